@@ -0,0 +1,206 @@
+// hlv drains nodes from their load balancers from an operator's machine, either through a
+// running hasta-la-vista server or, without -server, with the configured cloud provider and
+// local credentials
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/client"
+	"github.com/briankopp/hasta-la-vista/pkg/cloudproviders"
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Exit codes
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitTimedOut = 3
+)
+
+const usage = `Usage: hlv <command> [flags] <node>
+
+Commands:
+  drain     drain the node from its load balancers and wait for it to finish
+  undrain   register the node with its load balancers again
+  status    show the node's state at each load balancer and target group it is registered with
+  plan      show what draining the node would do, without changing anything
+  list-lbs  list the load balancers and target groups the node is registered with
+
+The node is an instance ID or private IP. Commands call the server given by -server or
+HLV_SERVER, or without one build the provider from -config or CONFIG_FILE and environment
+variables, using local cloud credentials. Run hlv <command> -h for a command's flags.
+`
+
+// options are the flags shared by every command
+type options struct {
+	server  string
+	token   string
+	config  string
+	output  string
+	verbose bool
+	timeout time.Duration
+	dryRun  bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	command := args[0]
+	var opts options
+	flags := flag.NewFlagSet("hlv "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.server, "server", os.Getenv("HLV_SERVER"), "hasta-la-vista server URL, defaults to HLV_SERVER")
+	flags.StringVar(&opts.token, "token", os.Getenv("HLV_TOKEN"), "bearer token for the server, defaults to HLV_TOKEN")
+	flags.StringVar(&opts.config, "config", os.Getenv("CONFIG_FILE"), "configuration file without a server, defaults to CONFIG_FILE")
+	flags.StringVar(&opts.output, "o", "table", "output format, table or json")
+	flags.BoolVar(&opts.verbose, "v", false, "log progress to stderr")
+	switch command {
+	case "drain":
+		flags.BoolVar(&opts.dryRun, "dry-run", false, "report what would be drained without draining")
+		flags.DurationVar(&opts.timeout, "timeout", 0, "how long to wait for each load balancer, overriding the configured timeout")
+	case "plan":
+		flags.DurationVar(&opts.timeout, "timeout", 0, "drain timeout to plan against, overriding the configured timeout")
+	case "undrain", "status", "list-lbs":
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, usage)
+		return exitUsage
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 || (opts.output != "table" && opts.output != "json") {
+		flags.Usage()
+		return exitUsage
+	}
+	nodeName := flags.Arg(0)
+
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	if opts.verbose {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: stderr})
+
+	provider, err := buildProvider(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitError
+	}
+
+	// an interrupt abandons waiting on the server, or stops retries with the library
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	go func() {
+		<-interrupted
+		cancel()
+	}()
+	ctx = deregister.WithOptions(ctx, deregister.DrainOptions{DryRun: opts.dryRun, Timeout: opts.timeout})
+
+	out := &printer{w: stdout, json: opts.output == "json"}
+	code, err := runCommand(ctx, command, provider, nodeName, out)
+	if err != nil {
+		if err == deregister.ErrNotSupported {
+			err = fmt.Errorf("%s is not supported by the cloud provider", command)
+		}
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitError
+	}
+	return code
+}
+
+func runCommand(ctx context.Context, command string, provider deregister.CloudProvider, nodeName string, out *printer) (int, error) {
+	switch command {
+	case "drain":
+		result, err := provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+		if err != nil {
+			return exitError, err
+		}
+		if err := out.drainResult(result); err != nil {
+			return exitError, err
+		}
+		if result.TimedOut() {
+			return exitTimedOut, nil
+		}
+		return exitOK, nil
+
+	case "undrain":
+		restorer, ok := provider.(deregister.Restorer)
+		if !ok {
+			return exitError, deregister.ErrNotSupported
+		}
+		if err := restorer.RestoreNodeToLoadBalancer(ctx, nodeName); err != nil {
+			return exitError, err
+		}
+		return exitOK, out.restored(nodeName)
+	}
+
+	if command == "plan" {
+		planner, ok := provider.(deregister.Planner)
+		if !ok {
+			return exitError, deregister.ErrNotSupported
+		}
+		plan, err := planner.PlanDrain(ctx, nodeName)
+		if err != nil {
+			return exitError, err
+		}
+		return exitOK, out.plan(plan)
+	}
+
+	lister, ok := provider.(deregister.RegistrationLister)
+	if !ok {
+		return exitError, deregister.ErrNotSupported
+	}
+	registrations, err := lister.ListRegistrations(ctx, nodeName)
+	if err != nil {
+		return exitError, err
+	}
+
+	if command == "status" {
+		err = out.status(registrations)
+	} else {
+		err = out.loadBalancers(registrations)
+	}
+	if err != nil {
+		return exitError, err
+	}
+	return exitOK, nil
+}
+
+// buildProvider returns a client for the server, or the configured provider when there is none
+func buildProvider(opts options) (deregister.CloudProvider, error) {
+	if opts.server != "" {
+		return &client.Client{URL: opts.server, Token: opts.token}, nil
+	}
+
+	cfg, err := config.LoadLambda(opts.config)
+	if err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, fmt.Errorf("%v\nset -server to use a hasta-la-vista server instead", err)
+		}
+		return nil, err
+	}
+	return cloudproviders.Build(cfg, metrics.Nop{})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/server"
+)
+
+// fakeProvider drains i-slow with a timeout and fails to drain i-broken
+type fakeProvider struct{}
+
+func (fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	if nodeName == "i-broken" {
+		return nil, errors.New("throttled")
+	}
+
+	result := &deregister.DrainResult{NodeID: nodeName, ClusterName: "prod", DryRun: deregister.OptionsFromContext(ctx).DryRun}
+	if result.DryRun {
+		result.Plan = &deregister.Plan{NodeID: nodeName, ClusterName: "prod", LoadBalancers: []deregister.PlannedLoadBalancer{
+			{Name: "tg-1", Type: "elbv2", State: "healthy", Action: deregister.PlanDeregister},
+		}}
+		return result, nil
+	}
+	result.LoadBalancers = []deregister.LoadBalancerResult{{Name: "tg-1", Type: "elbv2", Deregistered: true, TimedOut: nodeName == "i-slow"}}
+	return result, nil
+}
+
+func (fakeProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	return nil
+}
+
+func (fakeProvider) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	return &deregister.NodeRegistrations{NodeID: nodeName, ClusterName: "prod", Registrations: []deregister.Registration{
+		{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Port: 30080, State: "healthy"},
+		{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Port: 30443, State: "draining", Reason: "Target.DeregistrationInProgress"},
+		{LoadBalancer: "elb-1", Type: "elbv1", State: "InService"},
+	}}, nil
+}
+
+func TestRun(t *testing.T) {
+	api := &server.Server{
+		Provider:      fakeProvider{},
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ops": "ops-token"}),
+		MaxTimeout:    time.Hour,
+	}
+	svr := httptest.NewServer(api.Handler())
+	defer svr.Close()
+	remote := []string{"-server", svr.URL, "-token", "ops-token"}
+
+	cases := []struct {
+		Args     []string
+		Remote   bool
+		Code     int
+		Contains []string
+	}{
+		{Args: nil, Code: exitUsage},
+		{Args: []string{"destroy", "i-1"}, Code: exitUsage},
+		{Args: []string{"status", "-o", "yaml", "i-1"}, Code: exitUsage},
+		{Args: []string{"status"}, Code: exitUsage},
+		{Args: []string{"drain", "i-1"}, Remote: true, Code: exitOK, Contains: []string{"Drain of node i-1 in cluster prod", "tg-1"}},
+		{Args: []string{"drain", "i-slow"}, Remote: true, Code: exitTimedOut},
+		{Args: []string{"drain", "i-broken"}, Remote: true, Code: exitError},
+		{Args: []string{"drain", "-dry-run", "i-1"}, Remote: true, Code: exitOK, Contains: []string{"Draining node i-1 in cluster prod would take"}},
+		{Args: []string{"undrain", "i-1"}, Remote: true, Code: exitOK, Contains: []string{"Node i-1 registered with its load balancers"}},
+		{Args: []string{"plan", "-o", "json", "i-1"}, Remote: true, Code: exitOK, Contains: []string{`"action": "deregister"`}},
+		{Args: []string{"status", "i-1"}, Remote: true, Code: exitOK, Contains: []string{"Node i-1 in cluster prod", "30443", "Target.DeregistrationInProgress"}},
+		{Args: []string{"status", "-o", "json", "i-1"}, Remote: true, Code: exitOK, Contains: []string{`"targetGroup": "tg-1"`, `"state": "InService"`}},
+		{Args: []string{"list-lbs", "i-1"}, Remote: true, Code: exitOK, Contains: []string{"LOAD BALANCER   TARGET GROUP   TYPE", "elb-1"}},
+	}
+
+	for i, c := range cases {
+		args := c.Args
+		if c.Remote {
+			args = append(append([]string{args[0]}, remote...), args[1:]...)
+		}
+
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != c.Code {
+			t.Fatalf("%d failed - expected exit code %v, got %v: %s", i, c.Code, code, stderr.String())
+		}
+		for _, expected := range c.Contains {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Fatalf("%d failed - expected output to contain %q, got %q", i, expected, stdout.String())
+			}
+		}
+	}
+}
+
+func TestRunListsEachLoadBalancerOnce(t *testing.T) {
+	api := &server.Server{
+		Provider:      fakeProvider{},
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ops": "ops-token"}),
+	}
+	svr := httptest.NewServer(api.Handler())
+	defer svr.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"list-lbs", "-server", svr.URL, "-token", "ops-token", "-o", "json", "i-1"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("failed - expected exit code 0, got %v: %s", code, stderr.String())
+	}
+
+	var lbs []loadBalancer
+	if err := json.Unmarshal(stdout.Bytes(), &lbs); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if len(lbs) != 2 || lbs[0] != (loadBalancer{Name: "alb-1", TargetGroup: "tg-1", Type: "elbv2"}) || lbs[1].Name != "elb-1" {
+		t.Fatalf("failed - expected each load balancer once, got %+v", lbs)
+	}
+}
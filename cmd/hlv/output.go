@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// printer writes command results as aligned tables or JSON
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// loadBalancer is a load balancer, or one of its target groups, as listed by list-lbs
+type loadBalancer struct {
+	Name        string `json:"name"`
+	TargetGroup string `json:"targetGroup,omitempty"`
+	Type        string `json:"type"`
+}
+
+func (p *printer) drainResult(result *deregister.DrainResult) error {
+	if result.DryRun && result.Plan != nil {
+		return p.plan(result.Plan)
+	}
+	if p.json {
+		return p.writeJSON(result)
+	}
+
+	fmt.Fprintf(p.w, "Drain of node %s in cluster %s took %v\n\n", result.NodeID, result.ClusterName, result.Duration.Round(time.Second))
+	return p.table([]string{"LOAD BALANCER", "TYPE", "DEREGISTERED", "TIMED OUT"}, len(result.LoadBalancers), func(i int) []interface{} {
+		lb := result.LoadBalancers[i]
+		return []interface{}{lb.Name, lb.Type, yesNo(lb.Deregistered), yesNo(lb.TimedOut)}
+	})
+}
+
+func (p *printer) restored(nodeName string) error {
+	if p.json {
+		return p.writeJSON(map[string]interface{}{"node": nodeName, "restored": true})
+	}
+	_, err := fmt.Fprintf(p.w, "Node %s registered with its load balancers\n", nodeName)
+	return err
+}
+
+func (p *printer) status(registrations *deregister.NodeRegistrations) error {
+	if p.json {
+		return p.writeJSON(registrations)
+	}
+
+	fmt.Fprintf(p.w, "Node %s in cluster %s\n\n", registrations.NodeID, registrations.ClusterName)
+	return p.table([]string{"LOAD BALANCER", "TARGET GROUP", "TYPE", "PORT", "STATE", "REASON"}, len(registrations.Registrations), func(i int) []interface{} {
+		r := registrations.Registrations[i]
+		port := ""
+		if r.Port > 0 {
+			port = fmt.Sprint(r.Port)
+		}
+		return []interface{}{r.LoadBalancer, r.TargetGroup, r.Type, port, r.State, r.Reason}
+	})
+}
+
+func (p *printer) plan(plan *deregister.Plan) error {
+	if p.json {
+		return p.writeJSON(plan)
+	}
+
+	fmt.Fprintf(p.w, "Draining node %s in cluster %s would take about %v of the %v timeout\n\n",
+		plan.NodeID, plan.ClusterName, plan.EstimatedDuration, plan.Timeout)
+	return p.table([]string{"LOAD BALANCER", "TYPE", "STATE", "ACTION", "DELAY", "WOULD TIME OUT", "REASON"}, len(plan.LoadBalancers), func(i int) []interface{} {
+		lb := plan.LoadBalancers[i]
+		return []interface{}{lb.Name, lb.Type, lb.State, lb.Action, lb.DeregistrationDelay, yesNo(lb.WouldTimeOut), lb.Reason}
+	})
+}
+
+func (p *printer) loadBalancers(registrations *deregister.NodeRegistrations) error {
+	lbs := []loadBalancer{}
+	seen := map[loadBalancer]bool{}
+	for _, r := range registrations.Registrations {
+		lb := loadBalancer{Name: r.LoadBalancer, TargetGroup: r.TargetGroup, Type: r.Type}
+		if !seen[lb] {
+			seen[lb] = true
+			lbs = append(lbs, lb)
+		}
+	}
+	if p.json {
+		return p.writeJSON(lbs)
+	}
+
+	return p.table([]string{"LOAD BALANCER", "TARGET GROUP", "TYPE"}, len(lbs), func(i int) []interface{} {
+		return []interface{}{lbs[i].Name, lbs[i].TargetGroup, lbs[i].Type}
+	})
+}
+
+func (p *printer) table(headers []string, rows int, row func(i int) []interface{}) error {
+	w := tabwriter.NewWriter(p.w, 0, 4, 3, ' ', 0)
+	for i, header := range headers {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, header)
+	}
+	fmt.Fprintln(w)
+	for i := 0; i < rows; i++ {
+		for j, value := range row(i) {
+			if j > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, value)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func (p *printer) writeJSON(value interface{}) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
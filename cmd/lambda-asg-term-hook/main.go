@@ -14,15 +14,19 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
-	"github.com/aws/aws-sdk-go/service/elbv2"
-	awsProvider "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/aws"
-	"github.com/briankopp/hasta-la-vista/pkg/utils"
+	"github.com/briankopp/hasta-la-vista/pkg/cloudproviders"
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// cfg is loaded once per lambda container
+var cfg *config.Config
+
 // asgDetails struct is used for decoding the CW event
 type asgDetails struct {
 	LifecycleActionToken string `json:"LifecycleActionToken"`
@@ -58,22 +62,13 @@ func HandleSpotTerminationRequest(ctx context.Context, req events.CloudWatchEven
 		return errors.New("Cannot process LifecycleTransition if not autoscaling:EC2_INSTANCE_TERMINATING")
 	}
 
-	// Acquire AWS client
-	awsSession := session.Must(session.NewSession())
-	config := aws.Config{Region: aws.String(utils.GetAWSRegion())}
-	elbClient := elb.New(awsSession, &config)
-	elbV2Client := elbv2.New(awsSession, &config)
-	ec2Client := ec2.New(awsSession, &config)
-	timeout := utils.GetTimeout()
-	provider := &awsProvider.CloudProvider{
-		ELB:     elbClient,
-		ELBV2:   elbV2Client,
-		EC2:     ec2Client,
-		Timeout: timeout,
-		DryRun:  utils.IsDryRun(),
-	}
-
-	err = provider.DrainNodeFromLoadBalancer(details.EC2InstanceID)
+	drainer, err := cloudproviders.Build(cfg, buildRecorder(details.AutoscalingGroupName))
+	if err != nil {
+		log.Error().Err(err).Msg("Error building cloud provider")
+		return err
+	}
+
+	_, err = drainer.DrainNodeFromLoadBalancer(ctx, details.EC2InstanceID)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -83,7 +78,8 @@ func HandleSpotTerminationRequest(ctx context.Context, req events.CloudWatchEven
 
 	log.Info().Str("instanceId", details.EC2InstanceID).Msg("Successfully drained node from load balancer")
 
-	asgClient := autoscaling.New(awsSession, &config)
+	awsSession := session.Must(session.NewSession())
+	asgClient := autoscaling.New(awsSession, &aws.Config{Region: aws.String(cfg.AWSRegion())})
 	_, err = asgClient.CompleteLifecycleAction(
 		&autoscaling.CompleteLifecycleActionInput{
 			LifecycleActionResult: aws.String("CONTINUE"),
@@ -103,7 +99,24 @@ func HandleSpotTerminationRequest(ctx context.Context, req events.CloudWatchEven
 	return nil
 }
 
+// buildRecorder writes EMF metrics dimensioned by the ASG when logging in EMF format
+func buildRecorder(autoscalingGroupName string) metrics.Recorder {
+	if cfg.LogFormat != "emf" {
+		return metrics.Nop{}
+	}
+
+	return metrics.NewEMF(os.Stdout, cfg.EMFNamespace, map[string]string{
+		"AutoScalingGroupName": autoscalingGroupName,
+	})
+}
+
 func setupLogger() {
+	if cfg.LogFormat == "emf" {
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+		zerolog.SetGlobalLevel(cfg.Level())
+		return
+	}
+
 	output := zerolog.ConsoleWriter{
 		NoColor:    true,
 		Out:        os.Stdout,
@@ -127,10 +140,32 @@ func setupLogger() {
 	}
 
 	log.Logger = zerolog.New(output).With().Timestamp().Logger()
-	utils.SetLogLevel()
+	zerolog.SetGlobalLevel(cfg.Level())
+}
+
+// handleWithTracing runs the handler in a span, flushing spans before the invocation ends
+func handleWithTracing(ctx context.Context, req events.CloudWatchEvent) error {
+	ctx, span := tracing.Start(ctx, "HandleSpotTerminationRequest", trace.WithAttributes(
+		attribute.String("event.detailType", req.DetailType),
+	))
+	err := HandleSpotTerminationRequest(ctx, req)
+	tracing.End(span, err)
+	if flushErr := tracing.Flush(ctx); flushErr != nil {
+		log.Warn().Err(flushErr).Msg("error flushing traces")
+	}
+	return err
 }
 
 func main() {
+	var err error
+	cfg, err = config.LoadLambda(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
 	setupLogger()
-	lambda.Start(HandleSpotTerminationRequest)
+	if _, err := tracing.Setup(context.Background(), "lambda-asg-term-hook"); err != nil {
+		log.Error().Err(err).Msg("error setting up tracing, continuing without it")
+	}
+	lambda.Start(handleWithTracing)
 }
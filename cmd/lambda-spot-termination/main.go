@@ -11,17 +11,20 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
-	"github.com/aws/aws-sdk-go/service/elbv2"
-	awsProvider "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/aws"
-	"github.com/briankopp/hasta-la-vista/pkg/utils"
+	"github.com/briankopp/hasta-la-vista/pkg/cloudproviders"
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// cfg is loaded once per lambda container
+var cfg *config.Config
+
 // instanceDetail struct is used for decoding the CW event
 type instanceDetail struct {
 	InstanceID     string `json:"instance-id"`
@@ -54,22 +57,13 @@ func HandleSpotTerminationRequest(ctx context.Context, req events.CloudWatchEven
 		return errors.New("instance-action not terminate")
 	}
 
-	// Acquire AWS client
-	awsSession := session.Must(session.NewSession())
-	config := aws.Config{Region: aws.String(utils.GetAWSRegion())}
-	elbClient := elb.New(awsSession, &config)
-	elbV2Client := elbv2.New(awsSession, &config)
-	ec2Client := ec2.New(awsSession, &config)
-	timeout := utils.GetTimeout()
-	provider := &awsProvider.CloudProvider{
-		ELB:     elbClient,
-		ELBV2:   elbV2Client,
-		EC2:     ec2Client,
-		Timeout: timeout,
-		DryRun:  utils.IsDryRun(),
+	drainer, err := cloudproviders.Build(cfg, buildRecorder(ctx, details.InstanceID))
+	if err != nil {
+		log.Error().Err(err).Msg("Error building cloud provider")
+		return err
 	}
 
-	err = provider.DrainNodeFromLoadBalancer(details.InstanceID)
+	_, err = drainer.DrainNodeFromLoadBalancer(ctx, details.InstanceID)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -81,7 +75,44 @@ func HandleSpotTerminationRequest(ctx context.Context, req events.CloudWatchEven
 	return nil
 }
 
+// buildRecorder writes EMF metrics dimensioned by the instance's ASG when logging in EMF format
+func buildRecorder(ctx context.Context, instanceID string) metrics.Recorder {
+	if cfg.LogFormat != "emf" {
+		return metrics.Nop{}
+	}
+
+	dimensions := map[string]string{}
+	node, err := describeNode(ctx, instanceID)
+	if err != nil {
+		log.Warn().Err(err).Str("instanceId", instanceID).Msg("unable to find autoscaling group for metrics")
+	} else if node.AutoscalingGroup != "" {
+		dimensions["AutoScalingGroupName"] = node.AutoscalingGroup
+	}
+
+	return metrics.NewEMF(os.Stdout, cfg.EMFNamespace, dimensions)
+}
+
+// describeNode looks the instance up with an uninstrumented provider, as the recorder is not built yet
+func describeNode(ctx context.Context, instanceID string) (*deregister.NodeInfo, error) {
+	provider, err := deregister.New(cfg.ProviderConfig(metrics.Nop{}))
+	if err != nil {
+		return nil, err
+	}
+
+	describer, ok := provider.(deregister.NodeDescriber)
+	if !ok {
+		return nil, deregister.ErrNotSupported
+	}
+	return describer.DescribeNode(ctx, instanceID)
+}
+
 func setupLogger() {
+	if cfg.LogFormat == "emf" {
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+		zerolog.SetGlobalLevel(cfg.Level())
+		return
+	}
+
 	output := zerolog.ConsoleWriter{
 		NoColor:    true,
 		Out:        os.Stdout,
@@ -105,10 +136,32 @@ func setupLogger() {
 	}
 
 	log.Logger = zerolog.New(output).With().Timestamp().Logger()
-	utils.SetLogLevel()
+	zerolog.SetGlobalLevel(cfg.Level())
+}
+
+// handleWithTracing runs the handler in a span, flushing spans before the invocation ends
+func handleWithTracing(ctx context.Context, req events.CloudWatchEvent) error {
+	ctx, span := tracing.Start(ctx, "HandleSpotTerminationRequest", trace.WithAttributes(
+		attribute.String("event.detailType", req.DetailType),
+	))
+	err := HandleSpotTerminationRequest(ctx, req)
+	tracing.End(span, err)
+	if flushErr := tracing.Flush(ctx); flushErr != nil {
+		log.Warn().Err(flushErr).Msg("error flushing traces")
+	}
+	return err
 }
 
 func main() {
+	var err error
+	cfg, err = config.LoadLambda(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
 	setupLogger()
-	lambda.Start(HandleSpotTerminationRequest)
+	if _, err := tracing.Setup(context.Background(), "lambda-spot-termination"); err != nil {
+		log.Error().Err(err).Msg("error setting up tracing, continuing without it")
+	}
+	lambda.Start(handleWithTracing)
 }
@@ -1,11 +1,17 @@
 module github.com/briankopp/hasta-la-vista
 
-go 1.13
+go 1.16
 
 require (
 	github.com/aws/aws-lambda-go v1.16.0
 	github.com/aws/aws-sdk-go v1.30.4
+	github.com/prometheus/client_golang v1.11.1
 	github.com/rs/zerolog v1.18.0
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 replace github.com/briankopp/hasta-la-vista => ./
@@ -2,91 +2,156 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
-	"github.com/aws/aws-sdk-go/service/elbv2"
-	awsProvider "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/certs"
+	"github.com/briankopp/hasta-la-vista/pkg/cloudproviders"
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/consumer"
 	"github.com/briankopp/hasta-la-vista/pkg/deregister"
-	"github.com/briankopp/hasta-la-vista/pkg/utils"
+	"github.com/briankopp/hasta-la-vista/pkg/imds"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/policy"
+	"github.com/briankopp/hasta-la-vista/pkg/server"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-func buildCloudProvider(whichProvider string) (deregister.CloudProvider, error) {
-	if whichProvider == "aws" {
-		log.Info().Msg("building cloud provider for AWS")
-		awsSession := session.Must(session.NewSession())
-		config := aws.Config{Region: aws.String(utils.GetAWSRegion())}
-		elbClient := elb.New(awsSession, &config)
-		elbV2Client := elbv2.New(awsSession, &config)
-		ec2Client := ec2.New(awsSession, &config)
-		timeout := utils.GetTimeout()
-		provider := &awsProvider.CloudProvider{
-			ELB:     elbClient,
-			ELBV2:   elbV2Client,
-			EC2:     ec2Client,
-			Timeout: timeout,
-			DryRun:  utils.IsDryRun(),
+// hmacReplays outlives the authenticators rebuilt on each config reload, so a reload
+// does not allow signatures which were already used to be replayed
+var hmacReplays = auth.NewReplayCache()
+
+func buildAuthenticator(cfg *config.AuthConfig) (auth.Authenticator, error) {
+	switch cfg.Mode {
+	case "bearer":
+		log.Info().Msg("authenticating requests with bearer tokens")
+		return auth.NewBearerAuthenticator(cfg.Tokens), nil
+	case "hmac":
+		log.Info().Msg("authenticating requests with HMAC signatures")
+		return auth.NewHMACAuthenticator(cfg.HMACKeys, cfg.HMACMaxSkew.Duration(), hmacReplays), nil
+	case "tokenreview":
+		log.Info().Msg("authenticating requests with kubernetes token reviews")
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			return nil, err
 		}
-		return provider, nil
+		return &auth.TokenReviewAuthenticator{Client: client, Audiences: cfg.TokenReviewAudiences}, nil
+	case "clientcert":
+		log.Info().Msg("authenticating requests with TLS client certificates")
+		return &auth.ClientCertAuthenticator{AllowedNames: cfg.AllowedClients}, nil
 	}
 
-	return nil, errors.New("Unrecognized cloud provider")
+	return nil, errors.New("Unrecognized auth mode")
 }
 
-func main() {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	utils.SetLogLevel()
-	appSecret := utils.GetAppSecret()
-	whichProvider := utils.GetCloudProviderType()
-	provider, err := buildCloudProvider(whichProvider)
+// buildAPI builds the API for a configuration, so a reload can swap in a new one
+func buildAPI(store *config.Store, cfg *config.Config, prometheusMetrics *metrics.Prometheus) (http.Handler, error) {
+	provider, err := cloudproviders.Build(cfg, prometheusMetrics)
 	if err != nil {
-		log.Fatal().Err(err).Msg("error getting cloud provider")
+		return nil, err
+	}
+
+	authenticator, err := buildAuthenticator(&cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessPolicy *policy.Policy
+	if cfg.PolicyFile != "" {
+		accessPolicy, err = policy.Load(cfg.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("policyFile", cfg.PolicyFile).Int("rules", len(accessPolicy.Rules)).Msg("loaded access policy")
+	}
+
+	api := &server.Server{
+		Provider:      provider,
+		Authenticator: authenticator,
+		Policy:        accessPolicy,
+		DryRun:        cfg.DryRun,
+		MaxTimeout:    cfg.MaxTimeout.Duration(),
+		Metrics:       prometheusMetrics.Handler(),
+		Config:        store,
+	}
+	return api.Handler(), nil
+}
+
+func runServer(store *config.Store, prometheusMetrics *metrics.Prometheus, done <-chan os.Signal) {
+	cfg := store.Current()
+	handler, err := buildAPI(store, cfg, prometheusMetrics)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error building API")
 		os.Exit(1)
 	}
 
-	svr := &http.Server{Addr: fmt.Sprintf(":%v", 80)}
-	http.HandleFunc("/health", func(response http.ResponseWriter, request *http.Request) {
-		fmt.Fprint(response, "OK")
-	})
-	http.HandleFunc("/drain", func(response http.ResponseWriter, request *http.Request) {
-		if request.Method != "POST" {
-			log.Warn().Str("Method", request.Method).Msg("received /drain unallowed method")
-			response.Header().Set("Allow", "POST")
-			return
+	// In flight requests finish with the handler they started with
+	var active atomic.Value
+	active.Store(handler)
+	store.OnChange(func(previous *config.Config, current *config.Config) {
+		zerolog.SetGlobalLevel(current.Level())
+		if fields := config.RequiresRestart(previous, current); len(fields) > 0 {
+			log.Warn().Strs("settings", fields).Msg("changed settings take effect after a restart")
 		}
 
-		password := request.URL.Query().Get("pw")
-		if password == "" || password != appSecret {
-			log.Error().Msg("provided password invalid")
-			response.WriteHeader(403)
+		handler, err := buildAPI(store, current, prometheusMetrics)
+		if err != nil {
+			log.Error().Err(err).Msg("error applying reloaded configuration, keeping previous API")
 			return
 		}
+		active.Store(handler)
+	})
+
+	var reloader *certs.Reloader
+	if cfg.TLS.CertFile != "" {
+		clientAuth := tls.NoClientCert
+		if cfg.TLS.ClientCAFile != "" {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
 
-		nodeName := request.URL.Query().Get("node")
-		err := provider.DrainNodeFromLoadBalancer(nodeName)
+		reloader, err = certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, clientAuth)
 		if err != nil {
-			response.WriteHeader(500)
-			return
+			log.Fatal().Err(err).Msg("error loading TLS certificates")
+			os.Exit(1)
 		}
+	}
 
-		fmt.Fprint(response, "OK")
-	})
+	svr := &http.Server{Addr: cfg.Addr(), Handler: http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		active.Load().(http.Handler).ServeHTTP(response, request)
+	})}
 
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT)
-	log.Info().Msg("HTTP server started and listening on port 80")
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go store.Watch(watchCtx, 30*time.Second)
+	go func() {
+		var err error
+		if reloader != nil {
+			svr.TLSConfig = reloader.TLSConfig()
+			go reloader.Watch(watchCtx, 30*time.Second)
+			err = svr.ListenAndServeTLS("", "")
+		} else {
+			err = svr.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("error running http server")
+		}
+	}()
+	log.Info().Str("addr", cfg.Addr()).Bool("tls", reloader != nil).Msg("HTTP server started and listening")
 
 	// Wait for an OS signal
 	<-done
@@ -102,5 +167,104 @@ func main() {
 	}
 
 	log.Info().Msg("successfully closed the http server")
-	return
+}
+
+func runSQSConsumer(cfg *config.Config, provider deregister.CloudProvider, done <-chan os.Signal) {
+	awsSession := session.Must(session.NewSession())
+	awsConfig := aws.Config{Region: aws.String(cfg.AWSRegion())}
+	sqsConfig := awsConfig
+	if cfg.SQS.Endpoint != "" {
+		sqsConfig.Endpoint = aws.String(cfg.SQS.Endpoint)
+	}
+
+	c := &consumer.Consumer{
+		SQS:               sqs.New(awsSession, &sqsConfig),
+		Autoscaling:       autoscaling.New(awsSession, &awsConfig),
+		Provider:          provider,
+		QueueURL:          cfg.SQS.QueueURL,
+		VisibilityTimeout: 30 * time.Second,
+		WaitTime:          20 * time.Second,
+		MaxReceives:       cfg.SQS.MaxReceives,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	// Wait for an OS signal
+	<-done
+	log.Info().Msg("received signal, stopping sqs consumer")
+	cancel()
+	<-stopped
+	log.Info().Msg("successfully stopped the sqs consumer")
+}
+
+func runIMDSWatcher(cfg *config.Config, provider deregister.CloudProvider, done <-chan os.Signal) {
+	w := &imds.Watcher{
+		Metadata:               imds.NewClient(cfg.IMDS.Endpoint),
+		Provider:               provider,
+		PollInterval:           cfg.PollInterval.Duration(),
+		ScheduledEventLeadTime: cfg.IMDS.ScheduledEventLeadTime.Duration(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		if err := w.Run(ctx); err != nil {
+			log.Error().Err(err).Msg("error running metadata watcher")
+		}
+		close(stopped)
+	}()
+
+	// Keep running after a drain so the DaemonSet pod is not restarted
+	<-done
+	log.Info().Msg("received signal, stopping metadata watcher")
+	cancel()
+	<-stopped
+	log.Info().Msg("successfully stopped the metadata watcher")
+}
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	store, err := config.NewStore(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+		os.Exit(1)
+	}
+	cfg := store.Current()
+	zerolog.SetGlobalLevel(cfg.Level())
+	log.Info().Str("mode", cfg.Mode).Bool("dryRun", cfg.DryRun).Dur("timeout", cfg.Timeout.Duration()).Msg("loaded configuration")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "hasta-la-vista")
+	if err != nil {
+		log.Fatal().Err(err).Msg("error setting up tracing")
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(done, os.Interrupt, syscall.SIGINT)
+
+	prometheusMetrics := metrics.NewPrometheus()
+	if cfg.Mode == config.ModeServer {
+		runServer(store, prometheusMetrics, done)
+		return
+	}
+
+	provider, err := cloudproviders.Build(cfg, prometheusMetrics)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error getting cloud provider")
+		os.Exit(1)
+	}
+
+	switch cfg.Mode {
+	case config.ModeSQS:
+		runSQSConsumer(cfg, provider, done)
+	case config.ModeIMDS:
+		runIMDSWatcher(cfg, provider, done)
+	}
 }
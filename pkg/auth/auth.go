@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnauthenticated is returned when a request does not carry valid credentials
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name   string
+	Groups []string
+}
+
+// Authenticator verifies the credentials of an incoming request
+type Authenticator interface {
+	Authenticate(request *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of the context carrying the identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext gets the identity stored by the middleware, if any
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// Middleware rejects requests which fail authentication and
+// stores the caller identity on the request context
+func Middleware(authenticator Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		identity, err := authenticator.Authenticate(request)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("path", request.URL.Path).
+				Str("remoteAddr", request.RemoteAddr).
+				Msg("request failed authentication")
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		log.Debug().
+			Str("identity", identity.Name).
+			Str("path", request.URL.Path).
+			Msg("request authenticated")
+		next(response, request.WithContext(WithIdentity(request.Context(), identity)))
+	}
+}
+
+// bearerToken gets the token from an "Authorization: Bearer <token>" header
+func bearerToken(request *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := request.Header.Get("Authorization")
+	if len(header) <= len(prefix) || header[:len(prefix)] != prefix {
+		return "", false
+	}
+
+	return header[len(prefix):], true
+}
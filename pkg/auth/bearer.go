@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// BearerAuthenticator accepts requests carrying any of a set of static bearer tokens.
+// Several tokens may be active at once to allow rotation.
+type BearerAuthenticator struct {
+	tokens []namedToken
+}
+
+type namedToken struct {
+	name string
+	hash [sha256.Size]byte
+}
+
+// NewBearerAuthenticator creates an authenticator from a map of identity name to token
+func NewBearerAuthenticator(tokens map[string]string) *BearerAuthenticator {
+	a := &BearerAuthenticator{}
+	for name, token := range tokens {
+		if token == "" {
+			continue
+		}
+		a.tokens = append(a.tokens, namedToken{name: name, hash: sha256.Sum256([]byte(token))})
+	}
+	return a
+}
+
+// Authenticate checks the Authorization header against every configured token.
+// Tokens are hashed so comparisons are constant-time regardless of token length.
+func (a *BearerAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	token, ok := bearerToken(request)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	provided := sha256.Sum256([]byte(token))
+	var matched *namedToken
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare(provided[:], a.tokens[i].hash[:]) == 1 {
+			matched = &a.tokens[i]
+		}
+	}
+
+	if matched == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	return &Identity{Name: matched.name}, nil
+}
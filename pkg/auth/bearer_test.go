@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerAuthenticator(t *testing.T) {
+	authenticator := NewBearerAuthenticator(map[string]string{
+		"current":  "new-token",
+		"previous": "old-token",
+	})
+
+	cases := []struct {
+		Header   string
+		Query    string
+		Expected string
+	}{
+		{Header: "Bearer new-token", Expected: "current"},
+		{Header: "Bearer old-token", Expected: "previous"},
+		{Header: "Bearer wrong-token", Expected: ""},
+		{Header: "new-token", Expected: ""},
+		{Header: "", Query: "?pw=new-token", Expected: ""},
+	}
+
+	for i, c := range cases {
+		request := httptest.NewRequest("POST", "/drain"+c.Query, nil)
+		if c.Header != "" {
+			request.Header.Set("Authorization", c.Header)
+		}
+
+		identity, err := authenticator.Authenticate(request)
+		if c.Expected == "" {
+			if err == nil {
+				t.Fatalf("%d failed - expected authentication to fail, got %v", i, identity)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d failed - unexpected error %v", i, err)
+		}
+		if identity.Name != c.Expected {
+			t.Fatalf("%d failed - expected identity %v, got %v", i, c.Expected, identity.Name)
+		}
+	}
+}
+
+func TestMiddlewareStoresIdentity(t *testing.T) {
+	authenticator := NewBearerAuthenticator(map[string]string{"ops": "token"})
+	var seen *Identity
+	handler := Middleware(authenticator, func(w http.ResponseWriter, r *http.Request) {
+		seen = IdentityFromContext(r.Context())
+	})
+
+	request := httptest.NewRequest("POST", "/drain", nil)
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusUnauthorized || seen != nil {
+		t.Fatalf("failed - expected unauthenticated request to be rejected, got %v", recorder.Code)
+	}
+
+	request.Header.Set("Authorization", "Bearer token")
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusOK || seen == nil || seen.Name != "ops" {
+		t.Fatalf("failed - expected request to be authenticated as ops, got %v %v", recorder.Code, seen)
+	}
+}
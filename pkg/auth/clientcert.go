@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+// ClientCertAuthenticator identifies callers by their verified TLS client certificate.
+// If AllowedNames is not empty, the certificate subject common name or one of its
+// DNS, URI or email SANs must be in the list, and the matching name identifies the
+// caller. Otherwise the common name identifies the caller, so certificates without
+// one are rejected.
+type ClientCertAuthenticator struct {
+	AllowedNames []string
+}
+
+// Authenticate returns the first allowed name found on the client certificate
+func (a *ClientCertAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return nil, fmt.Errorf("%w: no verified client certificate", ErrUnauthenticated)
+	}
+
+	names := certificateNames(request.TLS.VerifiedChains[0][0])
+	if len(a.AllowedNames) == 0 {
+		if names[0] == "" {
+			return nil, fmt.Errorf("%w: client certificate has no common name", ErrUnauthenticated)
+		}
+		return &Identity{Name: names[0]}, nil
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, allowed := range a.AllowedNames {
+			if name == allowed {
+				return &Identity{Name: name}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: client certificate %q not allowed", ErrUnauthenticated, names)
+}
+
+// certificateNames lists the subject common name followed by the SANs of the certificate
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClientCertAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/bastion")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "bastion-01"},
+		DNSNames: []string{"bastion.example.org"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	cases := []struct {
+		Allowed  []string
+		Expected string
+	}{
+		{Allowed: nil, Expected: "bastion-01"},
+		{Allowed: []string{"bastion-01"}, Expected: "bastion-01"},
+		{Allowed: []string{"bastion.example.org"}, Expected: "bastion.example.org"},
+		{Allowed: []string{"spiffe://example.org/bastion"}, Expected: "spiffe://example.org/bastion"},
+		{Allowed: []string{"someone-else"}, Expected: ""},
+	}
+
+	for i, c := range cases {
+		authenticator := &ClientCertAuthenticator{AllowedNames: c.Allowed}
+		request := httptest.NewRequest("POST", "/drain", nil)
+		request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		identity, err := authenticator.Authenticate(request)
+		if c.Expected == "" {
+			if err == nil {
+				t.Fatalf("%d failed - expected certificate to be rejected", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d failed - unexpected error %v", i, err)
+		}
+		if identity.Name != c.Expected {
+			t.Fatalf("%d failed - expected identity %v, got %v", i, c.Expected, identity.Name)
+		}
+	}
+}
+
+func TestClientCertAuthenticatorWithoutCommonName(t *testing.T) {
+	cert := &x509.Certificate{DNSNames: []string{"bastion.example.org"}}
+	request := httptest.NewRequest("POST", "/drain", nil)
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	for _, allowed := range [][]string{nil, {""}} {
+		authenticator := &ClientCertAuthenticator{AllowedNames: allowed}
+		if identity, err := authenticator.Authenticate(request); err == nil {
+			t.Fatalf("failed - expected certificate without a common name to be rejected with %q, got %+v", allowed, identity)
+		}
+	}
+
+	authenticator := &ClientCertAuthenticator{AllowedNames: []string{"bastion.example.org"}}
+	identity, err := authenticator.Authenticate(request)
+	if err != nil || identity.Name != "bastion.example.org" {
+		t.Fatalf("failed - expected the allowed SAN to identify the caller, got %+v %v", identity, err)
+	}
+}
+
+func TestClientCertAuthenticatorRequiresTLS(t *testing.T) {
+	authenticator := &ClientCertAuthenticator{}
+	request := httptest.NewRequest("POST", "/drain", nil)
+	if _, err := authenticator.Authenticate(request); err == nil {
+		t.Fatalf("failed - expected plaintext request to be rejected")
+	}
+}
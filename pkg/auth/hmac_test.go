@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	authenticator := NewHMACAuthenticator(map[string]string{"bastion": "shared-secret"}, time.Minute, nil)
+	authenticator.Now = func() time.Time { return now }
+
+	request := httptest.NewRequest("POST", "/drain?node=i-0123456789", strings.NewReader("body"))
+	if err := SignRequest(request, "bastion", "shared-secret", now); err != nil {
+		t.Fatalf("failed - unexpected error signing %v", err)
+	}
+
+	identity, err := authenticator.Authenticate(request)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if identity.Name != "bastion" {
+		t.Fatalf("failed - expected identity bastion, got %v", identity.Name)
+	}
+
+	_, err = authenticator.Authenticate(request)
+	if err == nil {
+		t.Fatalf("failed - expected replayed request to be rejected")
+	}
+}
+
+func TestHMACAuthenticatorRejects(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cases := []struct {
+		Name   string
+		KeyID  string
+		Secret string
+		SignAt time.Time
+		Tamper func(query string) string
+	}{
+		{Name: "wrong secret", KeyID: "bastion", Secret: "other-secret", SignAt: now},
+		{Name: "unknown key", KeyID: "unknown", Secret: "shared-secret", SignAt: now},
+		{Name: "stale timestamp", KeyID: "bastion", Secret: "shared-secret", SignAt: now.Add(-2 * time.Minute)},
+		{Name: "future timestamp", KeyID: "bastion", Secret: "shared-secret", SignAt: now.Add(2 * time.Minute)},
+		{
+			Name: "tampered query", KeyID: "bastion", Secret: "shared-secret", SignAt: now,
+			Tamper: func(query string) string { return "node=i-other" },
+		},
+	}
+
+	for _, c := range cases {
+		authenticator := NewHMACAuthenticator(map[string]string{"bastion": "shared-secret"}, time.Minute, nil)
+		authenticator.Now = func() time.Time { return now }
+
+		request := httptest.NewRequest("POST", "/drain?node=i-0123456789", nil)
+		if err := SignRequest(request, c.KeyID, c.Secret, c.SignAt); err != nil {
+			t.Fatalf("%s failed - unexpected error signing %v", c.Name, err)
+		}
+		if c.Tamper != nil {
+			request.URL.RawQuery = c.Tamper(request.URL.RawQuery)
+		}
+
+		if _, err := authenticator.Authenticate(request); err == nil {
+			t.Fatalf("%s failed - expected request to be rejected", c.Name)
+		}
+	}
+}
+
+func TestHMACReplayCacheOutlivesAuthenticator(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	replays := NewReplayCache()
+
+	request := httptest.NewRequest("POST", "/drain?node=i-0123456789", nil)
+	if err := SignRequest(request, "bastion", "shared-secret", now); err != nil {
+		t.Fatalf("failed - unexpected error signing %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		// a reload builds a new authenticator sharing the cache
+		authenticator := NewHMACAuthenticator(map[string]string{"bastion": "shared-secret"}, time.Minute, replays)
+		authenticator.Now = func() time.Time { return now }
+		_, err := authenticator.Authenticate(request)
+		if i == 0 && err != nil {
+			t.Fatalf("failed - unexpected error %v", err)
+		}
+		if i == 1 && err == nil {
+			t.Fatal("failed - expected the request to be rejected as a replay after a rebuild")
+		}
+	}
+}
+
+func TestHMACAuthenticatorLimitsBody(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	authenticator := NewHMACAuthenticator(map[string]string{"bastion": "shared-secret"}, time.Minute, nil)
+	authenticator.Now = func() time.Time { return now }
+
+	request := httptest.NewRequest("POST", "/drain", strings.NewReader(strings.Repeat("a", MaxSignedBodyBytes+1)))
+	if err := SignRequest(request, "bastion", "shared-secret", now); err != nil {
+		t.Fatalf("failed - unexpected error signing %v", err)
+	}
+	if _, err := authenticator.Authenticate(request); err == nil {
+		t.Fatal("failed - expected an oversized body to be rejected")
+	}
+}
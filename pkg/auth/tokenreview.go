@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+const tokenReviewPath = "/apis/authentication.k8s.io/v1/tokenreviews"
+
+type tokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       tokenReviewSpec   `json:"spec"`
+	Status     tokenReviewStatus `json:"status,omitempty"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool   `json:"authenticated"`
+	Error         string `json:"error,omitempty"`
+	User          struct {
+		Username string   `json:"username"`
+		Groups   []string `json:"groups"`
+	} `json:"user"`
+}
+
+// TokenReviewAuthenticator accepts Kubernetes service account tokens, validated
+// by the API server with a TokenReview
+type TokenReviewAuthenticator struct {
+	Client    *kube.Client
+	Audiences []string
+}
+
+// Authenticate submits the bearer token for review and returns the reviewed user
+func (a *TokenReviewAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	token, ok := bearerToken(request)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	review := &tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token, Audiences: a.Audiences},
+	}
+
+	var result tokenReview
+	if err := a.Client.Do("POST", tokenReviewPath, "", review, &result); err != nil {
+		return nil, err
+	}
+
+	if !result.Status.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, result.Status.Error)
+	}
+
+	return &Identity{
+		Name:   result.Status.User.Username,
+		Groups: result.Status.User.Groups,
+	}, nil
+}
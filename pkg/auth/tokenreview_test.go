@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tokenReviewPath || r.Header.Get("Authorization") != "Bearer reviewer-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var review tokenReview
+		json.NewDecoder(r.Body).Decode(&review)
+		if review.Spec.Token == "valid-token" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = "system:serviceaccount:ops:drainer"
+			review.Status.User.Groups = []string{"system:serviceaccounts"}
+		}
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer server.Close()
+
+	authenticator := &TokenReviewAuthenticator{
+		Client: &kube.Client{Host: server.URL, BearerToken: "reviewer-token"},
+	}
+
+	request := httptest.NewRequest("POST", "/drain", nil)
+	request.Header.Set("Authorization", "Bearer valid-token")
+	identity, err := authenticator.Authenticate(request)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if identity.Name != "system:serviceaccount:ops:drainer" || len(identity.Groups) != 1 {
+		t.Fatalf("failed - unexpected identity %v", identity)
+	}
+
+	request.Header.Set("Authorization", "Bearer invalid-token")
+	if _, err := authenticator.Authenticate(request); err == nil {
+		t.Fatalf("failed - expected invalid token to be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or self-signed if parent is nil
+func newTestCert(t *testing.T, commonName string, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestReloaderPicksUpNewCertificate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	first := newTestCert(t, "first", false, nil)
+	writeFile(t, certFile, first.certPEM)
+	writeFile(t, keyFile, first.keyPEM)
+
+	reloader, err := NewReloader(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	second := newTestCert(t, "second", false, nil)
+	writeFile(t, certFile, second.certPEM)
+	writeFile(t, keyFile, second.keyPEM)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	if !reloader.changed() {
+		t.Fatalf("failed - expected certificate change to be detected")
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("failed - unexpected error reloading %v", err)
+	}
+
+	config, _ := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	leaf, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Fatalf("failed - expected reloaded certificate, got %v", leaf.Subject.CommonName)
+	}
+}
+
+func TestReloaderKeepsCertificateOnInvalidFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	first := newTestCert(t, "first", false, nil)
+	writeFile(t, certFile, first.certPEM)
+	writeFile(t, keyFile, first.keyPEM)
+	reloader, err := NewReloader(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	writeFile(t, certFile, []byte("not a certificate"))
+	if err := reloader.Reload(); err == nil {
+		t.Fatalf("failed - expected invalid certificate to fail reload")
+	}
+
+	config, _ := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	leaf, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if leaf.Subject.CommonName != "first" {
+		t.Fatalf("failed - expected previous certificate to be kept, got %v", leaf.Subject.CommonName)
+	}
+}
+
+func TestReloaderVerifiesClientCertificates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := newTestCert(t, "test-ca", true, nil)
+	server := newTestCert(t, "localhost", false, ca)
+	client := newTestCert(t, "bastion", false, ca)
+	writeFile(t, certFile, server.certPEM)
+	writeFile(t, keyFile, server.keyPEM)
+	writeFile(t, caFile, ca.certPEM)
+
+	reloader, err := NewReloader(certFile, keyFile, caFile, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	ts.TLS = reloader.TLSConfig()
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientPair, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientPair},
+	}}}
+	resp, err := withCert.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "bastion" {
+		t.Fatalf("failed - expected client certificate bastion, got %s", body)
+	}
+
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	}}}
+	if resp, err := withoutCert.Get(ts.URL); err == nil {
+		resp.Body.Close()
+		t.Fatalf("failed - expected request without client certificate to fail")
+	}
+}
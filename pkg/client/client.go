@@ -0,0 +1,147 @@
+// Package client calls a running hasta-la-vista server. Client implements the same provider
+// interfaces as the cloud providers, so callers can drain through the API or the library alike
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// StatusError is returned when the server responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("server returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Client drains nodes through the server API. It implements the CloudProvider, Restorer,
+// Planner and RegistrationLister interfaces
+type Client struct {
+	// URL is the server's base URL, such as https://hasta-la-vista.example.com
+	URL string
+	// Token is sent as a bearer token if set
+	Token string
+	HTTP  *http.Client
+}
+
+// DrainNodeFromLoadBalancer drains the node, passing the dry-run and timeout options in the
+// context to the server
+func (c *Client) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	options := deregister.OptionsFromContext(ctx)
+	query := url.Values{"node": {nodeName}}
+	if options.DryRun {
+		query.Set("dryRun", "true")
+	}
+	if options.Timeout > 0 {
+		query.Set("timeout", options.Timeout.String())
+	}
+
+	body, err := c.post(ctx, "/drain", query)
+	if err != nil {
+		return nil, err
+	}
+
+	// servers which predate JSON drain results answer OK
+	result := &deregister.DrainResult{NodeID: nodeName}
+	if strings.TrimSpace(string(body)) == "OK" {
+		return result, nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("error decoding drain result: %v", err)
+	}
+	return result, nil
+}
+
+// RestoreNodeToLoadBalancer registers the node with its load balancers again, returning
+// deregister.ErrNotSupported if the server's provider cannot
+func (c *Client) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	_, err := c.post(ctx, "/undrain", url.Values{"node": {nodeName}})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotImplemented {
+		return deregister.ErrNotSupported
+	}
+	return err
+}
+
+// PlanDrain asks the server for a dry-run drain and returns its plan
+func (c *Client) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	options := deregister.OptionsFromContext(ctx)
+	options.DryRun = true
+	result, err := c.DrainNodeFromLoadBalancer(deregister.WithOptions(ctx, options), nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if result.Plan == nil {
+		return nil, deregister.ErrNotSupported
+	}
+	return result.Plan, nil
+}
+
+// ListRegistrations lists the load balancers and target groups the node is registered with,
+// returning deregister.ErrNotSupported if the server's provider cannot
+func (c *Client) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	body, err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(nodeName)+"/registrations", url.Values{})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotImplemented {
+		return nil, deregister.ErrNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := &deregister.NodeRegistrations{}
+	if err := json.Unmarshal(body, registrations); err != nil {
+		return nil, fmt.Errorf("error decoding registrations: %v", err)
+	}
+	return registrations, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	return c.do(ctx, "POST", path, query)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values) ([]byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/server"
+)
+
+type fakeProvider struct {
+	options  deregister.DrainOptions
+	restored []string
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	p.options = deregister.OptionsFromContext(ctx)
+	if nodeName == "i-broken" {
+		return nil, errors.New("throttled")
+	}
+	result := &deregister.DrainResult{NodeID: nodeName, ClusterName: "clustername", DryRun: p.options.DryRun}
+	if p.options.DryRun {
+		result.Plan = &deregister.Plan{NodeID: nodeName, LoadBalancers: []deregister.PlannedLoadBalancer{{Name: "elb-1", Action: deregister.PlanDeregister}}}
+	}
+	return result, nil
+}
+
+func (p *fakeProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	p.restored = append(p.restored, nodeName)
+	return nil
+}
+
+func (p *fakeProvider) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	return &deregister.NodeRegistrations{NodeID: nodeName, ClusterName: "clustername", Registrations: []deregister.Registration{
+		{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Port: 30080, State: "healthy"},
+	}}, nil
+}
+
+func newTestClient(provider deregister.CloudProvider) (*Client, func()) {
+	api := &server.Server{
+		Provider:      provider,
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ops": "ops-token"}),
+		MaxTimeout:    time.Hour,
+	}
+	svr := httptest.NewServer(api.Handler())
+	return &Client{URL: svr.URL + "/", Token: "ops-token"}, svr.Close
+}
+
+func TestDrainThroughServer(t *testing.T) {
+	provider := &fakeProvider{}
+	c, closeServer := newTestClient(provider)
+	defer closeServer()
+
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{Timeout: 90 * time.Second})
+	result, err := c.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || result.NodeID != "i-1" || result.ClusterName != "clustername" {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if provider.options.Timeout != 90*time.Second || provider.options.DryRun {
+		t.Fatalf("failed - expected the timeout to reach the provider, got %+v", provider.options)
+	}
+
+	plan, err := c.PlanDrain(context.Background(), "i-1")
+	if err != nil || len(plan.LoadBalancers) != 1 || !provider.options.DryRun {
+		t.Fatalf("failed - expected a dry-run plan, got %+v %v", plan, err)
+	}
+
+	if err := c.RestoreNodeToLoadBalancer(context.Background(), "i-1"); err != nil || len(provider.restored) != 1 {
+		t.Fatalf("failed - expected the node to be restored, got %v", err)
+	}
+
+	registrations, err := c.ListRegistrations(context.Background(), "i-1")
+	if err != nil || registrations.NodeID != "i-1" || len(registrations.Registrations) != 1 || registrations.Registrations[0].TargetGroup != "tg-1" {
+		t.Fatalf("failed - unexpected registrations %+v %v", registrations, err)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	c, closeServer := newTestClient(&fakeProvider{})
+	defer closeServer()
+
+	_, err := c.DrainNodeFromLoadBalancer(context.Background(), "i-broken")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 500 {
+		t.Fatalf("failed - expected a 500, got %v", err)
+	}
+
+	c.Token = "wrong"
+	if _, err := c.DrainNodeFromLoadBalancer(context.Background(), "i-1"); !errors.As(err, &statusErr) || statusErr.StatusCode != 401 {
+		t.Fatalf("failed - expected a 401, got %v", err)
+	}
+}
+
+type drainOnlyProvider struct{}
+
+func (drainOnlyProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	return &deregister.DrainResult{NodeID: nodeName}, nil
+}
+
+func TestUndrainNotSupported(t *testing.T) {
+	c, closeServer := newTestClient(drainOnlyProvider{})
+	defer closeServer()
+
+	if err := c.RestoreNodeToLoadBalancer(context.Background(), "i-1"); err != deregister.ErrNotSupported {
+		t.Fatalf("failed - expected undrain to be unsupported, got %v", err)
+	}
+	// a dry-run without a plan cannot be planned from
+	if _, err := c.PlanDrain(context.Background(), "i-1"); err != deregister.ErrNotSupported {
+		t.Fatalf("failed - expected planning to be unsupported, got %v", err)
+	}
+	if _, err := c.ListRegistrations(context.Background(), "i-1"); err != deregister.ErrNotSupported {
+		t.Fatalf("failed - expected listing registrations to be unsupported, got %v", err)
+	}
+}
@@ -1,14 +1,19 @@
 package aws
 
 import (
+	"context"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MyEC2API is a subset of the AWS EC2 API interface
@@ -20,8 +25,10 @@ type MyEC2API interface {
 type MyELBAPI interface {
 	DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error)
 	DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+	DescribeLoadBalancerAttributes(input *elb.DescribeLoadBalancerAttributesInput) (*elb.DescribeLoadBalancerAttributesOutput, error)
 	DescribeLoadBalancers(input *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error)
 	DescribeTags(input *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error)
+	RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error)
 }
 
 // MyELBV2API is a subset of the AWS ELBV2 API interface
@@ -30,153 +37,258 @@ type MyELBV2API interface {
 	DescribeListeners(input *elbv2.DescribeListenersInput) (*elbv2.DescribeListenersOutput, error)
 	DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error)
 	DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error)
+	DescribeTargetGroupAttributes(input *elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error)
 	DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error)
+	RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error)
 }
 
 // CloudProvider is a wrapper around the required interfaces
 // to allow for mocking. It implements the CloudProvider interface
 type CloudProvider struct {
-	EC2     MyEC2API
-	ELB     MyELBAPI
-	ELBV2   MyELBV2API
-	Timeout time.Duration
-	DryRun  bool
+	deregister.Base
+	EC2   MyEC2API
+	ELB   MyELBAPI
+	ELBV2 MyELBV2API
+
+	// TagSelectors are tags load balancers must carry in addition to the cluster
+	// tag to be drained. An empty value matches any value
+	TagSelectors map[string]string
+
+	// exclude, when set, asks the cloud controller manager to remove the node instead of
+	// deregistering it, leaving the drain to wait for the load balancers to report it gone
+	exclude func(ctx context.Context, nodeID string) error
 }
 
 // DrainNodeFromLoadBalancer drains the node from both ELB and ELBV2 load balancers in AWS land
-func (m *CloudProvider) DrainNodeFromLoadBalancer(nodeName string) error {
+func (m *CloudProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
 	log.Info().
 		Str("nodeName", nodeName).
 		Msg("handling deregistration for node")
-	nodeID := nodeName
-	if !strings.HasPrefix(nodeName, "i-") {
-		// get node ID from hostname
-		nodeIDFromHostname, err := m.getNodeIDFromIP(nodeName)
-		if err != nil {
-			return err
-		}
+	start := m.Now()
+	result, err := m.drainNode(ctx, nodeName)
+	result.Duration = m.Since(start)
+	return result, err
+}
 
-		nodeID = *nodeIDFromHostname
+func (m *CloudProvider) drainNode(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result := &deregister.DrainResult{}
+	nodeID, err := m.resolveNodeID(ctx, nodeName)
+	if err != nil {
+		return result, err
 	}
+	result.NodeID = nodeID
 
-	vpcID, clusterName, err := m.GetVPCAndClusterFromInstance(nodeID)
+	vpcID, cluster, err := m.GetVPCAndClusterFromInstance(ctx, nodeID)
 	if err != nil {
-		return err
+		return result, err
+	}
+	result.ClusterName = *cluster
+
+	if m.exclude != nil {
+		if err := m.exclude(ctx, nodeID); err != nil {
+			return result, err
+		}
 	}
 
 	var wg sync.WaitGroup
+	var v1Results, v2Results []deregister.LoadBalancerResult
+	var v1Err, v2Err error
 	wg.Add(2)
 	log.Info().Msg("beginning drain operations")
 	go func() {
-		err := m.drainNodeFromELBV1sInCluster(nodeID, *vpcID, *clusterName)
-		if err != nil {
+		defer wg.Done()
+		v1Results, v1Err = m.drainNodeFromELBV1sInCluster(ctx, nodeID, *vpcID, *cluster)
+		if v1Err != nil {
 			log.Error().
-				Err(err).
+				Err(v1Err).
 				Str("nodeID", nodeID).
 				Msg("error occurred draining node from all v1 ELBs")
-			wg.Done()
 			return
 		}
 		log.Info().Str("nodeID", nodeID).Msg("completed drain for all v1 ELBs")
-		wg.Done()
 	}()
 
 	go func() {
-		err := m.drainNodeFromELBV2sInCluster(nodeID, *vpcID, *clusterName)
-		if err != nil {
+		defer wg.Done()
+		v2Results, v2Err = m.drainNodeFromELBV2sInCluster(ctx, nodeID, *vpcID, *cluster)
+		if v2Err != nil {
 			log.Error().
-				Err(err).
+				Err(v2Err).
 				Str("nodeID", nodeID).
 				Msg("error occurred draining node from all v2 ELBs")
-			wg.Done()
 			return
 		}
 		log.Info().Str("nodeID", nodeID).Msg("completed drained for all v2 ELBs")
-		wg.Done()
 	}()
 
 	wg.Wait()
-	return nil // TODO report error
+	result.LoadBalancers = append(v1Results, v2Results...)
+	if v1Err != nil {
+		return result, v1Err
+	}
+	return result, v2Err
 }
 
-func (m *CloudProvider) drainNodeFromELBV1sInCluster(nodeID string, vpcID string, clusterName string) error {
-	elbV1Names, err := m.getELBV1s(vpcID, clusterName)
+// RestoreNodeToLoadBalancer registers the node with every ELB and v2 target group in its cluster
+func (m *CloudProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling registration for node")
+	nodeID, err := m.resolveNodeID(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	vpcID, clusterName, err := m.GetVPCAndClusterFromInstance(ctx, nodeID)
 	if err != nil {
 		return err
 	}
 
+	err = m.registerNodeWithELBV1sInCluster(ctx, nodeID, *vpcID, *clusterName)
+	if err != nil {
+		return err
+	}
+
+	return m.registerNodeWithELBV2sInCluster(ctx, nodeID, *vpcID, *clusterName)
+}
+
+// resolveNodeID returns the instance ID of a node given either its ID or private IP
+func (m *CloudProvider) resolveNodeID(ctx context.Context, nodeName string) (string, error) {
+	if strings.HasPrefix(nodeName, "i-") {
+		return nodeName, nil
+	}
+
+	// get node ID from hostname
+	nodeIDFromHostname, err := m.getNodeIDFromIP(ctx, nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	return *nodeIDFromHostname, nil
+}
+
+func (m *CloudProvider) drainNodeFromELBV1sInCluster(ctx context.Context, nodeID string, vpcID string, clusterName string) ([]deregister.LoadBalancerResult, error) {
+	elbV1Names, err := m.getELBV1s(ctx, vpcID, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
 	var wg sync.WaitGroup
-	for _, elbV1Name := range elbV1Names {
+	results := make([]deregister.LoadBalancerResult, len(elbV1Names))
+	for i, elbV1Name := range elbV1Names {
 		wg.Add(1)
-		start := time.Now()
-		go func(name string) {
-			for {
+		start := m.Now()
+		results[i] = deregister.LoadBalancerResult{Name: elbV1Name, Type: metrics.LoadBalancerELBV1}
+		go func(name string, result *deregister.LoadBalancerResult) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "pollELBV1", trace.WithAttributes(attribute.String("elb.name", name)))
+			defer span.End()
+			for polls := 1; ; polls++ {
+				span.SetAttributes(attribute.Int("polls", polls))
 				log.Debug().
 					Str("elbName", name).
 					Str("nodeID", nodeID).
 					Msg("draining node from ELB v1")
 
-				drained, _ := m.drainNodeFromELBV1(nodeID, name)
+				drained, deregistered, err := m.drainNodeFromELBV1(ctx, nodeID, name)
+				if err != nil {
+					log.Warn().Err(err).Str("elbName", name).Str("nodeID", nodeID).Msg("error checking drain progress")
+				}
+				result.Deregistered = result.Deregistered || deregistered
 
 				if drained {
-					wg.Done()
+					m.Recorder().LoadBalancerDrained(clusterName, metrics.LoadBalancerELBV1, m.Since(start), false)
 					break
 				}
 
-				if time.Since(start) > m.Timeout {
+				if m.Since(start) > m.DrainTimeout(ctx) {
 					log.Warn().
 						Str("elbName", name).
 						Str("nodeID", nodeID).
-						Msg("node did not drain within 120s")
-					wg.Done()
+						Dur("timeout", m.DrainTimeout(ctx)).
+						Msg("node did not drain within timeout")
+					m.Recorder().LoadBalancerDrained(clusterName, metrics.LoadBalancerELBV1, m.Since(start), true)
+					span.SetAttributes(attribute.Bool("timedOut", true))
+					result.TimedOut = true
 					break
 				}
 
-				time.Sleep(5 * time.Second)
+				if m.Wait(ctx) != nil {
+					break
+				}
 			}
-		}(elbV1Name)
+		}(elbV1Name, &results[i])
 	}
 	wg.Wait()
-	return nil
+	return results, ctx.Err()
 }
 
-func (m *CloudProvider) drainNodeFromELBV2sInCluster(nodeID string, vpcID string, clusterName string) error {
-	targetGroupARNs, err := m.getELBV2TargetGroupARNsInCluster(vpcID, clusterName)
+func (m *CloudProvider) drainNodeFromELBV2sInCluster(ctx context.Context, nodeID string, vpcID string, clusterName string) ([]deregister.LoadBalancerResult, error) {
+	targetGroupARNs, err := m.getELBV2TargetGroupARNsInCluster(ctx, vpcID, clusterName)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	var wg sync.WaitGroup
-	for _, targetGroupARN := range targetGroupARNs {
+	results := make([]deregister.LoadBalancerResult, len(targetGroupARNs))
+	for i, targetGroupARN := range targetGroupARNs {
 		wg.Add(1)
-		start := time.Now()
-		go func(arn string) {
-			for {
-				drained, _ := m.nodeDrainedFromELBV2TargetGroup(nodeID, arn)
+		start := m.Now()
+		results[i] = deregister.LoadBalancerResult{Name: targetGroupARN, Type: metrics.LoadBalancerELBV2}
+		go func(arn string, result *deregister.LoadBalancerResult) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "pollELBV2TargetGroup", trace.WithAttributes(attribute.String("targetGroup.arn", arn)))
+			defer span.End()
+			for polls := 1; ; polls++ {
+				span.SetAttributes(attribute.Int("polls", polls))
+				drained, deregistered, err := m.nodeDrainedFromELBV2TargetGroup(ctx, nodeID, arn)
+				if err != nil {
+					log.Warn().Err(err).Str("elbArn", arn).Str("nodeID", nodeID).Msg("error checking drain progress")
+				}
+				result.Deregistered = result.Deregistered || deregistered
 				log.Debug().
 					Str("elbArn", arn).
 					Str("nodeID", nodeID).
 					Msg("draining node from ELB v2")
 
 				if drained {
-					wg.Done()
+					m.Recorder().LoadBalancerDrained(clusterName, metrics.LoadBalancerELBV2, m.Since(start), false)
 					break
 				}
 
-				if time.Since(start) > m.Timeout {
+				if m.Since(start) > m.DrainTimeout(ctx) {
 					log.Warn().
 						Str("elbArn", arn).
 						Str("nodeID", nodeID).
-						Msg("node did not drain within 120s")
-					wg.Done()
+						Dur("timeout", m.DrainTimeout(ctx)).
+						Msg("node did not drain within timeout")
+					m.Recorder().LoadBalancerDrained(clusterName, metrics.LoadBalancerELBV2, m.Since(start), true)
+					span.SetAttributes(attribute.Bool("timedOut", true))
+					result.TimedOut = true
 					break
 				}
 
-				time.Sleep(5 * time.Second)
+				if m.Wait(ctx) != nil {
+					break
+				}
 			}
-		}(targetGroupARN)
+		}(targetGroupARN, &results[i])
 	}
 
 	wg.Wait()
-	return nil
+	return results, ctx.Err()
+}
+
+// matchesTags reports whether a load balancer's tags include the cluster tag and every tag selector
+func (m *CloudProvider) matchesTags(tags map[string]string, clusterTag string) bool {
+	if _, ok := tags[clusterTag]; !ok {
+		return false
+	}
+	for key, value := range m.TagSelectors {
+		actual, ok := tags[key]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,72 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestDrainRecordsErrorOutcome(t *testing.T) {
+	recorder := &fakeRecorder{}
+	clients, _ := deregister.Wrap(&CloudProvider{
+		Base: deregister.Base{Metrics: recorder},
+		EC2:  &fakeEC2{err: errors.New("throttled")},
+	}, deregister.WithMetrics(recorder))
+
+	_, err := clients.DrainNodeFromLoadBalancer(context.Background(), "i-0123456789")
+	if err == nil {
+		t.Fatalf("failed - expected error to be returned")
+	}
+	if len(recorder.outcomes) != 1 || recorder.outcomes[0] != "error" {
+		t.Fatalf("failed - expected a single error outcome, got %v", recorder.outcomes)
+	}
+	if recorder.inFlight != 0 {
+		t.Fatalf("failed - expected no drains in flight, got %v", recorder.inFlight)
+	}
+	if len(recorder.apiErrors) != 1 || recorder.apiErrors[0] != "ec2.DescribeInstances" {
+		t.Fatalf("failed - expected failed ec2.DescribeInstances call, got %v", recorder.apiErrors)
+	}
+}
+
+func TestDrainRecordsSpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	clients, _ := deregister.Wrap(&CloudProvider{
+		EC2: &fakeEC2{err: errors.New("throttled")},
+	}, deregister.WithTracing())
+	clients.DrainNodeFromLoadBalancer(context.Background(), "10.0.0.1")
+
+	ended := spans.Ended()
+	names := []string{}
+	for _, span := range ended {
+		names = append(names, span.Name())
+	}
+
+	expected := []string{"ec2.DescribeInstances", "getNodeIDFromIP", "DrainNodeFromLoadBalancer"}
+	if len(names) != len(expected) {
+		t.Fatalf("failed - expected spans %v, got %v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Fatalf("failed - expected spans %v, got %v", expected, names)
+		}
+	}
+
+	root := ended[len(ended)-1]
+	if root.Status().Code != codes.Error {
+		t.Fatalf("failed - expected drain span to record the error, got %v", root.Status())
+	}
+	if ended[0].Parent().SpanID() != ended[1].SpanContext().SpanID() {
+		t.Fatalf("failed - expected api call span to be a child of getNodeIDFromIP")
+	}
+}
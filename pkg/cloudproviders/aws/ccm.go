@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+	"github.com/rs/zerolog/log"
+)
+
+// KubernetesAPI is the subset of the Kubernetes client the CCM provider uses
+type KubernetesAPI interface {
+	GetNode(nodeName string) (*kube.Node, error)
+	ExcludeFromLoadBalancers(n *kube.Node) (bool, error)
+	IncludeInLoadBalancers(n *kube.Node) (bool, error)
+	Cordon(n *kube.Node) (bool, error)
+	Uncordon(n *kube.Node) (bool, error)
+	AddTaint(n *kube.Node, key string) (bool, error)
+	RemoveTaint(n *kube.Node, key string) (bool, error)
+}
+
+// CCMProvider drains nodes by labelling them to be excluded from external load balancers,
+// then polls ELB and ELBV2 health like CloudProvider until the cloud controller manager
+// has removed them. It never deregisters nodes itself. It implements the CloudProvider interface
+type CCMProvider struct {
+	AWS        *CloudProvider
+	Kubernetes KubernetesAPI
+
+	// Cordon marks the node unschedulable as well
+	Cordon bool
+
+	// Taint, when set, is added to the node as a NoSchedule taint, e.g. for controllers
+	// which remove tainted nodes from load balancers or EndpointSlices
+	Taint string
+}
+
+// DrainNodeFromLoadBalancer excludes the node from external load balancers and waits for
+// every load balancer in its cluster to stop sending it traffic
+func (p *CCMProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	return p.observer().DrainNodeFromLoadBalancer(ctx, nodeName)
+}
+
+// RestoreNodeToLoadBalancer undoes the label, cordon and taint a drain added, leaving the
+// cloud controller manager to register the node again
+func (p *CCMProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling registration for node")
+	node, err := p.getNode(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if err := p.patch(ctx, node, p.Kubernetes.IncludeInLoadBalancers); err != nil {
+		return err
+	}
+	if err := p.patch(ctx, node, p.Kubernetes.Uncordon); err != nil {
+		return err
+	}
+	if p.Taint != "" {
+		return p.patch(ctx, node, func(n *kube.Node) (bool, error) { return p.Kubernetes.RemoveTaint(n, p.Taint) })
+	}
+	return nil
+}
+
+// DescribeNode describes the node's instance
+func (p *CCMProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	return p.AWS.DescribeNode(ctx, nodeName)
+}
+
+// PlanDrain reports what draining the node would do without labelling it
+func (p *CCMProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	return p.observer().PlanDrain(ctx, nodeName)
+}
+
+// ListRegistrations lists the load balancers the node's instance is registered with
+func (p *CCMProvider) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	return p.AWS.ListRegistrations(ctx, nodeName)
+}
+
+// ListClusterLoadBalancers reports the health of the cluster's load balancers
+func (p *CCMProvider) ListClusterLoadBalancers(ctx context.Context, clusterName string) (*deregister.ClusterLoadBalancers, error) {
+	return p.AWS.ListClusterLoadBalancers(ctx, clusterName)
+}
+
+// observer returns a copy of the AWS provider which excludes the node rather than deregistering it
+func (p *CCMProvider) observer() *CloudProvider {
+	observer := *p.AWS
+	observer.exclude = p.exclude
+	return &observer
+}
+
+// exclude labels the node, and cordons and taints it when configured. It runs once the
+// node's instance and cluster are known, before the load balancers are polled
+func (p *CCMProvider) exclude(ctx context.Context, nodeID string) error {
+	node, err := p.getNode(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("node", node.Name).
+		Str("nodeID", nodeID).
+		Bool("cordon", p.Cordon).
+		Str("taint", p.Taint).
+		Msg("excluding node from external load balancers")
+	if err := p.patch(ctx, node, p.Kubernetes.ExcludeFromLoadBalancers); err != nil {
+		return err
+	}
+	if p.Cordon {
+		if err := p.patch(ctx, node, p.Kubernetes.Cordon); err != nil {
+			return err
+		}
+	}
+	if p.Taint != "" {
+		return p.patch(ctx, node, func(n *kube.Node) (bool, error) { return p.Kubernetes.AddTaint(n, p.Taint) })
+	}
+	return nil
+}
+
+func (p *CCMProvider) getNode(ctx context.Context, nodeName string) (*kube.Node, error) {
+	finish := p.AWS.StartAPICall(ctx, "kubernetes.nodes.get")
+	node, err := p.Kubernetes.GetNode(nodeName)
+	finish(err)
+	return node, err
+}
+
+func (p *CCMProvider) patch(ctx context.Context, node *kube.Node, change func(*kube.Node) (bool, error)) error {
+	finish := p.AWS.StartAPICall(ctx, "kubernetes.nodes.patch")
+	_, err := change(node)
+	finish(err)
+	return err
+}
@@ -0,0 +1,129 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+// fakeKubernetes records node changes, calling onExclude when the node is labelled
+type fakeKubernetes struct {
+	node      kube.Node
+	changes   []string
+	onExclude func()
+}
+
+func (f *fakeKubernetes) GetNode(nodeName string) (*kube.Node, error) {
+	node := f.node
+	return &node, nil
+}
+
+func (f *fakeKubernetes) ExcludeFromLoadBalancers(n *kube.Node) (bool, error) {
+	f.changes = append(f.changes, "exclude")
+	if f.onExclude != nil {
+		f.onExclude()
+	}
+	return true, nil
+}
+
+func (f *fakeKubernetes) IncludeInLoadBalancers(n *kube.Node) (bool, error) {
+	f.changes = append(f.changes, "include")
+	return true, nil
+}
+
+func (f *fakeKubernetes) Cordon(n *kube.Node) (bool, error) {
+	f.changes = append(f.changes, "cordon")
+	return true, nil
+}
+
+func (f *fakeKubernetes) Uncordon(n *kube.Node) (bool, error) {
+	f.changes = append(f.changes, "uncordon")
+	return true, nil
+}
+
+func (f *fakeKubernetes) AddTaint(n *kube.Node, key string) (bool, error) {
+	f.changes = append(f.changes, "taint "+key)
+	return true, nil
+}
+
+func (f *fakeKubernetes) RemoveTaint(n *kube.Node, key string) (bool, error) {
+	f.changes = append(f.changes, "untaint "+key)
+	return true, nil
+}
+
+func TestCCMDrainWaitsForCloudControllerManager(t *testing.T) {
+	provider, elbClient, elbV2Client := newPlanningProvider("InService", "healthy")
+	provider.PollInterval = time.Millisecond
+	k8s := &fakeKubernetes{node: kube.Node{Name: "ip-10-0-0-1"}}
+	// the cloud controller manager removes the node once it sees the label
+	k8s.onExclude = func() {
+		elbClient.descHealthOutput.InstanceStates[0].State = aws.String("OutOfService")
+		elbV2Client.targetHealthOutput.TargetHealthDescriptions[0].TargetHealth.State = aws.String("unused")
+	}
+	ccm := &CCMProvider{AWS: provider, Kubernetes: k8s, Cordon: true, Taint: "ToBeDeletedByClusterAutoscaler"}
+
+	result, err := ccm.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.TimedOut() || len(result.LoadBalancers) != 2 {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if elbClient.deregistrations != 0 || elbV2Client.deregistrations != 0 {
+		t.Fatal("failed - expected the cloud controller manager to deregister the node")
+	}
+	if len(k8s.changes) != 3 || k8s.changes[0] != "exclude" || k8s.changes[1] != "cordon" || k8s.changes[2] != "taint ToBeDeletedByClusterAutoscaler" {
+		t.Fatalf("failed - unexpected node changes %v", k8s.changes)
+	}
+}
+
+func TestCCMDrainTimesOutWithoutDeregistering(t *testing.T) {
+	provider, elbClient, elbV2Client := newPlanningProvider("InService", "healthy")
+	provider.PollInterval = time.Millisecond
+	provider.Timeout = 10 * time.Millisecond
+	k8s := &fakeKubernetes{node: kube.Node{Name: "ip-10-0-0-1"}}
+	ccm := &CCMProvider{AWS: provider, Kubernetes: k8s}
+
+	result, err := ccm.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+	if elbClient.deregistrations != 0 || elbV2Client.deregistrations != 0 {
+		t.Fatal("failed - expected no deregistrations")
+	}
+	if len(k8s.changes) != 1 {
+		t.Fatalf("failed - expected only the label, got %v", k8s.changes)
+	}
+}
+
+func TestCCMDryRunDoesNotLabel(t *testing.T) {
+	provider, _, _ := newPlanningProvider("InService", "healthy")
+	k8s := &fakeKubernetes{node: kube.Node{Name: "ip-10-0-0-1"}}
+	ccm, _ := deregister.Wrap(&CCMProvider{AWS: provider, Kubernetes: k8s}, deregister.WithDryRun(false))
+
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{DryRun: true})
+	result, err := ccm.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || !result.DryRun || len(k8s.changes) != 0 {
+		t.Fatalf("failed - unexpected result %+v %v %v", result, err, k8s.changes)
+	}
+	if reason := result.Plan.LoadBalancers[0].Reason; reason != "the cloud controller manager deregisters the node once it is excluded" {
+		t.Fatalf("failed - unexpected reason %q", reason)
+	}
+	if provider.exclude != nil {
+		t.Fatal("failed - expected the AWS provider to be left unchanged")
+	}
+}
+
+func TestCCMRestore(t *testing.T) {
+	provider, _, _ := newPlanningProvider("OutOfService", "unused")
+	k8s := &fakeKubernetes{node: kube.Node{Name: "ip-10-0-0-1"}}
+	ccm := &CCMProvider{AWS: provider, Kubernetes: k8s, Taint: "drain"}
+
+	if err := ccm.RestoreNodeToLoadBalancer(context.Background(), "ip-10-0-0-1"); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if len(k8s.changes) != 3 || k8s.changes[0] != "include" || k8s.changes[2] != "untaint drain" {
+		t.Fatalf("failed - unexpected node changes %v", k8s.changes)
+	}
+}
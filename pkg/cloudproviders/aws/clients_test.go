@@ -1,8 +1,11 @@
 package aws
 
 import (
+	"time"
+
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
 )
 
 type fakeELB struct {
@@ -10,24 +13,74 @@ type fakeELB struct {
 	describeTagsOutput *elb.DescribeTagsOutput
 	descHealthOutput   *elb.DescribeInstanceHealthOutput
 	deregOutput        *elb.DeregisterInstancesFromLoadBalancerOutput
+	attributesOutput   *elb.DescribeLoadBalancerAttributesOutput
 	err                error
+	deregistrations    int
 }
 
 func (m *fakeELB) DescribeLoadBalancers(input *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error) {
 	return m.describeELBOutput, m.err
 }
 
+func (m *fakeELB) DescribeLoadBalancerAttributes(input *elb.DescribeLoadBalancerAttributesInput) (*elb.DescribeLoadBalancerAttributesOutput, error) {
+	return m.attributesOutput, m.err
+}
+
 func (m *fakeELB) DescribeTags(input *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error) {
 	return m.describeTagsOutput, m.err
 }
 
 func (m *fakeELB) DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	m.deregistrations++
 	return m.deregOutput, m.err
 }
+func (m *fakeELB) RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error) {
+	return &elb.RegisterInstancesWithLoadBalancerOutput{}, m.err
+}
+
 func (m *fakeELB) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
 	return m.descHealthOutput, m.err
 }
 
+type fakeELBV2 struct {
+	describeELBOutput       *elbv2.DescribeLoadBalancersOutput
+	describeTagsOutput      *elbv2.DescribeTagsOutput
+	describeListenersOutput *elbv2.DescribeListenersOutput
+	targetHealthOutput      *elbv2.DescribeTargetHealthOutput
+	attributesOutput        *elbv2.DescribeTargetGroupAttributesOutput
+	err                     error
+	deregistrations         int
+}
+
+func (m *fakeELBV2) DeregisterTargets(input *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	m.deregistrations++
+	return &elbv2.DeregisterTargetsOutput{}, m.err
+}
+
+func (m *fakeELBV2) DescribeListeners(input *elbv2.DescribeListenersInput) (*elbv2.DescribeListenersOutput, error) {
+	return m.describeListenersOutput, m.err
+}
+
+func (m *fakeELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	return m.describeELBOutput, m.err
+}
+
+func (m *fakeELBV2) DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error) {
+	return m.describeTagsOutput, m.err
+}
+
+func (m *fakeELBV2) DescribeTargetGroupAttributes(input *elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	return m.attributesOutput, m.err
+}
+
+func (m *fakeELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	return m.targetHealthOutput, m.err
+}
+
+func (m *fakeELBV2) RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	return &elbv2.RegisterTargetsOutput{}, m.err
+}
+
 type fakeEC2 struct {
 	describeInstancesOutput *ec2.DescribeInstancesOutput
 	err                     error
@@ -36,3 +89,33 @@ type fakeEC2 struct {
 func (m *fakeEC2) DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
 	return m.describeInstancesOutput, m.err
 }
+
+type fakeRecorder struct {
+	outcomes   []string
+	apiCalls   []string
+	apiErrors  []string
+	inFlight   int
+	lbTimeouts int
+}
+
+func (r *fakeRecorder) DrainStarted() {
+	r.inFlight++
+}
+
+func (r *fakeRecorder) DrainFinished(cluster string, outcome string, duration time.Duration) {
+	r.inFlight--
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+func (r *fakeRecorder) LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool) {
+	if timedOut {
+		r.lbTimeouts++
+	}
+}
+
+func (r *fakeRecorder) APICall(operation string, err error) {
+	r.apiCalls = append(r.apiCalls, operation)
+	if err != nil {
+		r.apiErrors = append(r.apiErrors, operation)
+	}
+}
@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeClock advances simulated time by each wait instead of sleeping, recording the waits
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// newClockedTargetGroup builds a provider and backend sharing a simulated clock, with i-1
+// in a single target group so only one polling loop advances the clock
+func newClockedTargetGroup(delay time.Duration, instanceIDs ...string) (*CloudProvider, *fakeAWS, *fakeClock) {
+	clock := newFakeClock()
+	f := newFakeAWS()
+	f.now = clock.Now
+	f.addInstance("i-1", "10.0.0.1", "clustername")
+	f.addTargetGroup("tg-1", delay, instanceIDs...)
+	f.addELBV2("alb-1", "clustername", "tg-1")
+
+	provider := f.provider()
+	provider.Clock = clock
+	provider.PollInterval = 0
+	provider.Timeout = 5 * time.Minute
+	return provider, f, clock
+}
+
+func TestDrainTimesOutOnSimulatedClock(t *testing.T) {
+	provider, f, clock := newClockedTargetGroup(time.Hour, "i-1")
+	provider.PollInterval = 10 * time.Second
+
+	start := time.Now()
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("failed - expected the drain to take simulated time only, took %v", elapsed)
+	}
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+
+	// polls at 0s, 10s, ... 310s, when more than the 5m timeout has passed
+	if len(clock.waits) != 31 || result.Duration != 310*time.Second {
+		t.Fatalf("failed - expected 31 waits over 310s, got %v over %v", len(clock.waits), result.Duration)
+	}
+	for _, wait := range clock.waits {
+		if wait != 10*time.Second {
+			t.Fatalf("failed - expected every wait to be the poll interval, got %v", clock.waits)
+		}
+	}
+	if state := f.targetState("tg-1", "i-1"); state != "draining" {
+		t.Fatalf("failed - expected i-1 to still be draining, got %v", state)
+	}
+}
+
+func TestDrainExitsOnceDrained(t *testing.T) {
+	provider, f, clock := newClockedTargetGroup(30*time.Second, "i-1")
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+
+	// the default 5s poll interval finds the target unused as soon as the 30s delay passes
+	if len(clock.waits) != 6 || result.Duration != 30*time.Second {
+		t.Fatalf("failed - expected 6 waits over 30s, got %v over %v", clock.waits, result.Duration)
+	}
+	if calls := f.callCount("elbv2.DescribeTargetHealth"); calls != 7 {
+		t.Fatalf("failed - expected 7 health checks, got %v", calls)
+	}
+}
+
+func TestDrainDoesNotWaitForUnregisteredNode(t *testing.T) {
+	provider, f, clock := newClockedTargetGroup(time.Hour)
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.TimedOut() || result.LoadBalancers[0].Deregistered {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if len(clock.waits) != 0 || result.Duration != 0 {
+		t.Fatalf("failed - expected no waits, got %v over %v", clock.waits, result.Duration)
+	}
+	if calls := f.callCount("elbv2.DeregisterTargets"); calls != 0 {
+		t.Fatalf("failed - expected no deregistrations, got %v", calls)
+	}
+}
+
+func TestDrainTimeoutOverrideOnSimulatedClock(t *testing.T) {
+	provider, _, clock := newClockedTargetGroup(time.Hour, "i-1")
+	provider.Timeout = time.Hour
+
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{Timeout: time.Minute})
+	result, err := provider.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+	if len(clock.waits) != 13 || result.Duration != 65*time.Second {
+		t.Fatalf("failed - expected the per-drain timeout to apply, got %v waits over %v", len(clock.waits), result.Duration)
+	}
+}
@@ -0,0 +1,167 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ListClusterLoadBalancers counts the targets of every ELB and v2 target group in the cluster by
+// health, and finds registered instances which no longer exist. The cluster's VPCs are those of
+// its tagged instances
+func (m *CloudProvider) ListClusterLoadBalancers(ctx context.Context, clusterName string) (*deregister.ClusterLoadBalancers, error) {
+	ctx, span := tracing.Start(ctx, "ListClusterLoadBalancers", trace.WithAttributes(attribute.String("cluster.name", clusterName)))
+	lbs, err := m.listClusterLoadBalancers(ctx, clusterName)
+	tracing.End(span, err)
+	return lbs, err
+}
+
+func (m *CloudProvider) listClusterLoadBalancers(ctx context.Context, clusterName string) (*deregister.ClusterLoadBalancers, error) {
+	vpcIDs, err := m.getClusterVPCs(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if len(vpcIDs) == 0 {
+		return nil, deregister.ErrClusterNotFound
+	}
+
+	lbs := &deregister.ClusterLoadBalancers{ClusterName: clusterName, LoadBalancers: []deregister.LoadBalancerHealth{}}
+	// the instances registered with each entry, to find the stale ones once all are known
+	targets := [][]string{}
+	for _, vpcID := range vpcIDs {
+		elbV1Names, err := m.getELBV1s(ctx, vpcID, clusterName)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range elbV1Names {
+			health, instanceIDs, err := m.elbV1Health(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			lbs.LoadBalancers = append(lbs.LoadBalancers, *health)
+			targets = append(targets, instanceIDs)
+		}
+
+		elbV2ARNs, err := m.getELBV2sInVPC(ctx, vpcID)
+		if err != nil {
+			return nil, err
+		}
+		if len(elbV2ARNs) == 0 {
+			continue
+		}
+		elbV2ARNs, err = m.filterELBV2sWithTag(ctx, elbV2ARNs, fmt.Sprintf("kubernetes.io/cluster/%s", clusterName))
+		if err != nil {
+			return nil, err
+		}
+
+		targetHealth := map[string][]*elbv2.TargetHealthDescription{}
+		for _, elbV2ARN := range elbV2ARNs {
+			targetGroupARNs, err := m.getTargetGroupsAtELB(ctx, elbV2ARN)
+			if err != nil {
+				return nil, err
+			}
+			for _, targetGroupARN := range aws.StringValueSlice(targetGroupARNs) {
+				descriptions, ok := targetHealth[targetGroupARN]
+				if !ok {
+					descriptions, err = m.describeTargetHealth(ctx, targetGroupARN)
+					if err != nil {
+						return nil, err
+					}
+					targetHealth[targetGroupARN] = descriptions
+				}
+
+				health, instanceIDs := targetGroupHealth(aws.StringValue(elbV2ARN), targetGroupARN, descriptions)
+				lbs.LoadBalancers = append(lbs.LoadBalancers, *health)
+				targets = append(targets, instanceIDs)
+			}
+		}
+	}
+
+	instanceIDs := []string{}
+	for _, ids := range targets {
+		for _, id := range ids {
+			if !contains(instanceIDs, id) {
+				instanceIDs = append(instanceIDs, id)
+			}
+		}
+	}
+	existing, err := m.getExistingInstances(ctx, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, ids := range targets {
+		for _, id := range ids {
+			if !existing[id] {
+				lbs.LoadBalancers[i].StaleTargets = append(lbs.LoadBalancers[i].StaleTargets, id)
+			}
+		}
+		sort.Strings(lbs.LoadBalancers[i].StaleTargets)
+	}
+
+	return lbs, nil
+}
+
+// elbV1Health counts the ELB's instances by health, returning their IDs
+func (m *CloudProvider) elbV1Health(ctx context.Context, elbV1Name string) (*deregister.LoadBalancerHealth, []string, error) {
+	finish := m.StartAPICall(ctx, "elb.DescribeInstanceHealth")
+	result, err := m.ELB.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: &elbV1Name})
+	finish(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	health := &deregister.LoadBalancerHealth{LoadBalancer: elbV1Name, Type: metrics.LoadBalancerELBV1, StaleTargets: []string{}}
+	instanceIDs := []string{}
+	for _, state := range result.InstanceStates {
+		instanceIDs = append(instanceIDs, aws.StringValue(state.InstanceId))
+		switch {
+		// instances stay InService while connection draining is in progress
+		case aws.StringValue(state.State) == "InService" && strings.Contains(aws.StringValue(state.Description), "deregistration currently in progress"):
+			health.Draining++
+		case aws.StringValue(state.State) == "InService":
+			health.Healthy++
+		default:
+			health.Unhealthy++
+		}
+	}
+	return health, instanceIDs, nil
+}
+
+// targetGroupHealth counts the target group's targets by health, returning the IDs of its instance targets
+func targetGroupHealth(elbV2ARN string, targetGroupARN string, descriptions []*elbv2.TargetHealthDescription) (*deregister.LoadBalancerHealth, []string) {
+	health := &deregister.LoadBalancerHealth{
+		LoadBalancer: elbV2ARN,
+		TargetGroup:  targetGroupARN,
+		Type:         metrics.LoadBalancerELBV2,
+		StaleTargets: []string{},
+	}
+	instanceIDs := []string{}
+	for _, desc := range descriptions {
+		// IP targets have no instance to go missing
+		if id := aws.StringValue(desc.Target.Id); strings.HasPrefix(id, "i-") {
+			instanceIDs = append(instanceIDs, id)
+		}
+		switch aws.StringValue(desc.TargetHealth.State) {
+		case elbv2.TargetHealthStateEnumHealthy:
+			health.Healthy++
+		case elbv2.TargetHealthStateEnumDraining:
+			health.Draining++
+		case elbv2.TargetHealthStateEnumUnused:
+			health.Unused++
+		default:
+			health.Unhealthy++
+		}
+	}
+	return health, instanceIDs
+}
@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func TestListClusterLoadBalancers(t *testing.T) {
+	f := newScenario()
+	// i-3 was terminated and i-gone no longer exists at all, without either being drained
+	f.addInstance("i-3", "10.0.0.3", "clustername")
+	f.instances["i-3"].terminated = true
+
+	f.classicELBs["elb-1"].drainingTimeout = time.Hour
+	f.classicELBs["elb-1"].instances["i-2"].deregisteredAt = time.Now()
+	f.classicELBs["elb-1"].instances["i-3"] = &fakeRegistration{state: "InService"}
+	f.targetGroups["tg-1"].targets["i-2"].state = "unhealthy"
+	f.targetGroups["tg-1"].targets["i-3"] = &fakeRegistration{state: "healthy"}
+	f.targetGroups["tg-2"].targets["i-1"].state = "draining"
+	f.targetGroups["tg-2"].targets["i-1"].deregisteredAt = time.Now()
+	f.targetGroups["tg-2"].delay = time.Hour
+	f.targetGroups["tg-2"].targets["i-gone"] = &fakeRegistration{state: "unused"}
+	f.targetGroups["tg-2"].targets["10.0.0.9"] = &fakeRegistration{state: "healthy"}
+	f.addELBV2("alb-2", "clustername", "tg-1")
+
+	lbs, err := f.provider().ListClusterLoadBalancers(context.Background(), "clustername")
+	if err != nil || lbs.ClusterName != "clustername" {
+		t.Fatalf("failed - unexpected result %+v %v", lbs, err)
+	}
+
+	tg1 := deregister.LoadBalancerHealth{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Healthy: 2, Unhealthy: 1, StaleTargets: []string{"i-3"}}
+	tg1AtALB2 := tg1
+	tg1AtALB2.LoadBalancer = "alb-2"
+	expected := []deregister.LoadBalancerHealth{
+		{LoadBalancer: "elb-1", Type: "elbv1", Healthy: 2, Draining: 1, StaleTargets: []string{"i-3"}},
+		tg1,
+		{LoadBalancer: "alb-1", TargetGroup: "tg-2", Type: "elbv2", Healthy: 1, Draining: 1, Unused: 1, StaleTargets: []string{"i-gone"}},
+		tg1AtALB2,
+	}
+	if !reflect.DeepEqual(lbs.LoadBalancers, expected) {
+		t.Fatalf("failed - expected %+v, got %+v", expected, lbs.LoadBalancers)
+	}
+
+	if calls := f.callCount("elbv2.DescribeTargetHealth"); calls != 2 {
+		t.Fatalf("failed - expected each target group's health to be described once, got %v calls", calls)
+	}
+}
+
+func TestListClusterLoadBalancersUnknownCluster(t *testing.T) {
+	f := newScenario()
+
+	if _, err := f.provider().ListClusterLoadBalancers(context.Background(), "missing"); err != deregister.ErrClusterNotFound {
+		t.Fatalf("failed - expected cluster not found, got %v", err)
+	}
+}
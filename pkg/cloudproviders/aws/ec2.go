@@ -1,15 +1,27 @@
 package aws
 
 import (
+	"context"
 	"errors"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 )
 
+const asgTagKey = "aws:autoscaling:groupName"
+
+// maxFilterValues is the most values EC2 accepts in a single filter
+const maxFilterValues = 200
+
 // GetVPCAndClusterFromInstance gets the VPC ID and cluster name from the instance
-func (m *CloudProvider) GetVPCAndClusterFromInstance(nodeID string) (vpcID *string, clusterName *string, err error) {
+func (m *CloudProvider) GetVPCAndClusterFromInstance(ctx context.Context, nodeID string) (vpcID *string, clusterName *string, err error) {
+	ctx, span := tracing.Start(ctx, "GetVPCAndClusterFromInstance")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "ec2.DescribeInstances")
 	instances, err := m.EC2.DescribeInstances(
 		&ec2.DescribeInstancesInput{
 			InstanceIds: []*string{
@@ -17,6 +29,7 @@ func (m *CloudProvider) GetVPCAndClusterFromInstance(nodeID string) (vpcID *stri
 			},
 		},
 	)
+	finish(err)
 
 	if err != nil {
 		return nil, nil, err
@@ -39,8 +52,57 @@ func (m *CloudProvider) GetVPCAndClusterFromInstance(nodeID string) (vpcID *stri
 	return nil, nil, nil
 }
 
+// DescribeNode gets the VPC, cluster, autoscaling group and tags of the node
+func (m *CloudProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	nodeID, err := m.resolveNodeID(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	finish := m.StartAPICall(ctx, "ec2.DescribeInstances")
+	instances, err := m.EC2.DescribeInstances(
+		&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{
+				aws.String(nodeID),
+			},
+		},
+	)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	tagKeyMatch := "kubernetes.io/cluster/"
+	for _, res := range instances.Reservations {
+		for _, inst := range res.Instances {
+			info := &deregister.NodeInfo{
+				ID:    nodeID,
+				VPCID: aws.StringValue(inst.VpcId),
+				Tags:  map[string]string{},
+			}
+			for _, tagPair := range inst.Tags {
+				key, value := aws.StringValue(tagPair.Key), aws.StringValue(tagPair.Value)
+				info.Tags[key] = value
+				if strings.HasPrefix(key, tagKeyMatch) {
+					info.ClusterName = key[len(tagKeyMatch):]
+				}
+				if key == asgTagKey {
+					info.AutoscalingGroup = value
+				}
+			}
+			return info, nil
+		}
+	}
+
+	return nil, errors.New("Unable to find instance by ID")
+}
+
 // getNodeIDFromIP gets the node id from the ip...
-func (m *CloudProvider) getNodeIDFromIP(nodeIP string) (*string, error) {
+func (m *CloudProvider) getNodeIDFromIP(ctx context.Context, nodeIP string) (*string, error) {
+	ctx, span := tracing.Start(ctx, "getNodeIDFromIP")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "ec2.DescribeInstances")
 	instances, err := m.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			&ec2.Filter{
@@ -49,6 +111,7 @@ func (m *CloudProvider) getNodeIDFromIP(nodeIP string) (*string, error) {
 			},
 		},
 	})
+	finish(err)
 
 	if err != nil {
 		return nil, err
@@ -62,3 +125,73 @@ func (m *CloudProvider) getNodeIDFromIP(nodeIP string) (*string, error) {
 
 	return nil, errors.New("Unable to find instance by IP")
 }
+
+// getClusterVPCs gets the VPCs of the instances tagged as members of the cluster
+func (m *CloudProvider) getClusterVPCs(ctx context.Context, clusterName string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "getClusterVPCs")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "ec2.DescribeInstances")
+	instances, err := m.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String("kubernetes.io/cluster/" + clusterName)},
+			},
+		},
+	})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	vpcIDs := []string{}
+	for _, res := range instances.Reservations {
+		for _, inst := range res.Instances {
+			// terminated instances no longer have a VPC
+			vpcID := aws.StringValue(inst.VpcId)
+			if vpcID != "" && !contains(vpcIDs, vpcID) {
+				vpcIDs = append(vpcIDs, vpcID)
+			}
+		}
+	}
+	return vpcIDs, nil
+}
+
+// getExistingInstances returns which of the instances exist and have not been terminated.
+// They are looked up with a filter, as DescribeInstances fails for unknown instance IDs
+func (m *CloudProvider) getExistingInstances(ctx context.Context, nodeIDs []string) (map[string]bool, error) {
+	ctx, span := tracing.Start(ctx, "getExistingInstances")
+	defer span.End()
+
+	existing := map[string]bool{}
+	for start := 0; start < len(nodeIDs); start += maxFilterValues {
+		end := start + maxFilterValues
+		if end > len(nodeIDs) {
+			end = len(nodeIDs)
+		}
+
+		finish := m.StartAPICall(ctx, "ec2.DescribeInstances")
+		instances, err := m.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				&ec2.Filter{
+					Name:   aws.String("instance-id"),
+					Values: aws.StringSlice(nodeIDs[start:end]),
+				},
+			},
+		})
+		finish(err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, res := range instances.Reservations {
+			for _, inst := range res.Instances {
+				if inst.State == nil || aws.StringValue(inst.State.Name) != ec2.InstanceStateNameTerminated {
+					existing[aws.StringValue(inst.InstanceId)] = true
+				}
+			}
+		}
+	}
+	return existing, nil
+}
@@ -1,8 +1,10 @@
 package aws
 
 import (
+	"context"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -24,9 +26,44 @@ func TestGetNodeIDFromIP(t *testing.T) {
 		},
 	}
 
-	id, _ := clients.getNodeIDFromIP("ip-10-0-0-1.ec2.internal")
+	id, _ := clients.getNodeIDFromIP(context.Background(), "ip-10-0-0-1.ec2.internal")
 	if *id != expected {
 		t.Fatalf("Expected instance id to equal %v, got %v", expected, id)
 	}
 	return
 }
+
+func TestDescribeNode(t *testing.T) {
+	clients := CloudProvider{
+		EC2: &fakeEC2{
+			describeInstancesOutput: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					&ec2.Reservation{
+						Instances: []*ec2.Instance{
+							&ec2.Instance{
+								InstanceId: aws.String("i-0123456789"),
+								VpcId:      aws.String("vpc-1"),
+								Tags: []*ec2.Tag{
+									&ec2.Tag{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")},
+									&ec2.Tag{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("prod-workers")},
+									&ec2.Tag{Key: aws.String("team"), Value: aws.String("payments")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	info, err := clients.DescribeNode(context.Background(), "i-0123456789")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if info.VPCID != "vpc-1" || info.ClusterName != "prod" || info.AutoscalingGroup != "prod-workers" {
+		t.Fatalf("failed - unexpected node info %+v", info)
+	}
+	if info.Tags["team"] != "payments" {
+		t.Fatalf("failed - expected team tag to be payments, got %v", info.Tags["team"])
+	}
+}
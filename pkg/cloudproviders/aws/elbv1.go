@@ -1,20 +1,26 @@
 package aws
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
-func (m *CloudProvider) getELBV1s(vpcID string, clusterName string) ([]string, error) {
-	elbsInVPC, err := m.getELBV1NamesInVPC(vpcID)
+func (m *CloudProvider) getELBV1s(ctx context.Context, vpcID string, clusterName string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "getELBV1s")
+	defer span.End()
+
+	elbsInVPC, err := m.getELBV1NamesInVPC(ctx, vpcID)
 	if err != nil {
 		return nil, err
 	}
 
 	expectedTag := fmt.Sprintf("kubernetes.io/cluster/%s", clusterName)
-	filteredELBs, err := m.filterELBV1sWithTag(elbsInVPC, expectedTag)
+	filteredELBs, err := m.filterELBV1sWithTag(ctx, elbsInVPC, expectedTag)
 	if err != nil {
 		return nil, err
 	}
@@ -27,20 +33,26 @@ func (m *CloudProvider) getELBV1s(vpcID string, clusterName string) ([]string, e
 	return filteredELBs, nil
 }
 
-func (m *CloudProvider) filterELBV1sWithTag(elbNames []*string, tagName string) ([]string, error) {
+func (m *CloudProvider) filterELBV1sWithTag(ctx context.Context, elbNames []*string, tagName string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "filterELBV1sWithTag")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "elb.DescribeTags")
 	elbTags, err := m.ELB.DescribeTags(&elb.DescribeTagsInput{
 		LoadBalancerNames: elbNames})
+	finish(err)
 	if err != nil {
 		return nil, err
 	}
 
 	names := []string{}
 	for _, element := range elbTags.TagDescriptions {
+		tags := map[string]string{}
 		for _, tag := range element.Tags {
-			if *tag.Key == tagName {
-				names = append(names, *element.LoadBalancerName)
-				break
-			}
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if m.matchesTags(tags, tagName) {
+			names = append(names, *element.LoadBalancerName)
 		}
 	}
 
@@ -53,9 +65,11 @@ func (m *CloudProvider) filterELBV1sWithTag(elbNames []*string, tagName string)
 	return names, nil
 }
 
-func (m *CloudProvider) getELBV1NamesInVPC(vpcID string) ([]*string, error) {
+func (m *CloudProvider) getELBV1NamesInVPC(ctx context.Context, vpcID string) ([]*string, error) {
 	elbDescribeParams := &elb.DescribeLoadBalancersInput{}
+	finish := m.StartAPICall(ctx, "elb.DescribeLoadBalancers")
 	elbs, err := m.ELB.DescribeLoadBalancers(elbDescribeParams)
+	finish(err)
 	if err != nil {
 		return nil, err
 	}
@@ -74,11 +88,13 @@ func (m *CloudProvider) getELBV1NamesInVPC(vpcID string) ([]*string, error) {
 	return elbsInVPC, nil
 }
 
-func (m *CloudProvider) drainNodeFromELBV1(nodeID string, elbV1Name string) (done bool, e error) {
+func (m *CloudProvider) drainNodeFromELBV1(ctx context.Context, nodeID string, elbV1Name string) (done bool, deregistered bool, e error) {
+	finish := m.StartAPICall(ctx, "elb.DescribeInstanceHealth")
 	result, err := m.ELB.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
 		LoadBalancerName: &elbV1Name})
+	finish(err)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	instanceAtELB := false
@@ -94,15 +110,15 @@ func (m *CloudProvider) drainNodeFromELBV1(nodeID string, elbV1Name string) (don
 			Str("nodeID", nodeID).
 			Str("elbName", elbV1Name).
 			Msg("Instance not InService at ELB")
-		return true, nil
+		return true, false, nil
 	}
 
-	if m.DryRun {
+	if m.exclude != nil {
 		log.Info().
 			Str("nodeID", nodeID).
 			Str("elbName", elbV1Name).
-			Msg("DRY-RUN (no action taken)---Node InService at elb, draining (faking success)")
-		return false, nil
+			Msg("Node InService at elb, waiting for the cloud controller manager to deregister it")
+		return false, false, nil
 	}
 
 	log.Info().
@@ -110,14 +126,40 @@ func (m *CloudProvider) drainNodeFromELBV1(nodeID string, elbV1Name string) (don
 		Str("elbName", elbV1Name).
 		Msg("Node InService at elb, draining")
 
+	finish = m.StartAPICall(ctx, "elb.DeregisterInstancesFromLoadBalancer")
 	_, err = m.ELB.DeregisterInstancesFromLoadBalancer(&elb.DeregisterInstancesFromLoadBalancerInput{
 		Instances:        []*elb.Instance{&elb.Instance{InstanceId: &nodeID}},
 		LoadBalancerName: &elbV1Name,
 	})
+	finish(err)
 
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
-	return false, nil
+	return false, true, nil
+}
+
+func (m *CloudProvider) registerNodeWithELBV1sInCluster(ctx context.Context, nodeID string, vpcID string, clusterName string) error {
+	elbV1Names, err := m.getELBV1s(ctx, vpcID, clusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, elbV1Name := range elbV1Names {
+		log.Info().
+			Str("nodeID", nodeID).
+			Str("elbName", elbV1Name).
+			Msg("registering node with elb")
+		finish := m.StartAPICall(ctx, "elb.RegisterInstancesWithLoadBalancer")
+		_, err := m.ELB.RegisterInstancesWithLoadBalancer(&elb.RegisterInstancesWithLoadBalancerInput{
+			Instances:        []*elb.Instance{&elb.Instance{InstanceId: aws.String(nodeID)}},
+			LoadBalancerName: aws.String(elbV1Name),
+		})
+		finish(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package aws
 
 import (
+	"context"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -24,7 +25,7 @@ func TestDescribeLoadBalancers(t *testing.T) {
 			},
 		},
 	}
-	elbs, _ := clients.getELBV1NamesInVPC("vpc-1")
+	elbs, _ := clients.getELBV1NamesInVPC(context.Background(), "vpc-1")
 	if len(elbs) != 1 {
 		t.Fatalf("Expected only one result, got %v", len(elbs))
 	}
@@ -92,7 +93,7 @@ func TestFilterELBV1s(t *testing.T) {
 				describeTagsOutput: &c.Resp,
 			},
 		}
-		filtered, _ := clients.filterELBV1sWithTag(nil, "kubernetets.io/cluster/clustername")
+		filtered, _ := clients.filterELBV1sWithTag(context.Background(), nil, "kubernetets.io/cluster/clustername")
 		if len(filtered) != len(c.Expected) {
 			t.Fatalf("%d failed - unexpected number of results, expected %v, actual %v", i, len(c.Expected), len(filtered))
 		}
@@ -104,6 +105,42 @@ func TestFilterELBV1s(t *testing.T) {
 	}
 }
 
+func TestFilterELBV1sWithTagSelectors(t *testing.T) {
+	clusterTag := &elb.Tag{Key: aws.String("kubernetes.io/cluster/clustername"), Value: aws.String("owned")}
+	clients := &CloudProvider{
+		ELB: &fakeELB{
+			describeTagsOutput: &elb.DescribeTagsOutput{
+				TagDescriptions: []*elb.TagDescription{
+					{
+						LoadBalancerName: aws.String("ELB1"),
+						Tags:             []*elb.Tag{clusterTag, {Key: aws.String("team"), Value: aws.String("payments")}},
+					},
+					{
+						LoadBalancerName: aws.String("ELB2"),
+						Tags:             []*elb.Tag{clusterTag, {Key: aws.String("team"), Value: aws.String("search")}},
+					},
+					{
+						LoadBalancerName: aws.String("ELB3"),
+						Tags:             []*elb.Tag{clusterTag},
+					},
+				},
+			},
+		},
+		TagSelectors: map[string]string{"team": "payments"},
+	}
+
+	filtered, _ := clients.filterELBV1sWithTag(context.Background(), nil, "kubernetes.io/cluster/clustername")
+	if len(filtered) != 1 || filtered[0] != "ELB1" {
+		t.Fatalf("failed - expected only ELB1 to match selectors, got %v", filtered)
+	}
+
+	clients.TagSelectors = map[string]string{"team": ""}
+	filtered, _ = clients.filterELBV1sWithTag(context.Background(), nil, "kubernetes.io/cluster/clustername")
+	if len(filtered) != 2 {
+		t.Fatalf("failed - expected any team value to match, got %v", filtered)
+	}
+}
+
 func TestDrainNodeFromELBV1WhenPresent(t *testing.T) {
 	clients := &CloudProvider{
 		ELB: &fakeELB{
@@ -120,10 +157,13 @@ func TestDrainNodeFromELBV1WhenPresent(t *testing.T) {
 			},
 		},
 	}
-	result, err := clients.drainNodeFromELBV1("myinstance", "")
+	result, deregistered, err := clients.drainNodeFromELBV1(context.Background(), "myinstance", "")
 	if result || err != nil {
 		t.Fatalf("failed - expected result to be false and err to be nil")
 	}
+	if !deregistered {
+		t.Fatalf("failed - expected node to be deregistered")
+	}
 }
 
 func TestDrainNodeFromELBV1WhenNotPresent(t *testing.T) {
@@ -142,7 +182,7 @@ func TestDrainNodeFromELBV1WhenNotPresent(t *testing.T) {
 			},
 		},
 	}
-	result, err := clients.drainNodeFromELBV1("differentinstance", "")
+	result, _, err := clients.drainNodeFromELBV1(context.Background(), "differentinstance", "")
 	if err != nil {
 		t.Fatalf("failed - expected err to be nil")
 	}
@@ -1,9 +1,12 @@
 package aws
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
@@ -15,19 +18,22 @@ const (
 	statusNeedsDrained nodeStatus = iota
 )
 
-func (m *CloudProvider) getELBV2TargetGroupARNsInCluster(vpcID string, clusterName string) ([]string, error) {
-	elbsInVPC, err := m.getELBV2sInVPC(vpcID)
+func (m *CloudProvider) getELBV2TargetGroupARNsInCluster(ctx context.Context, vpcID string, clusterName string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "getELBV2TargetGroupARNsInCluster")
+	defer span.End()
+
+	elbsInVPC, err := m.getELBV2sInVPC(ctx, vpcID)
 	if err != nil {
 		return nil, err
 	}
 
 	expectedTag := fmt.Sprintf("kubernetes.io/cluster/%s", clusterName)
-	filteredELBs, err := m.filterELBV2sWithTag(elbsInVPC, expectedTag)
+	filteredELBs, err := m.filterELBV2sWithTag(ctx, elbsInVPC, expectedTag)
 	if err != nil {
 		return nil, err
 	}
 
-	targetGroupARNs, err := m.getTargetGroupsAtELBARNs(filteredELBs)
+	targetGroupARNs, err := m.getTargetGroupsAtELBARNs(ctx, filteredELBs)
 	if err != nil {
 		return nil, err
 	}
@@ -41,10 +47,13 @@ func (m *CloudProvider) getELBV2TargetGroupARNsInCluster(vpcID string, clusterNa
 	return targetGroupARNs, nil
 }
 
-func (m *CloudProvider) getTargetGroupsAtELBARNs(elbV2ARNs []*string) ([]string, error) {
+func (m *CloudProvider) getTargetGroupsAtELBARNs(ctx context.Context, elbV2ARNs []*string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "getTargetGroupsAtELBARNs")
+	defer span.End()
+
 	targetGroupARNs := []string{}
 	for _, elbARN := range elbV2ARNs {
-		elbTargets, err := m.getTargetGroupsAtELB(elbARN)
+		elbTargets, err := m.getTargetGroupsAtELB(ctx, elbARN)
 		if err != nil {
 			return nil, err
 		}
@@ -57,9 +66,11 @@ func (m *CloudProvider) getTargetGroupsAtELBARNs(elbV2ARNs []*string) ([]string,
 	return targetGroupARNs, nil
 }
 
-func (m *CloudProvider) getTargetGroupsAtELB(elbV2ARN *string) ([]*string, error) {
+func (m *CloudProvider) getTargetGroupsAtELB(ctx context.Context, elbV2ARN *string) ([]*string, error) {
+	finish := m.StartAPICall(ctx, "elbv2.DescribeListeners")
 	listeners, err := m.ELBV2.DescribeListeners(&elbv2.DescribeListenersInput{
 		LoadBalancerArn: elbV2ARN})
+	finish(err)
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +84,10 @@ func (m *CloudProvider) getTargetGroupsAtELB(elbV2ARN *string) ([]*string, error
 	return targets, nil
 }
 
-func (m *CloudProvider) getELBV2sInVPC(vpcID string) ([]*string, error) {
+func (m *CloudProvider) getELBV2sInVPC(ctx context.Context, vpcID string) ([]*string, error) {
+	finish := m.StartAPICall(ctx, "elbv2.DescribeLoadBalancers")
 	elbs, err := m.ELBV2.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{})
+	finish(err)
 	if err != nil {
 		return nil, err
 	}
@@ -93,10 +106,15 @@ func (m *CloudProvider) getELBV2sInVPC(vpcID string) ([]*string, error) {
 	return elbsInVPC, nil
 }
 
-func (m *CloudProvider) filterELBV2sWithTag(elbV2ARNs []*string, expectedTag string) ([]*string, error) {
+func (m *CloudProvider) filterELBV2sWithTag(ctx context.Context, elbV2ARNs []*string, expectedTag string) ([]*string, error) {
+	ctx, span := tracing.Start(ctx, "filterELBV2sWithTag")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "elbv2.DescribeTags")
 	elbTags, err := m.ELBV2.DescribeTags(&elbv2.DescribeTagsInput{
 		ResourceArns: elbV2ARNs,
 	})
+	finish(err)
 
 	if err != nil {
 		return nil, err
@@ -104,11 +122,12 @@ func (m *CloudProvider) filterELBV2sWithTag(elbV2ARNs []*string, expectedTag str
 
 	filteredARNs := []*string{}
 	for _, element := range elbTags.TagDescriptions {
+		tags := map[string]string{}
 		for _, tag := range element.Tags {
-			if *tag.Key == expectedTag {
-				filteredARNs = append(filteredARNs, element.ResourceArn)
-				break
-			}
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if m.matchesTags(tags, expectedTag) {
+			filteredARNs = append(filteredARNs, element.ResourceArn)
 		}
 	}
 
@@ -121,33 +140,35 @@ func (m *CloudProvider) filterELBV2sWithTag(elbV2ARNs []*string, expectedTag str
 	return filteredARNs, nil
 }
 
-func (m *CloudProvider) nodeDrainedFromELBV2TargetGroup(nodeID string, targetGroupArn string) (bool, error) {
-	drainStatus, err := m.instanceTargetGroupDrainStatus(nodeID, targetGroupArn)
+func (m *CloudProvider) nodeDrainedFromELBV2TargetGroup(ctx context.Context, nodeID string, targetGroupArn string) (drained bool, deregistered bool, err error) {
+	drainStatus, err := m.instanceTargetGroupDrainStatus(ctx, nodeID, targetGroupArn)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
-	if drainStatus == statusNeedsDrained && m.DryRun {
+	if drainStatus == statusNeedsDrained && m.exclude != nil {
 		log.Info().
 			Str("nodeID", nodeID).
 			Str("targetGroupArn", targetGroupArn).
-			Msg("DRY-RUN (no action taken)---Node needs draining")
-		return false, nil
+			Msg("Node in target group, waiting for the cloud controller manager to deregister it")
+		return false, false, nil
 	}
 
-	if drainStatus == statusNeedsDrained && !m.DryRun {
+	if drainStatus == statusNeedsDrained {
 		log.Info().
 			Str("nodeID", nodeID).
 			Str("targetGroupArn", targetGroupArn).
 			Msg("Node needs draining")
+		finish := m.StartAPICall(ctx, "elbv2.DeregisterTargets")
 		_, err = m.ELBV2.DeregisterTargets(&elbv2.DeregisterTargetsInput{
 			TargetGroupArn: &targetGroupArn,
 			Targets:        []*elbv2.TargetDescription{&elbv2.TargetDescription{Id: &nodeID}}})
+		finish(err)
 		if err != nil {
-			return false, err
+			return false, false, err
 		}
 
-		return false, nil
+		return false, true, nil
 	}
 
 	if drainStatus == statusDraining {
@@ -156,7 +177,7 @@ func (m *CloudProvider) nodeDrainedFromELBV2TargetGroup(nodeID string, targetGro
 			Str("targetGroupArn", targetGroupArn).
 			Bool("isDraining", true).
 			Msg("node is draining")
-		return false, nil
+		return false, false, nil
 	}
 
 	log.Info().
@@ -164,12 +185,14 @@ func (m *CloudProvider) nodeDrainedFromELBV2TargetGroup(nodeID string, targetGro
 		Str("targetGroupArn", targetGroupArn).
 		Bool("isDraining", true).
 		Msg("node does not need to be drained")
-	return true, nil
+	return true, false, nil
 }
 
-func (m *CloudProvider) instanceTargetGroupDrainStatus(nodeID string, targetGroupArn string) (nodeStatus, error) {
+func (m *CloudProvider) instanceTargetGroupDrainStatus(ctx context.Context, nodeID string, targetGroupArn string) (nodeStatus, error) {
+	finish := m.StartAPICall(ctx, "elbv2.DescribeTargetHealth")
 	healthResult, err := m.ELBV2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
 		TargetGroupArn: &targetGroupArn})
+	finish(err)
 	if err != nil {
 		return notInTargetGroup, err
 	}
@@ -196,6 +219,30 @@ func (m *CloudProvider) instanceTargetGroupDrainStatus(nodeID string, targetGrou
 	return notInTargetGroup, nil
 }
 
+func (m *CloudProvider) registerNodeWithELBV2sInCluster(ctx context.Context, nodeID string, vpcID string, clusterName string) error {
+	targetGroupARNs, err := m.getELBV2TargetGroupARNsInCluster(ctx, vpcID, clusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, targetGroupARN := range targetGroupARNs {
+		log.Info().
+			Str("nodeID", nodeID).
+			Str("targetGroupArn", targetGroupARN).
+			Msg("registering node with target group")
+		finish := m.StartAPICall(ctx, "elbv2.RegisterTargets")
+		_, err := m.ELBV2.RegisterTargets(&elbv2.RegisterTargetsInput{
+			TargetGroupArn: aws.String(targetGroupARN),
+			Targets:        []*elbv2.TargetDescription{&elbv2.TargetDescription{Id: aws.String(nodeID)}},
+		})
+		finish(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func contains(lst []string, s string) bool {
 	for _, a := range lst {
 		if a == s {
@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+func init() {
+	deregister.Register("aws", New)
+	deregister.Register("kubernetes", NewCCM)
+}
+
+// Settings are the AWS provider's own settings
+type Settings struct {
+	Region string `yaml:"region"`
+}
+
+// CCMSettings are the kubernetes provider's own settings
+type CCMSettings struct {
+	Region string `yaml:"region"`
+	Cordon bool   `yaml:"cordon"`
+	Taint  string `yaml:"taint"`
+}
+
+// New builds the AWS provider with clients for the configured region
+func New(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	var settings Settings
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+	return newCloudProvider(cfg, settings.Region)
+}
+
+// NewCCM builds the kubernetes provider, which leaves deregistering nodes to the cloud
+// controller manager. The cluster is reached in-cluster or through the kubeconfig named by KUBECONFIG
+func NewCCM(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	var settings CCMSettings
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	provider, err := newCloudProvider(cfg, settings.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kube.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CCMProvider{
+		AWS:        provider,
+		Kubernetes: client,
+		Cordon:     settings.Cordon,
+		Taint:      settings.Taint,
+	}, nil
+}
+
+func newCloudProvider(cfg deregister.ProviderConfig, region string) (*CloudProvider, error) {
+	awsSession, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	awsConfig := aws.Config{Region: aws.String(region)}
+	return &CloudProvider{
+		Base:         deregister.NewBase(cfg),
+		ELB:          elb.New(awsSession, &awsConfig),
+		ELBV2:        elbv2.New(awsSession, &awsConfig),
+		EC2:          ec2.New(awsSession, &awsConfig),
+		TagSelectors: cfg.TagSelectors,
+	}, nil
+}
@@ -0,0 +1,574 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeAWS is a stateful in-memory EC2, ELB and ELBV2 backend. Deregistered instances keep
+// draining for their load balancer's delay, measured by now, before they leave it
+type fakeAWS struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	instances    map[string]*fakeInstance
+	classicELBs  map[string]*fakeClassicELB
+	elbV2s       map[string]*fakeELBV2LoadBalancer
+	targetGroups map[string]*fakeTargetGroup
+
+	// failures makes an operation, such as elbv2.DescribeTargetHealth, fail. A positive
+	// count fails that many calls, a negative count fails every call
+	failures map[string]*fakeFailure
+	calls    map[string]int
+}
+
+type fakeFailure struct {
+	err   error
+	count int
+}
+
+type fakeInstance struct {
+	id         string
+	vpcID      string
+	privateIP  string
+	tags       map[string]string
+	terminated bool
+}
+
+type fakeClassicELB struct {
+	name            string
+	vpcID           string
+	tags            map[string]string
+	drainingTimeout time.Duration
+	instances       map[string]*fakeRegistration
+}
+
+type fakeELBV2LoadBalancer struct {
+	arn             string
+	vpcID           string
+	tags            map[string]string
+	targetGroupARNs []string
+}
+
+type fakeTargetGroup struct {
+	arn     string
+	port    int64
+	delay   time.Duration
+	targets map[string]*fakeRegistration
+}
+
+// fakeRegistration is an instance's membership of a load balancer or target group
+type fakeRegistration struct {
+	state          string
+	deregisteredAt time.Time
+}
+
+func newFakeAWS() *fakeAWS {
+	return &fakeAWS{
+		now:          time.Now,
+		instances:    map[string]*fakeInstance{},
+		classicELBs:  map[string]*fakeClassicELB{},
+		elbV2s:       map[string]*fakeELBV2LoadBalancer{},
+		targetGroups: map[string]*fakeTargetGroup{},
+		failures:     map[string]*fakeFailure{},
+		calls:        map[string]int{},
+	}
+}
+
+// provider returns a provider backed by the fake, polling every millisecond
+func (f *fakeAWS) provider() *CloudProvider {
+	return &CloudProvider{
+		Base:  deregister.Base{Timeout: time.Second, PollInterval: time.Millisecond},
+		EC2:   fakeAWSEC2{f},
+		ELB:   fakeAWSELB{f},
+		ELBV2: fakeAWSELBV2{f},
+	}
+}
+
+func (f *fakeAWS) addInstance(id string, privateIP string, cluster string) {
+	f.instances[id] = &fakeInstance{
+		id:        id,
+		vpcID:     "vpc-1",
+		privateIP: privateIP,
+		tags:      map[string]string{"kubernetes.io/cluster/" + cluster: "owned"},
+	}
+}
+
+func (f *fakeAWS) addClassicELB(name string, cluster string, drainingTimeout time.Duration, instanceIDs ...string) {
+	lb := &fakeClassicELB{
+		name:            name,
+		vpcID:           "vpc-1",
+		tags:            map[string]string{"kubernetes.io/cluster/" + cluster: "owned"},
+		drainingTimeout: drainingTimeout,
+		instances:       map[string]*fakeRegistration{},
+	}
+	for _, id := range instanceIDs {
+		lb.instances[id] = &fakeRegistration{state: "InService"}
+	}
+	f.classicELBs[name] = lb
+}
+
+// addELBV2 adds a load balancer with a listener forwarding to each target group
+func (f *fakeAWS) addELBV2(arn string, cluster string, targetGroupARNs ...string) {
+	f.elbV2s[arn] = &fakeELBV2LoadBalancer{
+		arn:             arn,
+		vpcID:           "vpc-1",
+		tags:            map[string]string{"kubernetes.io/cluster/" + cluster: "owned"},
+		targetGroupARNs: targetGroupARNs,
+	}
+}
+
+func (f *fakeAWS) addTargetGroup(arn string, delay time.Duration, instanceIDs ...string) {
+	tg := &fakeTargetGroup{arn: arn, port: 30080, delay: delay, targets: map[string]*fakeRegistration{}}
+	for _, id := range instanceIDs {
+		tg.targets[id] = &fakeRegistration{state: "healthy"}
+	}
+	f.targetGroups[arn] = tg
+}
+
+// fail makes the next count calls of an operation fail, or every call if count is negative
+func (f *fakeAWS) fail(operation string, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = &fakeFailure{err: fmt.Errorf("%s: Throttling: Rate exceeded", operation), count: count}
+}
+
+// call records an operation and returns its injected failure, if any. The caller holds the lock
+func (f *fakeAWS) call(operation string) error {
+	f.calls[operation]++
+	failure, ok := f.failures[operation]
+	if !ok || failure.count == 0 {
+		return nil
+	}
+	if failure.count > 0 {
+		failure.count--
+	}
+	return failure.err
+}
+
+func (f *fakeAWS) callCount(operation string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[operation]
+}
+
+// classicState returns the instance's state at a classic ELB, or "" once it has left
+func (f *fakeAWS) classicState(name string, instanceID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if registration, ok := f.classicELBs[name].instances[instanceID]; ok {
+		return registration.state
+	}
+	return ""
+}
+
+// targetState returns the instance's state in a target group, or "" if it was never registered
+func (f *fakeAWS) targetState(arn string, instanceID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if target, ok := f.targetGroups[arn].targets[instanceID]; ok {
+		return target.state
+	}
+	return ""
+}
+
+// advance completes connection draining and deregistration delays which have passed.
+// The caller holds the lock
+func (f *fakeAWS) advance() {
+	now := f.now()
+	for _, lb := range f.classicELBs {
+		for id, registration := range lb.instances {
+			if !registration.deregisteredAt.IsZero() && now.Sub(registration.deregisteredAt) >= lb.drainingTimeout {
+				delete(lb.instances, id)
+			}
+		}
+	}
+	for _, tg := range f.targetGroups {
+		for _, target := range tg.targets {
+			if target.state == "draining" && now.Sub(target.deregisteredAt) >= tg.delay {
+				target.state = "unused"
+			}
+		}
+	}
+}
+
+type fakeAWSEC2 struct{ *fakeAWS }
+
+func (f fakeAWSEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ec2.DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	ids := aws.StringValueSlice(input.InstanceIds)
+	filters := map[string][]string{}
+	for _, filter := range input.Filters {
+		filters[aws.StringValue(filter.Name)] = aws.StringValueSlice(filter.Values)
+	}
+
+	reservation := &ec2.Reservation{}
+	for _, id := range sortedKeys(f.instances) {
+		instance := f.instances[id]
+		if (len(ids) > 0 && !contains(ids, id)) || !instance.matches(filters) {
+			continue
+		}
+		ec2Instance := &ec2.Instance{
+			InstanceId:       aws.String(id),
+			VpcId:            aws.String(instance.vpcID),
+			PrivateIpAddress: aws.String(instance.privateIP),
+			State:            &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}
+		if instance.terminated {
+			ec2Instance.VpcId = nil
+			ec2Instance.PrivateIpAddress = nil
+			ec2Instance.State.Name = aws.String(ec2.InstanceStateNameTerminated)
+		}
+		for _, key := range sortedKeys(instance.tags) {
+			ec2Instance.Tags = append(ec2Instance.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(instance.tags[key])})
+		}
+		reservation.Instances = append(reservation.Instances, ec2Instance)
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+	if len(reservation.Instances) > 0 {
+		output.Reservations = []*ec2.Reservation{reservation}
+	}
+	return output, nil
+}
+
+// matches reports whether the instance matches every supported DescribeInstances filter
+func (i *fakeInstance) matches(filters map[string][]string) bool {
+	for name, values := range filters {
+		switch name {
+		case "private-ip-address":
+			if i.terminated || !contains(values, i.privateIP) {
+				return false
+			}
+		case "instance-id":
+			if !contains(values, i.id) {
+				return false
+			}
+		case "tag-key":
+			found := false
+			for _, value := range values {
+				if _, ok := i.tags[value]; ok {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			panic("unsupported filter " + name)
+		}
+	}
+	return true
+}
+
+type fakeAWSELB struct{ *fakeAWS }
+
+func (f fakeAWSELB) DescribeLoadBalancers(input *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.DescribeLoadBalancers"); err != nil {
+		return nil, err
+	}
+
+	output := &elb.DescribeLoadBalancersOutput{}
+	for _, name := range sortedKeys(f.classicELBs) {
+		output.LoadBalancerDescriptions = append(output.LoadBalancerDescriptions, &elb.LoadBalancerDescription{
+			LoadBalancerName: aws.String(name),
+			VPCId:            aws.String(f.classicELBs[name].vpcID),
+		})
+	}
+	return output, nil
+}
+
+func (f fakeAWSELB) DescribeLoadBalancerAttributes(input *elb.DescribeLoadBalancerAttributesInput) (*elb.DescribeLoadBalancerAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.DescribeLoadBalancerAttributes"); err != nil {
+		return nil, err
+	}
+
+	lb, ok := f.classicELBs[aws.StringValue(input.LoadBalancerName)]
+	if !ok {
+		return nil, fmt.Errorf("LoadBalancerNotFound: %s", aws.StringValue(input.LoadBalancerName))
+	}
+	return &elb.DescribeLoadBalancerAttributesOutput{LoadBalancerAttributes: &elb.LoadBalancerAttributes{
+		ConnectionDraining: &elb.ConnectionDraining{
+			Enabled: aws.Bool(lb.drainingTimeout > 0),
+			Timeout: aws.Int64(int64(lb.drainingTimeout / time.Second)),
+		},
+	}}, nil
+}
+
+func (f fakeAWSELB) DescribeTags(input *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.DescribeTags"); err != nil {
+		return nil, err
+	}
+
+	output := &elb.DescribeTagsOutput{}
+	for _, name := range aws.StringValueSlice(input.LoadBalancerNames) {
+		lb, ok := f.classicELBs[name]
+		if !ok {
+			return nil, fmt.Errorf("LoadBalancerNotFound: %s", name)
+		}
+		description := &elb.TagDescription{LoadBalancerName: aws.String(name)}
+		for _, key := range sortedKeys(lb.tags) {
+			description.Tags = append(description.Tags, &elb.Tag{Key: aws.String(key), Value: aws.String(lb.tags[key])})
+		}
+		output.TagDescriptions = append(output.TagDescriptions, description)
+	}
+	return output, nil
+}
+
+func (f fakeAWSELB) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.DescribeInstanceHealth"); err != nil {
+		return nil, err
+	}
+	f.advance()
+
+	lb, ok := f.classicELBs[aws.StringValue(input.LoadBalancerName)]
+	if !ok {
+		return nil, fmt.Errorf("LoadBalancerNotFound: %s", aws.StringValue(input.LoadBalancerName))
+	}
+	output := &elb.DescribeInstanceHealthOutput{}
+	for _, id := range sortedKeys(lb.instances) {
+		state := &elb.InstanceState{InstanceId: aws.String(id), State: aws.String(lb.instances[id].state), ReasonCode: aws.String("N/A"), Description: aws.String("N/A")}
+		// instances stay InService while connection draining is in progress
+		if !lb.instances[id].deregisteredAt.IsZero() {
+			state.Description = aws.String("Instance deregistration currently in progress.")
+		}
+		output.InstanceStates = append(output.InstanceStates, state)
+	}
+	return output, nil
+}
+
+func (f fakeAWSELB) DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.DeregisterInstancesFromLoadBalancer"); err != nil {
+		return nil, err
+	}
+
+	lb, ok := f.classicELBs[aws.StringValue(input.LoadBalancerName)]
+	if !ok {
+		return nil, fmt.Errorf("LoadBalancerNotFound: %s", aws.StringValue(input.LoadBalancerName))
+	}
+	for _, instance := range input.Instances {
+		if registration, ok := lb.instances[aws.StringValue(instance.InstanceId)]; ok && registration.deregisteredAt.IsZero() {
+			registration.deregisteredAt = f.now()
+		}
+	}
+	f.advance()
+	return &elb.DeregisterInstancesFromLoadBalancerOutput{}, nil
+}
+
+func (f fakeAWSELB) RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elb.RegisterInstancesWithLoadBalancer"); err != nil {
+		return nil, err
+	}
+
+	lb, ok := f.classicELBs[aws.StringValue(input.LoadBalancerName)]
+	if !ok {
+		return nil, fmt.Errorf("LoadBalancerNotFound: %s", aws.StringValue(input.LoadBalancerName))
+	}
+	for _, instance := range input.Instances {
+		lb.instances[aws.StringValue(instance.InstanceId)] = &fakeRegistration{state: "InService"}
+	}
+	return &elb.RegisterInstancesWithLoadBalancerOutput{}, nil
+}
+
+type fakeAWSELBV2 struct{ *fakeAWS }
+
+func (f fakeAWSELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DescribeLoadBalancers"); err != nil {
+		return nil, err
+	}
+
+	output := &elbv2.DescribeLoadBalancersOutput{}
+	for _, arn := range sortedKeys(f.elbV2s) {
+		output.LoadBalancers = append(output.LoadBalancers, &elbv2.LoadBalancer{
+			LoadBalancerArn: aws.String(arn),
+			VpcId:           aws.String(f.elbV2s[arn].vpcID),
+		})
+	}
+	return output, nil
+}
+
+func (f fakeAWSELBV2) DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DescribeTags"); err != nil {
+		return nil, err
+	}
+
+	output := &elbv2.DescribeTagsOutput{}
+	for _, arn := range aws.StringValueSlice(input.ResourceArns) {
+		lb, ok := f.elbV2s[arn]
+		if !ok {
+			return nil, fmt.Errorf("LoadBalancerNotFound: %s", arn)
+		}
+		description := &elbv2.TagDescription{ResourceArn: aws.String(arn)}
+		for _, key := range sortedKeys(lb.tags) {
+			description.Tags = append(description.Tags, &elbv2.Tag{Key: aws.String(key), Value: aws.String(lb.tags[key])})
+		}
+		output.TagDescriptions = append(output.TagDescriptions, description)
+	}
+	return output, nil
+}
+
+func (f fakeAWSELBV2) DescribeListeners(input *elbv2.DescribeListenersInput) (*elbv2.DescribeListenersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DescribeListeners"); err != nil {
+		return nil, err
+	}
+
+	lb, ok := f.elbV2s[aws.StringValue(input.LoadBalancerArn)]
+	if !ok {
+		return nil, fmt.Errorf("LoadBalancerNotFound: %s", aws.StringValue(input.LoadBalancerArn))
+	}
+	output := &elbv2.DescribeListenersOutput{}
+	for _, arn := range lb.targetGroupARNs {
+		output.Listeners = append(output.Listeners, &elbv2.Listener{
+			LoadBalancerArn: input.LoadBalancerArn,
+			DefaultActions:  []*elbv2.Action{{Type: aws.String("forward"), TargetGroupArn: aws.String(arn)}},
+		})
+	}
+	return output, nil
+}
+
+func (f fakeAWSELBV2) DescribeTargetGroupAttributes(input *elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DescribeTargetGroupAttributes"); err != nil {
+		return nil, err
+	}
+
+	tg, ok := f.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, fmt.Errorf("TargetGroupNotFound: %s", aws.StringValue(input.TargetGroupArn))
+	}
+	return &elbv2.DescribeTargetGroupAttributesOutput{Attributes: []*elbv2.TargetGroupAttribute{{
+		Key:   aws.String("deregistration_delay.timeout_seconds"),
+		Value: aws.String(strconv.Itoa(int(tg.delay / time.Second))),
+	}}}, nil
+}
+
+func (f fakeAWSELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DescribeTargetHealth"); err != nil {
+		return nil, err
+	}
+	f.advance()
+
+	tg, ok := f.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, fmt.Errorf("TargetGroupNotFound: %s", aws.StringValue(input.TargetGroupArn))
+	}
+	output := &elbv2.DescribeTargetHealthOutput{}
+	for _, id := range sortedKeys(tg.targets) {
+		health := &elbv2.TargetHealth{State: aws.String(tg.targets[id].state)}
+		switch tg.targets[id].state {
+		case "draining":
+			health.Reason = aws.String("Target.DeregistrationInProgress")
+		case "unused":
+			health.Reason = aws.String("Target.NotRegistered")
+		}
+		output.TargetHealthDescriptions = append(output.TargetHealthDescriptions, &elbv2.TargetHealthDescription{
+			Target:       &elbv2.TargetDescription{Id: aws.String(id), Port: aws.Int64(tg.port)},
+			TargetHealth: health,
+		})
+	}
+	return output, nil
+}
+
+func (f fakeAWSELBV2) DeregisterTargets(input *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.DeregisterTargets"); err != nil {
+		return nil, err
+	}
+
+	tg, ok := f.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, fmt.Errorf("TargetGroupNotFound: %s", aws.StringValue(input.TargetGroupArn))
+	}
+	for _, target := range input.Targets {
+		if registration, ok := tg.targets[aws.StringValue(target.Id)]; ok && registration.state != "draining" && registration.state != "unused" {
+			registration.state = "draining"
+			registration.deregisteredAt = f.now()
+		}
+	}
+	f.advance()
+	return &elbv2.DeregisterTargetsOutput{}, nil
+}
+
+func (f fakeAWSELBV2) RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("elbv2.RegisterTargets"); err != nil {
+		return nil, err
+	}
+
+	tg, ok := f.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, fmt.Errorf("TargetGroupNotFound: %s", aws.StringValue(input.TargetGroupArn))
+	}
+	for _, target := range input.Targets {
+		tg.targets[aws.StringValue(target.Id)] = &fakeRegistration{state: "healthy"}
+	}
+	return &elbv2.RegisterTargetsOutput{}, nil
+}
+
+// sortedKeys returns a map's keys in order, so the fake responds deterministically
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch typed := m.(type) {
+	case map[string]*fakeInstance:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*fakeClassicELB:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*fakeELBV2LoadBalancer:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*fakeRegistration:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]string:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,169 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+)
+
+// defaultDeregistrationDelay is the ELBV2 target group default when the attribute is not returned
+const defaultDeregistrationDelay = 300 * time.Second
+
+// PlanDrain reports what draining the node would do, from the current state of every load balancer
+// and target group in its cluster, without changing any of them
+func (m *CloudProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	nodeID, err := m.resolveNodeID(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	vpcID, cluster, err := m.GetVPCAndClusterFromInstance(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &deregister.Plan{
+		NodeID:        nodeID,
+		ClusterName:   *cluster,
+		VPCID:         *vpcID,
+		Timeout:       m.DrainTimeout(ctx),
+		LoadBalancers: []deregister.PlannedLoadBalancer{},
+	}
+
+	elbV1Names, err := m.getELBV1s(ctx, *vpcID, *cluster)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range elbV1Names {
+		planned, err := m.planELBV1(ctx, nodeID, name)
+		if err != nil {
+			return nil, err
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, *planned)
+	}
+
+	targetGroupARNs, err := m.getELBV2TargetGroupARNsInCluster(ctx, *vpcID, *cluster)
+	if err != nil {
+		return nil, err
+	}
+	for _, arn := range targetGroupARNs {
+		planned, err := m.planELBV2TargetGroup(ctx, nodeID, arn)
+		if err != nil {
+			return nil, err
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, *planned)
+	}
+
+	if m.exclude != nil {
+		for i := range plan.LoadBalancers {
+			if plan.LoadBalancers[i].Action == deregister.PlanDeregister {
+				plan.LoadBalancers[i].Reason = "the cloud controller manager deregisters the node once it is excluded"
+			}
+		}
+	}
+
+	plan.Simulate()
+	return plan, nil
+}
+
+func (m *CloudProvider) planELBV1(ctx context.Context, nodeID string, elbV1Name string) (*deregister.PlannedLoadBalancer, error) {
+	finish := m.StartAPICall(ctx, "elb.DescribeInstanceHealth")
+	health, err := m.ELB.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: aws.String(elbV1Name)})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	planned := &deregister.PlannedLoadBalancer{
+		Name:   elbV1Name,
+		Type:   metrics.LoadBalancerELBV1,
+		State:  "NotRegistered",
+		Action: deregister.PlanNone,
+	}
+	for _, element := range health.InstanceStates {
+		if aws.StringValue(element.InstanceId) == nodeID {
+			planned.State = aws.StringValue(element.State)
+			planned.Reason = aws.StringValue(element.Description)
+			break
+		}
+	}
+
+	// matches drainNodeFromELBV1, which only deregisters nodes InService
+	if planned.State != "InService" {
+		return planned, nil
+	}
+	planned.Action = deregister.PlanDeregister
+
+	finish = m.StartAPICall(ctx, "elb.DescribeLoadBalancerAttributes")
+	attributes, err := m.ELB.DescribeLoadBalancerAttributes(&elb.DescribeLoadBalancerAttributesInput{
+		LoadBalancerName: aws.String(elbV1Name)})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if draining := attributes.LoadBalancerAttributes.ConnectionDraining; draining != nil && aws.BoolValue(draining.Enabled) {
+		planned.DeregistrationDelay = time.Duration(aws.Int64Value(draining.Timeout)) * time.Second
+	}
+	return planned, nil
+}
+
+func (m *CloudProvider) planELBV2TargetGroup(ctx context.Context, nodeID string, targetGroupArn string) (*deregister.PlannedLoadBalancer, error) {
+	finish := m.StartAPICall(ctx, "elbv2.DescribeTargetHealth")
+	health, err := m.ELBV2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupArn)})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	planned := &deregister.PlannedLoadBalancer{
+		Name:   targetGroupArn,
+		Type:   metrics.LoadBalancerELBV2,
+		State:  "NotRegistered",
+		Action: deregister.PlanNone,
+	}
+	for _, desc := range health.TargetHealthDescriptions {
+		if aws.StringValue(desc.Target.Id) == nodeID {
+			planned.State = aws.StringValue(desc.TargetHealth.State)
+			planned.Reason = aws.StringValue(desc.TargetHealth.Description)
+			break
+		}
+	}
+
+	// matches instanceTargetGroupDrainStatus
+	switch planned.State {
+	case "initial", "healthy":
+		planned.Action = deregister.PlanDeregister
+	case "draining":
+		planned.Action = deregister.PlanWait
+	default:
+		return planned, nil
+	}
+
+	finish = m.StartAPICall(ctx, "elbv2.DescribeTargetGroupAttributes")
+	attributes, err := m.ELBV2.DescribeTargetGroupAttributes(&elbv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupArn)})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	planned.DeregistrationDelay = defaultDeregistrationDelay
+	for _, attribute := range attributes.Attributes {
+		if aws.StringValue(attribute.Key) != "deregistration_delay.timeout_seconds" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(aws.StringValue(attribute.Value)); err == nil {
+			planned.DeregistrationDelay = time.Duration(seconds) * time.Second
+		}
+	}
+	return planned, nil
+}
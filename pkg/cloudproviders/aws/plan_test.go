@@ -0,0 +1,139 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func newPlanningProvider(elbState string, targetState string) (*CloudProvider, *fakeELB, *fakeELBV2) {
+	clusterTag := "kubernetes.io/cluster/clustername"
+	elbClient := &fakeELB{
+		describeELBOutput: &elb.DescribeLoadBalancersOutput{
+			LoadBalancerDescriptions: []*elb.LoadBalancerDescription{
+				{LoadBalancerName: aws.String("ELB1"), VPCId: aws.String("vpc-1")},
+			},
+		},
+		describeTagsOutput: &elb.DescribeTagsOutput{
+			TagDescriptions: []*elb.TagDescription{
+				{LoadBalancerName: aws.String("ELB1"), Tags: []*elb.Tag{{Key: aws.String(clusterTag), Value: aws.String("owned")}}},
+			},
+		},
+		descHealthOutput: &elb.DescribeInstanceHealthOutput{
+			InstanceStates: []*elb.InstanceState{
+				{InstanceId: aws.String("i-1"), State: aws.String(elbState)},
+			},
+		},
+		attributesOutput: &elb.DescribeLoadBalancerAttributesOutput{
+			LoadBalancerAttributes: &elb.LoadBalancerAttributes{
+				ConnectionDraining: &elb.ConnectionDraining{Enabled: aws.Bool(true), Timeout: aws.Int64(30)},
+			},
+		},
+	}
+	elbV2Client := &fakeELBV2{
+		describeELBOutput: &elbv2.DescribeLoadBalancersOutput{
+			LoadBalancers: []*elbv2.LoadBalancer{
+				{LoadBalancerArn: aws.String("alb-1"), VpcId: aws.String("vpc-1")},
+			},
+		},
+		describeTagsOutput: &elbv2.DescribeTagsOutput{
+			TagDescriptions: []*elbv2.TagDescription{
+				{ResourceArn: aws.String("alb-1"), Tags: []*elbv2.Tag{{Key: aws.String(clusterTag), Value: aws.String("owned")}}},
+			},
+		},
+		describeListenersOutput: &elbv2.DescribeListenersOutput{
+			Listeners: []*elbv2.Listener{
+				{DefaultActions: []*elbv2.Action{{TargetGroupArn: aws.String("tg-1")}}},
+			},
+		},
+		targetHealthOutput: &elbv2.DescribeTargetHealthOutput{
+			TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+				{Target: &elbv2.TargetDescription{Id: aws.String("i-1")}, TargetHealth: &elbv2.TargetHealth{State: aws.String(targetState)}},
+			},
+		},
+		attributesOutput: &elbv2.DescribeTargetGroupAttributesOutput{
+			Attributes: []*elbv2.TargetGroupAttribute{
+				{Key: aws.String("deregistration_delay.timeout_seconds"), Value: aws.String("120")},
+			},
+		},
+	}
+	provider := &CloudProvider{
+		EC2: &fakeEC2{describeInstancesOutput: &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{
+				VpcId: aws.String("vpc-1"),
+				Tags:  []*ec2.Tag{{Key: aws.String(clusterTag), Value: aws.String("owned")}},
+			}}}},
+		}},
+		ELB:   elbClient,
+		ELBV2: elbV2Client,
+	}
+	provider.Timeout = 90 * time.Second
+	return provider, elbClient, elbV2Client
+}
+
+func TestPlanDrain(t *testing.T) {
+	provider, _, _ := newPlanningProvider("InService", "healthy")
+
+	plan, err := provider.PlanDrain(context.Background(), "i-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if plan.ClusterName != "clustername" || plan.VPCID != "vpc-1" || len(plan.LoadBalancers) != 2 {
+		t.Fatalf("failed - unexpected plan %+v", plan)
+	}
+
+	elbV1, targetGroup := plan.LoadBalancers[0], plan.LoadBalancers[1]
+	if elbV1.Action != deregister.PlanDeregister || elbV1.DeregistrationDelay != 30*time.Second || elbV1.WouldTimeOut {
+		t.Fatalf("failed - expected ELB1 to be deregistered within 30s, got %+v", elbV1)
+	}
+	if targetGroup.Action != deregister.PlanDeregister || targetGroup.DeregistrationDelay != 2*time.Minute || !targetGroup.WouldTimeOut {
+		t.Fatalf("failed - expected tg-1 to time out after its 120s delay, got %+v", targetGroup)
+	}
+	if plan.EstimatedDuration != 90*time.Second {
+		t.Fatalf("failed - expected the estimate to be capped at the timeout, got %v", plan.EstimatedDuration)
+	}
+
+	last := plan.Timeline[len(plan.Timeline)-1]
+	if len(plan.Timeline) != 4 || last.LoadBalancer != "tg-1" || last.Event != "timed out" {
+		t.Fatalf("failed - unexpected timeline %+v", plan.Timeline)
+	}
+}
+
+func TestPlanDrainSkipsNodesWhichDoNotNeedDraining(t *testing.T) {
+	provider, _, _ := newPlanningProvider("OutOfService", "draining")
+
+	plan, err := provider.PlanDrain(context.Background(), "i-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if plan.LoadBalancers[0].Action != deregister.PlanNone || plan.LoadBalancers[0].DeregistrationDelay != 0 {
+		t.Fatalf("failed - expected no action at an OutOfService ELB, got %+v", plan.LoadBalancers[0])
+	}
+	if plan.LoadBalancers[1].Action != deregister.PlanWait {
+		t.Fatalf("failed - expected to wait for a draining target, got %+v", plan.LoadBalancers[1])
+	}
+}
+
+func TestDryRunDrainReturnsPlanWithoutBlocking(t *testing.T) {
+	provider, elbClient, elbV2Client := newPlanningProvider("InService", "healthy")
+	provider.PollInterval = time.Hour
+
+	dryRun, _ := deregister.Wrap(provider, deregister.WithDryRun(false))
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{DryRun: true})
+	result, err := dryRun.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if !result.DryRun || result.Plan == nil || len(result.LoadBalancers) != 2 || !result.LoadBalancers[0].Deregistered {
+		t.Fatalf("failed - expected the plan in the dry-run result, got %+v", result)
+	}
+	if elbClient.deregistrations != 0 || elbV2Client.deregistrations != 0 {
+		t.Fatalf("failed - expected no deregistrations in dry-run")
+	}
+}
@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ListRegistrations reports the node's health at every ELB and v2 target group in its cluster
+// which it is registered with, without changing any of them
+func (m *CloudProvider) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	ctx, span := tracing.Start(ctx, "ListRegistrations", trace.WithAttributes(attribute.String("node.name", nodeName)))
+	registrations, err := m.listRegistrations(ctx, nodeName)
+	tracing.End(span, err)
+	return registrations, err
+}
+
+func (m *CloudProvider) listRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	nodeID, err := m.resolveNodeID(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	vpcID, cluster, err := m.GetVPCAndClusterFromInstance(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := &deregister.NodeRegistrations{
+		NodeID:        nodeID,
+		ClusterName:   *cluster,
+		Registrations: []deregister.Registration{},
+	}
+
+	elbV1Names, err := m.getELBV1s(ctx, *vpcID, *cluster)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range elbV1Names {
+		registration, err := m.elbV1Registration(ctx, nodeID, name)
+		if err != nil {
+			return nil, err
+		}
+		if registration != nil {
+			registrations.Registrations = append(registrations.Registrations, *registration)
+		}
+	}
+
+	// target groups are listed under each load balancer routing to them, so they are
+	// looked up from the load balancers in the cluster rather than as a flat list
+	elbV2ARNs, err := m.getELBV2sInVPC(ctx, *vpcID)
+	if err != nil {
+		return nil, err
+	}
+	if len(elbV2ARNs) == 0 {
+		return registrations, nil
+	}
+	elbV2ARNs, err = m.filterELBV2sWithTag(ctx, elbV2ARNs, fmt.Sprintf("kubernetes.io/cluster/%s", *cluster))
+	if err != nil {
+		return nil, err
+	}
+
+	targetHealth := map[string][]*elbv2.TargetHealthDescription{}
+	for _, elbV2ARN := range elbV2ARNs {
+		targetGroupARNs, err := m.getTargetGroupsAtELB(ctx, elbV2ARN)
+		if err != nil {
+			return nil, err
+		}
+		for _, targetGroupARN := range aws.StringValueSlice(targetGroupARNs) {
+			descriptions, ok := targetHealth[targetGroupARN]
+			if !ok {
+				descriptions, err = m.describeTargetHealth(ctx, targetGroupARN)
+				if err != nil {
+					return nil, err
+				}
+				targetHealth[targetGroupARN] = descriptions
+			}
+
+			for _, desc := range descriptions {
+				if aws.StringValue(desc.Target.Id) != nodeID {
+					continue
+				}
+				registrations.Registrations = append(registrations.Registrations, deregister.Registration{
+					LoadBalancer: aws.StringValue(elbV2ARN),
+					TargetGroup:  targetGroupARN,
+					Type:         metrics.LoadBalancerELBV2,
+					Port:         aws.Int64Value(desc.Target.Port),
+					State:        aws.StringValue(desc.TargetHealth.State),
+					Reason:       aws.StringValue(desc.TargetHealth.Reason),
+					Description:  aws.StringValue(desc.TargetHealth.Description),
+				})
+			}
+		}
+	}
+
+	return registrations, nil
+}
+
+// elbV1Registration returns the node's health at the ELB, or nil if it is not registered
+func (m *CloudProvider) elbV1Registration(ctx context.Context, nodeID string, elbV1Name string) (*deregister.Registration, error) {
+	finish := m.StartAPICall(ctx, "elb.DescribeInstanceHealth")
+	health, err := m.ELB.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: &elbV1Name})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range health.InstanceStates {
+		if aws.StringValue(state.InstanceId) == nodeID {
+			return &deregister.Registration{
+				LoadBalancer: elbV1Name,
+				Type:         metrics.LoadBalancerELBV1,
+				State:        aws.StringValue(state.State),
+				Reason:       aws.StringValue(state.ReasonCode),
+				Description:  aws.StringValue(state.Description),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *CloudProvider) describeTargetHealth(ctx context.Context, targetGroupARN string) ([]*elbv2.TargetHealthDescription, error) {
+	finish := m.StartAPICall(ctx, "elbv2.DescribeTargetHealth")
+	health, err := m.ELBV2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: &targetGroupARN})
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+	return health.TargetHealthDescriptions, nil
+}
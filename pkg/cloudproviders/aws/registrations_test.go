@@ -0,0 +1,56 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func TestListRegistrations(t *testing.T) {
+	f := newScenario()
+	f.targetGroups["tg-2"].port = 30443
+	f.targetGroups["tg-2"].targets["i-1"] = &fakeRegistration{state: "draining", deregisteredAt: time.Now()}
+	f.targetGroups["tg-2"].delay = time.Hour
+	f.addTargetGroup("tg-3", 0, "i-2")
+	f.addELBV2("alb-1", "clustername", "tg-1", "tg-2", "tg-3")
+	// tg-1 is shared by two load balancers
+	f.addELBV2("alb-2", "clustername", "tg-1")
+
+	registrations, err := f.provider().ListRegistrations(context.Background(), "10.0.0.1")
+	if err != nil || registrations.NodeID != "i-1" || registrations.ClusterName != "clustername" {
+		t.Fatalf("failed - unexpected registrations %+v %v", registrations, err)
+	}
+
+	expected := []deregister.Registration{
+		{LoadBalancer: "elb-1", Type: "elbv1", State: "InService", Reason: "N/A", Description: "N/A"},
+		{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Port: 30080, State: "healthy"},
+		{LoadBalancer: "alb-1", TargetGroup: "tg-2", Type: "elbv2", Port: 30443, State: "draining", Reason: "Target.DeregistrationInProgress"},
+		{LoadBalancer: "alb-2", TargetGroup: "tg-1", Type: "elbv2", Port: 30080, State: "healthy"},
+	}
+	if len(registrations.Registrations) != len(expected) {
+		t.Fatalf("failed - expected %v registrations, got %+v", len(expected), registrations.Registrations)
+	}
+	for i, registration := range registrations.Registrations {
+		if registration != expected[i] {
+			t.Fatalf("failed - expected %+v, got %+v", expected[i], registration)
+		}
+	}
+
+	if calls := f.callCount("elbv2.DescribeTargetHealth"); calls != 3 {
+		t.Fatalf("failed - expected each target group's health to be described once, got %v calls", calls)
+	}
+	if calls := f.callCount("elb.DeregisterInstancesFromLoadBalancer") + f.callCount("elbv2.DeregisterTargets"); calls != 0 {
+		t.Fatalf("failed - expected listing to change nothing, got %v deregistrations", calls)
+	}
+}
+
+func TestListRegistrationsError(t *testing.T) {
+	f := newScenario()
+	f.fail("elbv2.DescribeListeners", -1)
+
+	if _, err := f.provider().ListRegistrations(context.Background(), "i-1"); err == nil {
+		t.Fatal("failed - expected the listener error to be returned")
+	}
+}
@@ -0,0 +1,188 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// newScenario builds a cluster with a classic ELB and an ALB forwarding to two target groups,
+// all holding i-1, alongside load balancers belonging to another cluster
+func newScenario() *fakeAWS {
+	f := newFakeAWS()
+	f.addInstance("i-1", "10.0.0.1", "clustername")
+	f.addInstance("i-2", "10.0.0.2", "clustername")
+	f.addClassicELB("elb-1", "clustername", 20*time.Millisecond, "i-1", "i-2")
+	f.addClassicELB("elb-other", "othercluster", 0, "i-1")
+	f.addTargetGroup("tg-1", 20*time.Millisecond, "i-1", "i-2")
+	f.addTargetGroup("tg-2", 40*time.Millisecond, "i-1")
+	f.addTargetGroup("tg-other", 0, "i-1")
+	f.addELBV2("alb-1", "clustername", "tg-1", "tg-2")
+	f.addELBV2("alb-other", "othercluster", "tg-other")
+	return f
+}
+
+func resultsByName(result *deregister.DrainResult) map[string]deregister.LoadBalancerResult {
+	byName := map[string]deregister.LoadBalancerResult{}
+	for _, lb := range result.LoadBalancers {
+		byName[lb.Name] = lb
+	}
+	return byName
+}
+
+func TestScenarioDrainsClusterLoadBalancers(t *testing.T) {
+	f := newScenario()
+
+	result, err := f.provider().DrainNodeFromLoadBalancer(context.Background(), "10.0.0.1")
+	if err != nil || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if result.NodeID != "i-1" || result.ClusterName != "clustername" {
+		t.Fatalf("failed - unexpected node %v in cluster %v", result.NodeID, result.ClusterName)
+	}
+
+	byName := resultsByName(result)
+	if len(byName) != 3 || !byName["elb-1"].Deregistered || !byName["tg-1"].Deregistered || !byName["tg-2"].Deregistered {
+		t.Fatalf("failed - unexpected load balancers %+v", result.LoadBalancers)
+	}
+	if result.Duration < 40*time.Millisecond {
+		t.Fatalf("failed - expected the drain to wait for the longest delay, took %v", result.Duration)
+	}
+
+	if state := f.classicState("elb-1", "i-1"); state != "" {
+		t.Fatalf("failed - expected i-1 to have left elb-1, got %v", state)
+	}
+	if f.targetState("tg-1", "i-1") != "unused" || f.targetState("tg-2", "i-1") != "unused" {
+		t.Fatal("failed - expected i-1 to be unused in both target groups")
+	}
+
+	// other nodes and other clusters are untouched
+	if f.classicState("elb-1", "i-2") != "InService" || f.targetState("tg-1", "i-2") != "healthy" {
+		t.Fatal("failed - expected i-2 to stay registered")
+	}
+	if f.classicState("elb-other", "i-1") != "InService" || f.targetState("tg-other", "i-1") != "healthy" {
+		t.Fatal("failed - expected load balancers in another cluster to be untouched")
+	}
+}
+
+func TestScenarioTimesOutOnSlowTargetGroup(t *testing.T) {
+	f := newScenario()
+	f.targetGroups["tg-2"].delay = time.Hour
+
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{Timeout: 30 * time.Millisecond})
+	result, err := f.provider().DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+
+	byName := resultsByName(result)
+	if !byName["tg-2"].TimedOut || byName["tg-1"].TimedOut || byName["elb-1"].TimedOut {
+		t.Fatalf("failed - expected only tg-2 to time out, got %+v", result.LoadBalancers)
+	}
+	if state := f.targetState("tg-2", "i-1"); state != "draining" {
+		t.Fatalf("failed - expected i-1 to still be draining from tg-2, got %v", state)
+	}
+}
+
+func TestScenarioDryRunChangesNothing(t *testing.T) {
+	f := newScenario()
+	f.targetGroups["tg-2"].delay = 2 * time.Second
+
+	provider, _ := deregister.Wrap(f.provider(), deregister.WithDryRun(false))
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{DryRun: true, Timeout: time.Minute})
+	result, err := provider.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || !result.DryRun || len(result.LoadBalancers) != 3 {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if result.Plan.EstimatedDuration != 2*time.Second {
+		t.Fatalf("failed - expected the estimate to come from tg-2, got %v", result.Plan.EstimatedDuration)
+	}
+
+	for _, operation := range []string{"elb.DeregisterInstancesFromLoadBalancer", "elbv2.DeregisterTargets"} {
+		if calls := f.callCount(operation); calls != 0 {
+			t.Fatalf("failed - expected no %v calls, got %v", operation, calls)
+		}
+	}
+	if f.classicState("elb-1", "i-1") != "InService" || f.targetState("tg-2", "i-1") != "healthy" {
+		t.Fatal("failed - expected i-1 to stay registered")
+	}
+}
+
+func TestScenarioUnknownNode(t *testing.T) {
+	f := newScenario()
+
+	_, err := f.provider().DrainNodeFromLoadBalancer(context.Background(), "10.0.0.9")
+	if err == nil {
+		t.Fatal("failed - expected an unknown IP to be an error")
+	}
+	if calls := f.callCount("elb.DescribeLoadBalancers") + f.callCount("elbv2.DescribeLoadBalancers"); calls != 0 {
+		t.Fatalf("failed - expected no load balancer lookups, got %v", calls)
+	}
+}
+
+func TestScenarioEC2Error(t *testing.T) {
+	f := newScenario()
+	f.fail("ec2.DescribeInstances", -1)
+	recorder := &fakeRecorder{}
+	provider, _ := deregister.Wrap(f.provider(), deregister.WithMetrics(recorder))
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err == nil {
+		t.Fatal("failed - expected the EC2 error to be returned")
+	}
+	if len(recorder.outcomes) != 1 || recorder.outcomes[0] != "error" {
+		t.Fatalf("failed - expected an error outcome, got %v", recorder.outcomes)
+	}
+}
+
+func TestScenarioPartialFailure(t *testing.T) {
+	f := newScenario()
+	f.fail("elb.DescribeTags", -1)
+
+	result, err := f.provider().DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err == nil {
+		t.Fatal("failed - expected the ELB error to be returned")
+	}
+
+	// the target groups are still drained
+	byName := resultsByName(result)
+	if len(byName) != 2 || !byName["tg-1"].Deregistered || !byName["tg-2"].Deregistered {
+		t.Fatalf("failed - expected the target groups to drain, got %+v", result.LoadBalancers)
+	}
+	if f.targetState("tg-2", "i-1") != "unused" || f.classicState("elb-1", "i-1") != "InService" {
+		t.Fatal("failed - expected only the target groups to be drained")
+	}
+}
+
+func TestScenarioRetriesTransientErrors(t *testing.T) {
+	f := newScenario()
+	f.fail("elbv2.DescribeTargetHealth", 3)
+	f.fail("elb.DeregisterInstancesFromLoadBalancer", 1)
+
+	result, err := f.provider().DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.TimedOut() {
+		t.Fatalf("failed - expected throttling to be retried, got %+v %v", result, err)
+	}
+	if f.classicState("elb-1", "i-1") != "" || f.targetState("tg-1", "i-1") != "unused" {
+		t.Fatal("failed - expected i-1 to drain once the errors passed")
+	}
+	if calls := f.callCount("elb.DeregisterInstancesFromLoadBalancer"); calls < 2 {
+		t.Fatalf("failed - expected the failed deregistration to be retried, got %v calls", calls)
+	}
+}
+
+func TestScenarioRestoreAfterDrain(t *testing.T) {
+	f := newScenario()
+	provider := f.provider()
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if err := provider.RestoreNodeToLoadBalancer(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if f.classicState("elb-1", "i-1") != "InService" || f.targetState("tg-1", "i-1") != "healthy" || f.targetState("tg-2", "i-1") != "healthy" {
+		t.Fatal("failed - expected i-1 to be registered again")
+	}
+}
@@ -0,0 +1,374 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ResourceAPI is the subset of Azure Resource Manager the provider uses
+type ResourceAPI interface {
+	ListScaleSets(ctx context.Context) ([]string, error)
+	ListScaleSetNetworkInterfaces(ctx context.Context, scaleSet string) ([]NetworkInterface, error)
+	GetScaleSetVM(ctx context.Context, scaleSet string, instanceID string) (map[string]interface{}, error)
+	UpdateScaleSetVM(ctx context.Context, scaleSet string, instanceID string, vm map[string]interface{}) error
+	GetLoadBalancer(ctx context.Context, name string) (*LoadBalancer, error)
+}
+
+// CloudProvider drains AKS scale set nodes from the cluster's Azure load balancer
+// backend pools. It implements the CloudProvider interface
+type CloudProvider struct {
+	deregister.Base
+	Resources   ResourceAPI
+	ClusterName string
+
+	// LoadBalancers are the cluster's load balancers, missing ones are skipped
+	LoadBalancers []string
+	// BackendPool is the name of the cluster's inbound backend pool on each load balancer
+	BackendPool string
+}
+
+// scaleSetInstance identifies a VM in a scale set
+type scaleSetInstance struct {
+	scaleSet   string
+	instanceID string
+}
+
+// clusterPool is the cluster's backend pool on one load balancer
+type clusterPool struct {
+	loadBalancer string
+	pool         BackendAddressPool
+	// probeTime is how long the load balancer's slowest probe takes to mark a backend down
+	probeTime time.Duration
+}
+
+func (p *clusterPool) name() string {
+	return p.loadBalancer + "/" + p.pool.Name
+}
+
+// DrainNodeFromLoadBalancer removes the node's IP configurations from the cluster's backend
+// pools, then waits for the load balancers to stop sending it traffic
+func (m *CloudProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling deregistration for node")
+	start := m.Now()
+	result, err := m.drainNode(ctx, nodeName)
+	result.Duration = m.Since(start)
+	return result, err
+}
+
+func (m *CloudProvider) drainNode(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result := &deregister.DrainResult{ClusterName: m.ClusterName}
+	instance, vm, err := m.getVM(ctx, nodeName)
+	if err != nil {
+		return result, err
+	}
+	result.NodeID = stringField(vm, "name")
+
+	pools, err := m.clusterPools(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	registered := []clusterPool{}
+	for _, pool := range pools {
+		deregistered := removePool(vm, pool.pool.ID)
+		result.LoadBalancers = append(result.LoadBalancers, deregister.LoadBalancerResult{
+			Name:         pool.name(),
+			Type:         metrics.LoadBalancerAzure,
+			Deregistered: deregistered,
+		})
+		if deregistered {
+			registered = append(registered, pool)
+		}
+	}
+	if len(registered) == 0 {
+		log.Info().Str("vm", result.NodeID).Msg("node is not in any cluster backend pool")
+		return result, nil
+	}
+
+	log.Info().
+		Str("vm", result.NodeID).
+		Int("backendPools", len(registered)).
+		Msg("removing node from backend pools")
+	finish := m.StartAPICall(ctx, "compute.virtualMachineScaleSetVMs.update")
+	err = m.Resources.UpdateScaleSetVM(ctx, instance.scaleSet, instance.instanceID, vm)
+	finish(err)
+	if err != nil {
+		return result, err
+	}
+	removedAt := m.Now()
+
+	var wg sync.WaitGroup
+	for i := range result.LoadBalancers {
+		if !result.LoadBalancers[i].Deregistered {
+			continue
+		}
+		wg.Add(1)
+		go func(pool clusterPool, lbResult *deregister.LoadBalancerResult) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "pollAzureBackendPool", trace.WithAttributes(attribute.String("backendPool", pool.name())))
+			defer span.End()
+			for polls := 1; ; polls++ {
+				span.SetAttributes(attribute.Int("polls", polls))
+				drained, err := m.instanceDrained(ctx, instance, pool, m.Since(removedAt))
+				if err != nil {
+					log.Warn().Err(err).Str("backendPool", pool.name()).Msg("error checking drain progress")
+				}
+
+				if drained {
+					m.Recorder().LoadBalancerDrained(m.ClusterName, metrics.LoadBalancerAzure, m.Since(removedAt), false)
+					break
+				}
+
+				if m.Since(removedAt) > m.DrainTimeout(ctx) {
+					log.Warn().
+						Str("backendPool", pool.name()).
+						Str("vm", result.NodeID).
+						Dur("timeout", m.DrainTimeout(ctx)).
+						Msg("node did not drain within timeout")
+					m.Recorder().LoadBalancerDrained(m.ClusterName, metrics.LoadBalancerAzure, m.Since(removedAt), true)
+					span.SetAttributes(attribute.Bool("timedOut", true))
+					lbResult.TimedOut = true
+					break
+				}
+
+				if m.Wait(ctx) != nil {
+					break
+				}
+			}
+		}(pools[i], &result.LoadBalancers[i])
+	}
+	wg.Wait()
+	return result, ctx.Err()
+}
+
+// instanceDrained reports whether the backend pool no longer holds the instance and the health
+// probes have had time to stop sending it traffic
+func (m *CloudProvider) instanceDrained(ctx context.Context, instance *scaleSetInstance, pool clusterPool, sinceRemoved time.Duration) (bool, error) {
+	finish := m.StartAPICall(ctx, "network.loadBalancers.get")
+	lb, err := m.Resources.GetLoadBalancer(ctx, pool.loadBalancer)
+	finish(err)
+	if err != nil {
+		return false, err
+	}
+
+	for _, current := range lb.Properties.BackendAddressPools {
+		if !strings.EqualFold(current.ID, pool.pool.ID) {
+			continue
+		}
+		for _, ipConfiguration := range current.Properties.BackendIPConfigurations {
+			if instance.owns(ipConfiguration.ID) {
+				return false, nil
+			}
+		}
+	}
+	return sinceRemoved >= pool.probeTime, nil
+}
+
+// RestoreNodeToLoadBalancer adds the cluster's backend pools to the node's primary IP configuration
+func (m *CloudProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling registration for node")
+	instance, vm, err := m.getVM(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	pools, err := m.clusterPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	added := false
+	for _, pool := range pools {
+		added = addPool(vm, pool.pool.ID) || added
+	}
+
+	if !added {
+		log.Info().
+			Str("vm", stringField(vm, "name")).
+			Msg("node already in every backend pool")
+		return nil
+	}
+
+	finish := m.StartAPICall(ctx, "compute.virtualMachineScaleSetVMs.update")
+	err = m.Resources.UpdateScaleSetVM(ctx, instance.scaleSet, instance.instanceID, vm)
+	finish(err)
+	return err
+}
+
+// DescribeNode gets the cluster and tags of the node's VM
+func (m *CloudProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	instance, vm, err := m.getVM(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	if vmTags, ok := vm["tags"].(map[string]interface{}); ok {
+		for key, value := range vmTags {
+			tags[key] = fmt.Sprintf("%v", value)
+		}
+	}
+	return &deregister.NodeInfo{
+		ID:               stringField(vm, "name"),
+		ClusterName:      m.ClusterName,
+		AutoscalingGroup: instance.scaleSet,
+		Tags:             tags,
+	}, nil
+}
+
+// PlanDrain reports what draining the node would do without changing any backend pool
+func (m *CloudProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	_, vm, err := m.getVM(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := m.clusterPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &deregister.Plan{
+		NodeID:        stringField(vm, "name"),
+		ClusterName:   m.ClusterName,
+		Timeout:       m.DrainTimeout(ctx),
+		LoadBalancers: []deregister.PlannedLoadBalancer{},
+	}
+	for _, pool := range pools {
+		planned := deregister.PlannedLoadBalancer{
+			Name:   pool.name(),
+			Type:   metrics.LoadBalancerAzure,
+			State:  "NotRegistered",
+			Action: deregister.PlanNone,
+		}
+		if hasPool(vm, pool.pool.ID) {
+			planned.State = "Registered"
+			planned.Action = deregister.PlanDeregister
+			planned.DeregistrationDelay = pool.probeTime
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, planned)
+	}
+
+	plan.Simulate()
+	return plan, nil
+}
+
+// getVM finds the node's scale set VM by computer name, private IP or resource ID
+func (m *CloudProvider) getVM(ctx context.Context, nodeName string) (*scaleSetInstance, map[string]interface{}, error) {
+	instance, err := m.findInstance(ctx, nodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	finish := m.StartAPICall(ctx, "compute.virtualMachineScaleSetVMs.get")
+	vm, err := m.Resources.GetScaleSetVM(ctx, instance.scaleSet, instance.instanceID)
+	finish(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return instance, vm, nil
+}
+
+func (m *CloudProvider) findInstance(ctx context.Context, nodeName string) (*scaleSetInstance, error) {
+	if strings.Contains(strings.ToLower(nodeName), "/virtualmachinescalesets/") {
+		return parseVMID(nodeName)
+	}
+
+	if net.ParseIP(nodeName) == nil {
+		// AKS computer names are the scale set name and a six character base 36 instance ID
+		if len(nodeName) <= 6 {
+			return nil, fmt.Errorf("%s is not a scale set computer name", nodeName)
+		}
+		id, err := strconv.ParseInt(nodeName[len(nodeName)-6:], 36, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a scale set computer name", nodeName)
+		}
+		return &scaleSetInstance{scaleSet: nodeName[:len(nodeName)-6], instanceID: strconv.FormatInt(id, 10)}, nil
+	}
+
+	finish := m.StartAPICall(ctx, "compute.virtualMachineScaleSets.list")
+	scaleSets, err := m.Resources.ListScaleSets(ctx)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+	for _, scaleSet := range scaleSets {
+		finish := m.StartAPICall(ctx, "compute.virtualMachineScaleSets.listNetworkInterfaces")
+		nics, err := m.Resources.ListScaleSetNetworkInterfaces(ctx, scaleSet)
+		finish(err)
+		if err != nil {
+			return nil, err
+		}
+		for _, nic := range nics {
+			for _, ipConfiguration := range nic.Properties.IPConfigurations {
+				if ipConfiguration.Properties.PrivateIPAddress == nodeName {
+					return parseVMID(nic.Properties.VirtualMachine.ID)
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no scale set VM found for node %s", nodeName)
+}
+
+// clusterPools gets the cluster's backend pool on each of its load balancers
+func (m *CloudProvider) clusterPools(ctx context.Context) ([]clusterPool, error) {
+	pools := []clusterPool{}
+	for _, name := range m.LoadBalancers {
+		finish := m.StartAPICall(ctx, "network.loadBalancers.get")
+		lb, err := m.Resources.GetLoadBalancer(ctx, name)
+		finish(err)
+		if IsNotFound(err) {
+			log.Debug().Str("loadBalancer", name).Msg("load balancer does not exist")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var probeTime time.Duration
+		for _, probe := range lb.Properties.Probes {
+			if t := time.Duration(probe.Properties.IntervalInSeconds*probe.Properties.NumberOfProbes) * time.Second; t > probeTime {
+				probeTime = t
+			}
+		}
+		for _, pool := range lb.Properties.BackendAddressPools {
+			if pool.Name == m.BackendPool {
+				pools = append(pools, clusterPool{loadBalancer: name, pool: pool, probeTime: probeTime})
+			}
+		}
+	}
+	return pools, nil
+}
+
+// owns reports whether a resource ID, such as a NIC IP configuration, belongs to the instance
+func (i *scaleSetInstance) owns(id string) bool {
+	prefix := fmt.Sprintf("/virtualmachinescalesets/%s/virtualmachines/%s/", i.scaleSet, i.instanceID)
+	return strings.Contains(strings.ToLower(id), strings.ToLower(prefix))
+}
+
+// parseVMID returns the scale set and instance ID from a scale set VM resource ID
+func parseVMID(id string) (*scaleSetInstance, error) {
+	parts := strings.Split(id, "/")
+	for i := 0; i+3 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "virtualMachineScaleSets") && strings.EqualFold(parts[i+2], "virtualMachines") {
+			return &scaleSetInstance{scaleSet: parts[i+1], instanceID: parts[i+3]}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a scale set VM ID", id)
+}
@@ -0,0 +1,204 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+const (
+	resourceGroupID = "/subscriptions/s/resourceGroups/mc_rg"
+	publicPoolID    = resourceGroupID + "/providers/Microsoft.Network/loadBalancers/kubernetes/backendAddressPools/kubernetes"
+	outboundPoolID  = resourceGroupID + "/providers/Microsoft.Network/loadBalancers/kubernetes/backendAddressPools/aksOutboundBackendPool"
+	vmID            = resourceGroupID + "/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1-123-vmss/virtualMachines/10"
+	ipConfigID      = vmID + "/networkInterfaces/nic/ipConfigurations/ipconfig1"
+)
+
+const vmModel = `{
+	"name": "aks-nodepool1-123-vmss_10",
+	"tags": {"team": "payments"},
+	"properties": {
+		"networkProfileConfiguration": {
+			"networkInterfaceConfigurations": [{
+				"properties": {
+					"primary": true,
+					"enableAcceleratedNetworking": true,
+					"ipConfigurations": [{
+						"name": "ipconfig1",
+						"properties": {
+							"primary": true,
+							"loadBalancerBackendAddressPools": [{"id": "` + publicPoolID + `"}, {"id": "` + outboundPoolID + `"}]
+						}
+					}]
+				}
+			}]
+		}
+	}
+}`
+
+// fakeResources applies VM model updates to the backend pool membership the load balancer reports
+type fakeResources struct {
+	vm      map[string]interface{}
+	lb      *LoadBalancer
+	updates int
+	err     error
+}
+
+func newFakeResources(t *testing.T) *fakeResources {
+	f := &fakeResources{lb: &LoadBalancer{Name: "kubernetes"}}
+	if err := json.Unmarshal([]byte(vmModel), &f.vm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{publicPoolID, outboundPoolID} {
+		pool := BackendAddressPool{ID: id, Name: id[strings.LastIndex(id, "/")+1:]}
+		pool.Properties.BackendIPConfigurations = []IDReference{{ID: ipConfigID}}
+		f.lb.Properties.BackendAddressPools = append(f.lb.Properties.BackendAddressPools, pool)
+	}
+	return f
+}
+
+func (f *fakeResources) ListScaleSets(ctx context.Context) ([]string, error) {
+	return []string{"aks-nodepool1-123-vmss"}, f.err
+}
+
+func (f *fakeResources) ListScaleSetNetworkInterfaces(ctx context.Context, scaleSet string) ([]NetworkInterface, error) {
+	nic := NetworkInterface{}
+	nic.Properties.VirtualMachine.ID = vmID
+	nic.Properties.IPConfigurations = make([]struct {
+		Properties struct {
+			PrivateIPAddress string `json:"privateIPAddress"`
+		} `json:"properties"`
+	}, 1)
+	nic.Properties.IPConfigurations[0].Properties.PrivateIPAddress = "10.240.0.4"
+	return []NetworkInterface{nic}, f.err
+}
+
+func (f *fakeResources) GetScaleSetVM(ctx context.Context, scaleSet string, instanceID string) (map[string]interface{}, error) {
+	if scaleSet != "aks-nodepool1-123-vmss" || instanceID != "10" {
+		return nil, &StatusError{StatusCode: http.StatusNotFound}
+	}
+	return f.vm, f.err
+}
+
+func (f *fakeResources) UpdateScaleSetVM(ctx context.Context, scaleSet string, instanceID string, vm map[string]interface{}) error {
+	f.updates++
+	f.vm = vm
+	for i := range f.lb.Properties.BackendAddressPools {
+		pool := &f.lb.Properties.BackendAddressPools[i]
+		pool.Properties.BackendIPConfigurations = nil
+		if hasPool(vm, pool.ID) {
+			pool.Properties.BackendIPConfigurations = []IDReference{{ID: ipConfigID}}
+		}
+	}
+	return f.err
+}
+
+func (f *fakeResources) GetLoadBalancer(ctx context.Context, name string) (*LoadBalancer, error) {
+	if name != "kubernetes" {
+		return nil, &StatusError{StatusCode: http.StatusNotFound}
+	}
+	return f.lb, f.err
+}
+
+func newProvider(resources *fakeResources) *CloudProvider {
+	return &CloudProvider{
+		Base:          deregister.Base{Timeout: time.Second, PollInterval: time.Millisecond},
+		Resources:     resources,
+		ClusterName:   "prod",
+		LoadBalancers: []string{"kubernetes", "kubernetes-internal"},
+		BackendPool:   "kubernetes",
+	}
+}
+
+func TestDrainRemovesNodeFromClusterBackendPool(t *testing.T) {
+	resources := newFakeResources(t)
+	provider := newProvider(resources)
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "aks-nodepool1-123-vmss00000a")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if result.NodeID != "aks-nodepool1-123-vmss_10" || len(result.LoadBalancers) != 1 || !result.LoadBalancers[0].Deregistered || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v", result)
+	}
+	if hasPool(resources.vm, publicPoolID) || !hasPool(resources.vm, outboundPoolID) {
+		t.Fatalf("failed - expected only the inbound pool to be removed")
+	}
+
+	configuration := ipConfigurations(resources.vm)[0]
+	nic := resources.vm["properties"].(map[string]interface{})["networkProfileConfiguration"].(map[string]interface{})["networkInterfaceConfigurations"].([]interface{})[0]
+	if nic.(map[string]interface{})["properties"].(map[string]interface{})["enableAcceleratedNetworking"] != true || configuration["primary"] != true {
+		t.Fatalf("failed - expected the rest of the VM model to be preserved")
+	}
+
+	// a second drain finds nothing to remove
+	result, err = provider.DrainNodeFromLoadBalancer(context.Background(), vmID)
+	if err != nil || result.LoadBalancers[0].Deregistered || resources.updates != 1 {
+		t.Fatalf("failed - expected no further updates, got %+v %v", result, err)
+	}
+
+	if err := provider.RestoreNodeToLoadBalancer(context.Background(), "10.240.0.4"); err != nil || !hasPool(resources.vm, publicPoolID) {
+		t.Fatalf("failed - expected the pool to be restored, got %v", err)
+	}
+}
+
+func TestDrainWaitsForHealthProbes(t *testing.T) {
+	resources := newFakeResources(t)
+	resources.lb.Properties.Probes = make([]Probe, 1)
+	resources.lb.Properties.Probes[0].Properties.IntervalInSeconds = 5
+	resources.lb.Properties.Probes[0].Properties.NumberOfProbes = 2
+	provider := newProvider(resources)
+	provider.Timeout = 20 * time.Millisecond
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "aks-nodepool1-123-vmss00000a")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected the drain to wait out the 10s of probes, got %+v %v", result, err)
+	}
+}
+
+func TestDryRunPlansWithoutUpdating(t *testing.T) {
+	resources := newFakeResources(t)
+	resources.lb.Properties.Probes = make([]Probe, 1)
+	resources.lb.Properties.Probes[0].Properties.IntervalInSeconds = 5
+	resources.lb.Properties.Probes[0].Properties.NumberOfProbes = 2
+	provider, _ := deregister.Wrap(newProvider(resources), deregister.WithDryRun(true))
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "10.240.0.4")
+	if err != nil || resources.updates != 0 {
+		t.Fatalf("failed - expected a dry-run without updates, got %v %v", err, resources.updates)
+	}
+	if result.Plan.LoadBalancers[0].DeregistrationDelay != 10*time.Second || result.Plan.LoadBalancers[0].Name != "kubernetes/kubernetes" {
+		t.Fatalf("failed - unexpected plan %+v", result.Plan)
+	}
+}
+
+func TestFindInstance(t *testing.T) {
+	provider := newProvider(newFakeResources(t))
+
+	for _, name := range []string{"aks-nodepool1-123-vmss00000a", "10.240.0.4", "azure://" + vmID} {
+		instance, err := provider.findInstance(context.Background(), name)
+		if err != nil || instance.scaleSet != "aks-nodepool1-123-vmss" || instance.instanceID != "10" {
+			t.Fatalf("failed - unexpected instance for %v: %+v %v", name, instance, err)
+		}
+	}
+	for _, name := range []string{"node", "10.0.0.1", "aks-nodepool1-vmss!!!!!!"} {
+		if _, err := provider.findInstance(context.Background(), name); err == nil {
+			t.Fatalf("failed - expected %v not to be found", name)
+		}
+	}
+}
+
+func TestDescribeNode(t *testing.T) {
+	provider := newProvider(newFakeResources(t))
+
+	info, err := provider.DescribeNode(context.Background(), "aks-nodepool1-123-vmss00000a")
+	if err != nil || info.ClusterName != "prod" || info.AutoscalingGroup != "aks-nodepool1-123-vmss" || info.Tags["team"] != "payments" {
+		t.Fatalf("failed - unexpected node info %+v %v", info, err)
+	}
+}
@@ -0,0 +1,260 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEndpoint         = "https://management.azure.com"
+	defaultIdentityEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	computeAPIVersion       = "2022-03-01"
+	networkAPIVersion       = "2022-05-01"
+)
+
+// IDReference refers to another resource by ID
+type IDReference struct {
+	ID string `json:"id"`
+}
+
+// NetworkInterface is the subset of a scale set VM's NIC needed to find it by IP
+type NetworkInterface struct {
+	ID         string `json:"id"`
+	Properties struct {
+		VirtualMachine   IDReference `json:"virtualMachine"`
+		IPConfigurations []struct {
+			Properties struct {
+				PrivateIPAddress string `json:"privateIPAddress"`
+			} `json:"properties"`
+		} `json:"ipConfigurations"`
+	} `json:"properties"`
+}
+
+// LoadBalancer is the subset of an Azure load balancer needed to follow a drain
+type LoadBalancer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		BackendAddressPools []BackendAddressPool `json:"backendAddressPools"`
+		Probes              []Probe              `json:"probes"`
+	} `json:"properties"`
+}
+
+// BackendAddressPool lists the IP configurations receiving a load balancer's traffic
+type BackendAddressPool struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		BackendIPConfigurations []IDReference `json:"backendIPConfigurations"`
+	} `json:"properties"`
+}
+
+// Probe is a load balancer health probe
+type Probe struct {
+	Properties struct {
+		IntervalInSeconds int `json:"intervalInSeconds"`
+		NumberOfProbes    int `json:"numberOfProbes"`
+	} `json:"properties"`
+}
+
+// StatusError is returned when Azure Resource Manager responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("azure api returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether the error is a 404 from Azure Resource Manager
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// Client is a minimal JSON client for the Azure Resource Manager resources of one resource group
+type Client struct {
+	Endpoint       string
+	SubscriptionID string
+	ResourceGroup  string
+	HTTP           *http.Client
+
+	// tokenSource supplies access tokens, by default from the managed identity endpoint
+	tokenSource func() (string, error)
+}
+
+// NewClient creates a client authenticated with the VM's managed identity. identityClientID
+// selects a user assigned identity, and is empty for the system assigned identity
+func NewClient(subscriptionID string, resourceGroup string, endpoint string, identityEndpoint string, identityClientID string) *Client {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if identityEndpoint == "" {
+		identityEndpoint = defaultIdentityEndpoint
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	source := &identityTokenSource{endpoint: identityEndpoint, clientID: identityClientID, resource: endpoint + "/", http: httpClient}
+	return &Client{
+		Endpoint:       endpoint,
+		SubscriptionID: subscriptionID,
+		ResourceGroup:  resourceGroup,
+		HTTP:           httpClient,
+		tokenSource:    source.Token,
+	}
+}
+
+// ListScaleSets lists the names of the scale sets in the resource group
+func (c *Client) ListScaleSets(ctx context.Context) ([]string, error) {
+	var page struct {
+		Value []struct {
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	err := c.do(ctx, "GET", "/providers/Microsoft.Compute/virtualMachineScaleSets", computeAPIVersion, nil, &page)
+	names := []string{}
+	for _, scaleSet := range page.Value {
+		names = append(names, scaleSet.Name)
+	}
+	return names, err
+}
+
+// ListScaleSetNetworkInterfaces lists the NICs of every VM in a scale set
+func (c *Client) ListScaleSetNetworkInterfaces(ctx context.Context, scaleSet string) ([]NetworkInterface, error) {
+	var page struct {
+		Value []NetworkInterface `json:"value"`
+	}
+	err := c.do(ctx, "GET", "/providers/Microsoft.Compute/virtualMachineScaleSets/"+scaleSet+"/networkInterfaces", "2018-10-01", nil, &page)
+	return page.Value, err
+}
+
+// GetScaleSetVM gets a scale set VM's complete model, so it can be changed and written back
+func (c *Client) GetScaleSetVM(ctx context.Context, scaleSet string, instanceID string) (map[string]interface{}, error) {
+	vm := map[string]interface{}{}
+	return vm, c.do(ctx, "GET", "/providers/Microsoft.Compute/virtualMachineScaleSets/"+scaleSet+"/virtualMachines/"+instanceID, computeAPIVersion, nil, &vm)
+}
+
+// UpdateScaleSetVM writes a scale set VM's model. The update completes asynchronously
+func (c *Client) UpdateScaleSetVM(ctx context.Context, scaleSet string, instanceID string, vm map[string]interface{}) error {
+	return c.do(ctx, "PUT", "/providers/Microsoft.Compute/virtualMachineScaleSets/"+scaleSet+"/virtualMachines/"+instanceID, computeAPIVersion, vm, nil)
+}
+
+// GetLoadBalancer gets a load balancer with its backend pools and probes
+func (c *Client) GetLoadBalancer(ctx context.Context, name string) (*LoadBalancer, error) {
+	lb := &LoadBalancer{}
+	return lb, c.do(ctx, "GET", "/providers/Microsoft.Network/loadBalancers/"+name, networkAPIVersion, nil, lb)
+}
+
+// do sends a request for a path in the resource group, decoding a JSON response into out if not nil
+func (c *Client) do(ctx context.Context, method string, path string, apiVersion string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	target := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s%s?api-version=%s", c.Endpoint, c.SubscriptionID, c.ResourceGroup, path, apiVersion)
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.tokenSource != nil {
+		token, err := c.tokenSource()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// identityTokenSource gets managed identity access tokens from the instance metadata
+// service, caching them until shortly before they expire
+type identityTokenSource struct {
+	endpoint string
+	clientID string
+	resource string
+	http     *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *identityTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Add(time.Minute).Before(s.expires) {
+		return s.token, nil
+	}
+
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {s.resource}}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest("GET", s.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("managed identity endpoint returned status %d for access token", resp.StatusCode)
+	}
+
+	// expires_in is a string of seconds
+	var token struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	seconds, _ := token.ExpiresIn.Int64()
+
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(seconds) * time.Second)
+	return s.token, nil
+}
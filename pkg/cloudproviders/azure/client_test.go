@@ -0,0 +1,56 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer token" {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if request.URL.Path != "/subscriptions/s/resourceGroups/mc_rg/providers/Microsoft.Network/loadBalancers/kubernetes" || request.URL.Query().Get("api-version") != networkAPIVersion {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.Write([]byte(`{"name": "kubernetes", "properties": {"backendAddressPools": [{"name": "kubernetes"}]}}`))
+	}))
+	defer server.Close()
+
+	client := &Client{Endpoint: server.URL, SubscriptionID: "s", ResourceGroup: "mc_rg", tokenSource: func() (string, error) { return "token", nil }}
+	lb, err := client.GetLoadBalancer(context.Background(), "kubernetes")
+	if err != nil || len(lb.Properties.BackendAddressPools) != 1 {
+		t.Fatalf("failed - unexpected load balancer %+v %v", lb, err)
+	}
+
+	if _, err := client.GetLoadBalancer(context.Background(), "kubernetes-internal"); !IsNotFound(err) {
+		t.Fatalf("failed - expected not found, got %v", err)
+	}
+}
+
+func TestIdentityTokenSource(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.Header.Get("Metadata") != "true" || request.URL.Query().Get("client_id") != "identity" {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response.Write([]byte(`{"access_token": "abc", "expires_in": "3599"}`))
+	}))
+	defer server.Close()
+
+	source := &identityTokenSource{endpoint: server.URL, clientID: "identity", resource: defaultEndpoint + "/", http: http.DefaultClient}
+	for i := 0; i < 2; i++ {
+		if token, err := source.Token(); err != nil || token != "abc" {
+			t.Fatalf("failed - expected the access token, got %v %v", token, err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("failed - expected the token to be cached, got %v requests", requests)
+	}
+}
@@ -0,0 +1,49 @@
+package azure
+
+import (
+	"errors"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func init() {
+	deregister.Register("azure", New)
+}
+
+// Settings are the Azure provider's own settings
+type Settings struct {
+	SubscriptionID string `yaml:"subscriptionId"`
+	// ResourceGroup is the AKS node resource group, usually MC_<group>_<cluster>_<region>
+	ResourceGroup    string   `yaml:"resourceGroup"`
+	ClusterName      string   `yaml:"clusterName"`
+	LoadBalancers    []string `yaml:"loadBalancers"`
+	BackendPool      string   `yaml:"backendPool"`
+	Endpoint         string   `yaml:"endpoint"`
+	IdentityEndpoint string   `yaml:"identityEndpoint"`
+	IdentityClientID string   `yaml:"identityClientId"`
+}
+
+// New builds the Azure provider for the configured node resource group
+func New(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	if len(cfg.TagSelectors) > 0 {
+		return nil, errors.New("tagSelectors (LB_TAG_SELECTORS) are not supported by the azure provider")
+	}
+	settings := Settings{
+		LoadBalancers: []string{"kubernetes", "kubernetes-internal"},
+		BackendPool:   "kubernetes",
+	}
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings.SubscriptionID == "" || settings.ResourceGroup == "" {
+		return nil, errors.New("providers.azure.subscriptionId and providers.azure.resourceGroup are required")
+	}
+
+	return &CloudProvider{
+		Base:          deregister.NewBase(cfg),
+		Resources:     NewClient(settings.SubscriptionID, settings.ResourceGroup, settings.Endpoint, settings.IdentityEndpoint, settings.IdentityClientID),
+		ClusterName:   settings.ClusterName,
+		LoadBalancers: settings.LoadBalancers,
+		BackendPool:   settings.BackendPool,
+	}, nil
+}
@@ -0,0 +1,89 @@
+package azure
+
+import "strings"
+
+// The scale set VM model is kept as decoded JSON so writing it back preserves every
+// setting, and these helpers change only the backend pools of its IP configurations
+
+// ipConfigurations returns the properties of every IP configuration of every NIC, primary first
+func ipConfigurations(vm map[string]interface{}) []map[string]interface{} {
+	var primary, others []map[string]interface{}
+	properties, _ := vm["properties"].(map[string]interface{})
+	network, _ := properties["networkProfileConfiguration"].(map[string]interface{})
+	nics, _ := network["networkInterfaceConfigurations"].([]interface{})
+	for _, nic := range nics {
+		nicMap, _ := nic.(map[string]interface{})
+		nicProperties, _ := nicMap["properties"].(map[string]interface{})
+		nicPrimary, _ := nicProperties["primary"].(bool)
+		configurations, _ := nicProperties["ipConfigurations"].([]interface{})
+		for _, configuration := range configurations {
+			configurationMap, _ := configuration.(map[string]interface{})
+			configurationProperties, ok := configurationMap["properties"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			configurationPrimary, _ := configurationProperties["primary"].(bool)
+			if nicPrimary && configurationPrimary {
+				primary = append(primary, configurationProperties)
+			} else {
+				others = append(others, configurationProperties)
+			}
+		}
+	}
+	return append(primary, others...)
+}
+
+func backendPools(configuration map[string]interface{}) []interface{} {
+	pools, _ := configuration["loadBalancerBackendAddressPools"].([]interface{})
+	return pools
+}
+
+func poolID(pool interface{}) string {
+	poolMap, _ := pool.(map[string]interface{})
+	id, _ := poolMap["id"].(string)
+	return id
+}
+
+// hasPool reports whether any IP configuration is in the backend pool
+func hasPool(vm map[string]interface{}, id string) bool {
+	for _, configuration := range ipConfigurations(vm) {
+		for _, pool := range backendPools(configuration) {
+			if strings.EqualFold(poolID(pool), id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removePool removes the backend pool from every IP configuration, reporting whether any held it
+func removePool(vm map[string]interface{}, id string) bool {
+	removed := false
+	for _, configuration := range ipConfigurations(vm) {
+		kept := []interface{}{}
+		for _, pool := range backendPools(configuration) {
+			if strings.EqualFold(poolID(pool), id) {
+				removed = true
+				continue
+			}
+			kept = append(kept, pool)
+		}
+		configuration["loadBalancerBackendAddressPools"] = kept
+	}
+	return removed
+}
+
+// addPool adds the backend pool to the primary IP configuration unless an IP configuration holds it
+func addPool(vm map[string]interface{}, id string) bool {
+	configurations := ipConfigurations(vm)
+	if len(configurations) == 0 || hasPool(vm, id) {
+		return false
+	}
+	configurations[0]["loadBalancerBackendAddressPools"] = append(backendPools(configurations[0]), map[string]interface{}{"id": id})
+	return true
+}
+
+func stringField(vm map[string]interface{}, name string) string {
+	value, _ := vm[name].(string)
+	return value
+}
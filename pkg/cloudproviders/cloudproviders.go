@@ -0,0 +1,74 @@
+// Package cloudproviders builds the configured cloud provider from the deregister registry.
+// Importing it registers every provider
+package cloudproviders
+
+import (
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/notify"
+	"github.com/rs/zerolog/log"
+
+	// Providers register themselves with deregister.Register
+	_ "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/aws"
+	_ "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/azure"
+	_ "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/gcp"
+	_ "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/metallb"
+	_ "github.com/briankopp/hasta-la-vista/pkg/cloudproviders/openstack"
+)
+
+// Build creates the configured cloud provider, wrapped with dry-run, metrics and tracing, and
+// with retries, Kubernetes events and notifications when they are enabled
+func Build(cfg *config.Config, recorder metrics.Recorder) (deregister.CloudProvider, error) {
+	log.Info().Str("cloudProvider", cfg.CloudProvider).Msg("building cloud provider")
+	provider, err := deregister.New(cfg.ProviderConfig(recorder))
+	if err != nil {
+		return nil, err
+	}
+
+	return deregister.Wrap(provider,
+		deregister.WithDryRun(cfg.DryRun),
+		deregister.WithMetrics(recorder),
+		deregister.WithTracing(),
+		deregister.WithRetries(cfg.DrainAttempts, cfg.PollInterval.Duration()),
+		withKubernetesEvents(cfg),
+		withNotifications(cfg),
+	)
+}
+
+// withKubernetesEvents records drains on the Kubernetes Node when enabled, reaching the
+// cluster in-cluster or through the kubeconfig named by KUBECONFIG
+func withKubernetesEvents(cfg *config.Config) deregister.Wrapper {
+	return func(provider deregister.CloudProvider) (deregister.CloudProvider, error) {
+		if !cfg.KubernetesEvents {
+			return provider, nil
+		}
+
+		client, err := kube.NewClient()
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to create kubernetes client, not recording node events")
+			return provider, nil
+		}
+
+		log.Info().Msg("recording drains as kubernetes node events")
+		return &kube.EventRecorder{Forwarder: deregister.Forwarder{Provider: provider}, Client: client}, nil
+	}
+}
+
+// withNotifications sends drain notifications to the webhooks in the notifiers file when set
+func withNotifications(cfg *config.Config) deregister.Wrapper {
+	return func(provider deregister.CloudProvider) (deregister.CloudProvider, error) {
+		if cfg.NotifiersFile == "" {
+			return provider, nil
+		}
+
+		notifiers, err := notify.Load(cfg.NotifiersFile)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Info().Str("notifiersFile", cfg.NotifiersFile).Int("notifiers", len(notifiers)).Msg("loaded drain notifiers")
+		return &notify.Provider{Forwarder: deregister.Forwarder{Provider: provider}, Notifiers: notifiers}, nil
+	}
+}
@@ -0,0 +1,22 @@
+package cloudproviders
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func TestProvidersRejectUnsupportedTagSelectors(t *testing.T) {
+	for _, name := range []string{"gcp", "azure", "openstack", "metallb"} {
+		_, err := deregister.New(deregister.ProviderConfig{Name: name, TagSelectors: map[string]string{"team": "payments"}})
+		if err == nil || !strings.Contains(err.Error(), "LB_TAG_SELECTORS") {
+			t.Fatalf("failed - expected tag selectors to be rejected for %v, got %v", name, err)
+		}
+	}
+
+	cfg := deregister.ProviderConfig{Name: "aws", TagSelectors: map[string]string{"team": "payments"}, Settings: map[string]interface{}{"region": "us-east-1"}}
+	if _, err := deregister.New(cfg); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEndpoint         = "https://compute.googleapis.com/compute/v1"
+	defaultMetadataEndpoint = "http://metadata.google.internal"
+)
+
+// Instance is the subset of a Compute instance needed to drain it
+type Instance struct {
+	Name              string            `json:"name"`
+	Zone              string            `json:"zone"`
+	SelfLink          string            `json:"selfLink"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		Network   string `json:"network"`
+		NetworkIP string `json:"networkIP"`
+	} `json:"networkInterfaces"`
+}
+
+// TargetPool is a legacy network load balancer pool of instances
+type TargetPool struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	SelfLink    string   `json:"selfLink"`
+	Instances   []string `json:"instances"`
+}
+
+// BackendService is a regional backend service of instance groups
+type BackendService struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SelfLink    string `json:"selfLink"`
+	Backends    []struct {
+		Group string `json:"group"`
+	} `json:"backends"`
+	ConnectionDraining *struct {
+		DrainingTimeoutSec int64 `json:"drainingTimeoutSec"`
+	} `json:"connectionDraining"`
+}
+
+// HealthStatus is the health of one instance behind a backend service
+type HealthStatus struct {
+	Instance    string `json:"instance"`
+	HealthState string `json:"healthState"`
+}
+
+// StatusError is returned when the Compute API responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("compute api returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Client is a minimal JSON client for the Compute Engine API of a single project
+type Client struct {
+	Endpoint string
+	Project  string
+	HTTP     *http.Client
+
+	// tokenSource supplies access tokens, by default from the metadata server
+	tokenSource func() (string, error)
+}
+
+// NewClient creates a client authenticated as the instance's service account, which on
+// GKE with workload identity is the service account bound to the pod
+func NewClient(project string, endpoint string, metadataEndpoint string) *Client {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if metadataEndpoint == "" {
+		metadataEndpoint = defaultMetadataEndpoint
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return &Client{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Project:     project,
+		HTTP:        httpClient,
+		tokenSource: (&metadataTokenSource{endpoint: metadataEndpoint, http: httpClient}).Token,
+	}
+}
+
+// AggregatedInstances lists the instances in every zone matching a Compute filter expression,
+// or every instance if the filter is empty
+func (c *Client) AggregatedInstances(ctx context.Context, filter string) ([]Instance, error) {
+	var instances []Instance
+	path := "/aggregated/instances"
+	if filter != "" {
+		path += "?filter=" + url.QueryEscape(filter)
+	}
+	err := c.list(ctx, path, func(body []byte) error {
+		var page struct {
+			Items map[string]struct {
+				Instances []Instance `json:"instances"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		for _, scope := range page.Items {
+			instances = append(instances, scope.Instances...)
+		}
+		return nil
+	})
+	return instances, err
+}
+
+// ListTargetPools lists the target pools in a region
+func (c *Client) ListTargetPools(ctx context.Context, region string) ([]TargetPool, error) {
+	var pools []TargetPool
+	err := c.list(ctx, "/regions/"+region+"/targetPools", func(body []byte) error {
+		var page struct {
+			Items []TargetPool `json:"items"`
+		}
+		err := json.Unmarshal(body, &page)
+		pools = append(pools, page.Items...)
+		return err
+	})
+	return pools, err
+}
+
+// GetTargetPool gets a target pool by name
+func (c *Client) GetTargetPool(ctx context.Context, region string, name string) (*TargetPool, error) {
+	pool := &TargetPool{}
+	return pool, c.do(ctx, "GET", "/regions/"+region+"/targetPools/"+name, nil, pool)
+}
+
+// RemoveTargetPoolInstance removes an instance from a target pool
+func (c *Client) RemoveTargetPoolInstance(ctx context.Context, region string, pool string, instance string) error {
+	return c.do(ctx, "POST", "/regions/"+region+"/targetPools/"+pool+"/removeInstance", instanceReferences(instance), nil)
+}
+
+// ListBackendServices lists the backend services in a region
+func (c *Client) ListBackendServices(ctx context.Context, region string) ([]BackendService, error) {
+	var services []BackendService
+	err := c.list(ctx, "/regions/"+region+"/backendServices", func(body []byte) error {
+		var page struct {
+			Items []BackendService `json:"items"`
+		}
+		err := json.Unmarshal(body, &page)
+		services = append(services, page.Items...)
+		return err
+	})
+	return services, err
+}
+
+// ListInstanceGroupInstances lists the URLs of the instances in a zonal instance group
+func (c *Client) ListInstanceGroupInstances(ctx context.Context, zone string, group string) ([]string, error) {
+	var page struct {
+		Items []struct {
+			Instance string `json:"instance"`
+		} `json:"items"`
+	}
+	err := c.do(ctx, "POST", "/zones/"+zone+"/instanceGroups/"+group+"/listInstances", map[string]string{"instanceState": "ALL"}, &page)
+	instances := []string{}
+	for _, item := range page.Items {
+		instances = append(instances, item.Instance)
+	}
+	return instances, err
+}
+
+// RemoveInstanceGroupInstance removes an instance from an unmanaged instance group, starting
+// connection draining at every backend service using the group
+func (c *Client) RemoveInstanceGroupInstance(ctx context.Context, zone string, group string, instance string) error {
+	return c.do(ctx, "POST", "/zones/"+zone+"/instanceGroups/"+group+"/removeInstances", instanceReferences(instance), nil)
+}
+
+// GetBackendServiceHealth gets the health of the instances of one group behind a backend service
+func (c *Client) GetBackendServiceHealth(ctx context.Context, region string, service string, group string) ([]HealthStatus, error) {
+	var health struct {
+		HealthStatus []HealthStatus `json:"healthStatus"`
+	}
+	err := c.do(ctx, "POST", "/regions/"+region+"/backendServices/"+service+"/getHealth", map[string]string{"group": group}, &health)
+	return health.HealthStatus, err
+}
+
+func instanceReferences(instance string) interface{} {
+	return map[string]interface{}{"instances": []map[string]string{{"instance": instance}}}
+}
+
+// list follows nextPageToken, passing each page to handle
+func (c *Client) list(ctx context.Context, path string, handle func(body []byte) error) error {
+	pageToken := ""
+	for {
+		pagePath := path
+		if pageToken != "" {
+			separator := "?"
+			if strings.Contains(path, "?") {
+				separator = "&"
+			}
+			pagePath += separator + "pageToken=" + url.QueryEscape(pageToken)
+		}
+
+		var body json.RawMessage
+		if err := c.do(ctx, "GET", pagePath, nil, &body); err != nil {
+			return err
+		}
+		if err := handle(body); err != nil {
+			return err
+		}
+
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// do sends a request for a path in the project, decoding a JSON response into out if not nil
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.Endpoint+"/projects/"+c.Project+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.tokenSource != nil {
+		token, err := c.tokenSource()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// metadataTokenSource gets the default service account's access token from the metadata
+// server, caching it until shortly before it expires
+type metadataTokenSource struct {
+	endpoint string
+	http     *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *metadataTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Add(time.Minute).Before(s.expires) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequest("GET", s.endpoint+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d for access token", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
@@ -0,0 +1,84 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientListsEveryPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer token" {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if request.URL.Path != "/projects/p/regions/us-central1/targetPools" {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if request.URL.Query().Get("pageToken") == "" {
+			response.Write([]byte(`{"items": [{"name": "a"}], "nextPageToken": "next"}`))
+			return
+		}
+		response.Write([]byte(`{"items": [{"name": "b"}]}`))
+	}))
+	defer server.Close()
+
+	client := &Client{Endpoint: server.URL, Project: "p", tokenSource: func() (string, error) { return "token", nil }}
+	pools, err := client.ListTargetPools(context.Background(), "us-central1")
+	if err != nil || len(pools) != 2 || pools[1].Name != "b" {
+		t.Fatalf("failed - expected both pages, got %+v %v", pools, err)
+	}
+
+	if _, err := client.GetTargetPool(context.Background(), "us-central1", "missing/x"); err == nil {
+		t.Fatalf("failed - expected a status error")
+	}
+}
+
+func TestClientRemovesInstance(t *testing.T) {
+	var body map[string][]map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" || request.URL.Path != "/projects/p/zones/us-central1-a/instanceGroups/ig/removeInstances" {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := ioutil.ReadAll(request.Body)
+		json.Unmarshal(data, &body)
+		response.Write([]byte(`{"kind": "compute#operation"}`))
+	}))
+	defer server.Close()
+
+	client := &Client{Endpoint: server.URL, Project: "p"}
+	if err := client.RemoveInstanceGroupInstance(context.Background(), "us-central1-a", "ig", node1); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if len(body["instances"]) != 1 || body["instances"][0]["instance"] != node1 {
+		t.Fatalf("failed - expected the instance reference, got %v", body)
+	}
+}
+
+func TestMetadataTokenSourceCachesToken(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.Header.Get("Metadata-Flavor") != "Google" {
+			response.WriteHeader(http.StatusForbidden)
+			return
+		}
+		response.Write([]byte(`{"access_token": "abc", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	source := &metadataTokenSource{endpoint: server.URL, http: http.DefaultClient}
+	for i := 0; i < 2; i++ {
+		if token, err := source.Token(); err != nil || token != "abc" {
+			t.Fatalf("failed - expected the access token, got %v %v", token, err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("failed - expected the token to be cached, got %v requests", requests)
+	}
+}
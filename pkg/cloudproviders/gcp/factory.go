@@ -0,0 +1,39 @@
+package gcp
+
+import (
+	"errors"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func init() {
+	deregister.Register("gcp", New)
+}
+
+// Settings are the GCP provider's own settings
+type Settings struct {
+	Project          string `yaml:"project"`
+	Region           string `yaml:"region"`
+	Endpoint         string `yaml:"endpoint"`
+	MetadataEndpoint string `yaml:"metadataEndpoint"`
+}
+
+// New builds the GCP provider for the configured project and region
+func New(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	if len(cfg.TagSelectors) > 0 {
+		return nil, errors.New("tagSelectors (LB_TAG_SELECTORS) are not supported by the gcp provider")
+	}
+	var settings Settings
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings.Project == "" || settings.Region == "" {
+		return nil, errors.New("providers.gcp.project and providers.gcp.region are required")
+	}
+
+	return &CloudProvider{
+		Base:    deregister.NewBase(cfg),
+		Compute: NewClient(settings.Project, settings.Endpoint, settings.MetadataEndpoint),
+		Region:  settings.Region,
+	}, nil
+}
@@ -0,0 +1,407 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clusterLabel is the label GKE puts on node instances naming their cluster
+const clusterLabel = "goog-k8s-cluster-name"
+
+// serviceNameKey is set in the description of target pools and backend services created
+// for Kubernetes services
+const serviceNameKey = "kubernetes.io/service-name"
+
+// ComputeAPI is the subset of the Compute Engine API the provider uses
+type ComputeAPI interface {
+	AggregatedInstances(ctx context.Context, filter string) ([]Instance, error)
+	ListTargetPools(ctx context.Context, region string) ([]TargetPool, error)
+	GetTargetPool(ctx context.Context, region string, name string) (*TargetPool, error)
+	RemoveTargetPoolInstance(ctx context.Context, region string, pool string, instance string) error
+	ListBackendServices(ctx context.Context, region string) ([]BackendService, error)
+	ListInstanceGroupInstances(ctx context.Context, zone string, group string) ([]string, error)
+	RemoveInstanceGroupInstance(ctx context.Context, zone string, group string, instance string) error
+	GetBackendServiceHealth(ctx context.Context, region string, service string, group string) ([]HealthStatus, error)
+}
+
+// CloudProvider drains nodes from the target pools and backend services of GKE
+// external passthrough network load balancers. It implements the CloudProvider interface
+type CloudProvider struct {
+	deregister.Base
+	Compute ComputeAPI
+	Region  string
+}
+
+// loadBalancer is a target pool holding the node, or a backend service and the
+// instance group holding the node
+type loadBalancer struct {
+	name    string
+	lbType  string
+	service *BackendService
+	zone    string
+	group   string
+	// groupURL is the instance group's self link, as backend services refer to it
+	groupURL string
+}
+
+// DrainNodeFromLoadBalancer removes the node from every target pool and backend service
+// instance group it is in, then waits for each to stop sending it traffic
+func (m *CloudProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling deregistration for node")
+	start := m.Now()
+	result, err := m.drainNode(ctx, nodeName)
+	result.Duration = m.Since(start)
+	return result, err
+}
+
+func (m *CloudProvider) drainNode(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result := &deregister.DrainResult{}
+	instance, cluster, err := m.findInstance(ctx, nodeName)
+	if err != nil {
+		return result, err
+	}
+	result.NodeID = instance.Name
+	result.ClusterName = cluster
+
+	lbs, err := m.loadBalancersForInstance(ctx, instance)
+	if err != nil {
+		return result, err
+	}
+
+	// remove the node everywhere before polling so every load balancer drains at once
+	removedGroups := map[string]bool{}
+	for _, lb := range lbs {
+		if err := m.removeInstance(ctx, instance, lb, removedGroups); err != nil {
+			return result, err
+		}
+		result.LoadBalancers = append(result.LoadBalancers, deregister.LoadBalancerResult{Name: lb.name, Type: lb.lbType, Deregistered: true})
+	}
+	removedAt := m.Now()
+
+	var wg sync.WaitGroup
+	for i, lb := range lbs {
+		wg.Add(1)
+		go func(lb loadBalancer, lbResult *deregister.LoadBalancerResult) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "pollGCPLoadBalancer", trace.WithAttributes(attribute.String("lb.name", lb.name)))
+			defer span.End()
+			for polls := 1; ; polls++ {
+				span.SetAttributes(attribute.Int("polls", polls))
+				drained, err := m.instanceDrained(ctx, instance, lb, m.Since(removedAt))
+				if err != nil {
+					log.Warn().Err(err).Str("lbName", lb.name).Str("instance", instance.Name).Msg("error checking drain progress")
+				}
+
+				if drained {
+					m.Recorder().LoadBalancerDrained(cluster, lb.lbType, m.Since(removedAt), false)
+					break
+				}
+
+				if m.Since(removedAt) > m.DrainTimeout(ctx) {
+					log.Warn().
+						Str("lbName", lb.name).
+						Str("instance", instance.Name).
+						Dur("timeout", m.DrainTimeout(ctx)).
+						Msg("node did not drain within timeout")
+					m.Recorder().LoadBalancerDrained(cluster, lb.lbType, m.Since(removedAt), true)
+					span.SetAttributes(attribute.Bool("timedOut", true))
+					lbResult.TimedOut = true
+					break
+				}
+
+				if m.Wait(ctx) != nil {
+					break
+				}
+			}
+		}(lb, &result.LoadBalancers[i])
+	}
+	wg.Wait()
+	return result, ctx.Err()
+}
+
+// removeInstance removes the instance from a target pool, or from the instance group behind a
+// backend service unless it was already removed for another backend service
+func (m *CloudProvider) removeInstance(ctx context.Context, instance *Instance, lb loadBalancer, removedGroups map[string]bool) error {
+	if lb.service == nil {
+		log.Info().Str("instance", instance.Name).Str("targetPool", lb.name).Msg("removing node from target pool")
+		finish := m.StartAPICall(ctx, "compute.targetPools.removeInstance")
+		err := m.Compute.RemoveTargetPoolInstance(ctx, m.Region, lb.name, instance.SelfLink)
+		finish(err)
+		return err
+	}
+
+	if removedGroups[lb.groupURL] {
+		return nil
+	}
+	log.Info().
+		Str("instance", instance.Name).
+		Str("instanceGroup", lb.group).
+		Str("backendService", lb.name).
+		Msg("removing node from instance group, starting connection draining")
+	finish := m.StartAPICall(ctx, "compute.instanceGroups.removeInstances")
+	err := m.Compute.RemoveInstanceGroupInstance(ctx, lb.zone, lb.group, instance.SelfLink)
+	finish(err)
+	removedGroups[lb.groupURL] = err == nil
+	return err
+}
+
+// instanceDrained reports whether a load balancer has stopped sending the instance traffic. A backend
+// service keeps sending established connections traffic for its connection draining timeout
+func (m *CloudProvider) instanceDrained(ctx context.Context, instance *Instance, lb loadBalancer, sinceRemoved time.Duration) (bool, error) {
+	if lb.service == nil {
+		finish := m.StartAPICall(ctx, "compute.targetPools.get")
+		pool, err := m.Compute.GetTargetPool(ctx, m.Region, lb.name)
+		finish(err)
+		if err != nil {
+			return false, err
+		}
+		return !contains(pool.Instances, instance.SelfLink), nil
+	}
+
+	finish := m.StartAPICall(ctx, "compute.backendServices.getHealth")
+	health, err := m.Compute.GetBackendServiceHealth(ctx, m.Region, lb.name, lb.groupURL)
+	finish(err)
+	if err != nil {
+		return false, err
+	}
+	for _, status := range health {
+		if status.Instance == instance.SelfLink {
+			return false, nil
+		}
+	}
+	return sinceRemoved >= drainingTimeout(lb.service), nil
+}
+
+// findInstance finds the node's instance by name or private IP, and its cluster from the GKE label
+func (m *CloudProvider) findInstance(ctx context.Context, nodeName string) (*Instance, string, error) {
+	filter := fmt.Sprintf("name = %q", nodeName)
+	byIP := net.ParseIP(nodeName) != nil
+	if byIP {
+		filter = fmt.Sprintf("networkInterfaces.networkIP = %q", nodeName)
+	}
+
+	finish := m.StartAPICall(ctx, "compute.instances.aggregatedList")
+	instances, err := m.Compute.AggregatedInstances(ctx, filter)
+	finish(err)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := range instances {
+		instance := &instances[i]
+		if !byIP && instance.Name != nodeName {
+			continue
+		}
+		if byIP && !hasNetworkIP(instance, nodeName) {
+			continue
+		}
+
+		cluster, ok := instance.Labels[clusterLabel]
+		if !ok {
+			return nil, "", fmt.Errorf("instance %s has no %s label", instance.Name, clusterLabel)
+		}
+		return instance, cluster, nil
+	}
+	return nil, "", fmt.Errorf("no instance found for node %s", nodeName)
+}
+
+// loadBalancersForInstance finds the Kubernetes target pools and backend services in the region
+// which send the instance traffic
+func (m *CloudProvider) loadBalancersForInstance(ctx context.Context, instance *Instance) ([]loadBalancer, error) {
+	ctx, span := tracing.Start(ctx, "loadBalancersForInstance")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "compute.targetPools.list")
+	pools, err := m.Compute.ListTargetPools(ctx, m.Region)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	lbs := []loadBalancer{}
+	for _, pool := range pools {
+		if managedByKubernetes(pool.Description) && contains(pool.Instances, instance.SelfLink) {
+			lbs = append(lbs, loadBalancer{name: pool.Name, lbType: metrics.LoadBalancerTargetPool})
+		}
+	}
+
+	finish = m.StartAPICall(ctx, "compute.backendServices.list")
+	services, err := m.Compute.ListBackendServices(ctx, m.Region)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]bool{}
+	zone := lastSegment(instance.Zone)
+	for i := range services {
+		service := &services[i]
+		if !managedByKubernetes(service.Description) {
+			continue
+		}
+		for _, backend := range service.Backends {
+			groupZone, group := parseGroup(backend.Group)
+			if groupZone != zone {
+				continue
+			}
+
+			member, ok := members[backend.Group]
+			if !ok {
+				finish := m.StartAPICall(ctx, "compute.instanceGroups.listInstances")
+				instances, err := m.Compute.ListInstanceGroupInstances(ctx, groupZone, group)
+				finish(err)
+				if err != nil {
+					return nil, err
+				}
+				member = contains(instances, instance.SelfLink)
+				members[backend.Group] = member
+			}
+
+			if member {
+				lbs = append(lbs, loadBalancer{
+					name:     service.Name,
+					lbType:   metrics.LoadBalancerBackendService,
+					service:  service,
+					zone:     groupZone,
+					group:    group,
+					groupURL: backend.Group,
+				})
+			}
+		}
+	}
+
+	log.Debug().
+		Str("instance", instance.Name).
+		Int("loadBalancers", len(lbs)).
+		Msg("found load balancers sending traffic to instance")
+	return lbs, nil
+}
+
+// DescribeNode gets the network, cluster and labels of the node's instance
+func (m *CloudProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	instance, cluster, err := m.findInstance(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &deregister.NodeInfo{ID: instance.Name, ClusterName: cluster, Tags: instance.Labels}
+	if len(instance.NetworkInterfaces) > 0 {
+		info.VPCID = lastSegment(instance.NetworkInterfaces[0].Network)
+	}
+	return info, nil
+}
+
+// PlanDrain reports what draining the node would do without changing any load balancer
+func (m *CloudProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	instance, cluster, err := m.findInstance(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	lbs, err := m.loadBalancersForInstance(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &deregister.Plan{
+		NodeID:        instance.Name,
+		ClusterName:   cluster,
+		Timeout:       m.DrainTimeout(ctx),
+		LoadBalancers: []deregister.PlannedLoadBalancer{},
+	}
+	if len(instance.NetworkInterfaces) > 0 {
+		plan.VPCID = lastSegment(instance.NetworkInterfaces[0].Network)
+	}
+
+	for _, lb := range lbs {
+		planned := deregister.PlannedLoadBalancer{
+			Name:   lb.name,
+			Type:   lb.lbType,
+			State:  "Registered",
+			Action: deregister.PlanDeregister,
+		}
+		if lb.service != nil {
+			planned.DeregistrationDelay = drainingTimeout(lb.service)
+			finish := m.StartAPICall(ctx, "compute.backendServices.getHealth")
+			health, err := m.Compute.GetBackendServiceHealth(ctx, m.Region, lb.name, lb.groupURL)
+			finish(err)
+			if err != nil {
+				return nil, err
+			}
+			for _, status := range health {
+				if status.Instance == instance.SelfLink {
+					planned.State = status.HealthState
+				}
+			}
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, planned)
+	}
+
+	plan.Simulate()
+	return plan, nil
+}
+
+// managedByKubernetes reports whether a description is the JSON Kubernetes writes on the
+// load balancer resources it creates for a service
+func managedByKubernetes(description string) bool {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(description), &fields); err != nil {
+		return false
+	}
+	_, ok := fields[serviceNameKey]
+	return ok
+}
+
+func drainingTimeout(service *BackendService) time.Duration {
+	if service.ConnectionDraining == nil {
+		return 0
+	}
+	return time.Duration(service.ConnectionDraining.DrainingTimeoutSec) * time.Second
+}
+
+func hasNetworkIP(instance *Instance, ip string) bool {
+	for _, nic := range instance.NetworkInterfaces {
+		if nic.NetworkIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGroup returns the zone and name of an instance group from its URL
+func parseGroup(groupURL string) (zone string, name string) {
+	parts := strings.Split(groupURL, "/")
+	for i := 0; i+3 < len(parts); i++ {
+		if parts[i] == "zones" && parts[i+2] == "instanceGroups" {
+			return parts[i+1], parts[i+3]
+		}
+	}
+	return "", ""
+}
+
+func lastSegment(resourceURL string) string {
+	return resourceURL[strings.LastIndex(resourceURL, "/")+1:]
+}
+
+func contains(lst []string, s string) bool {
+	for _, a := range lst {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
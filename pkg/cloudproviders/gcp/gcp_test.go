@@ -0,0 +1,206 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+const (
+	computeURL = "https://compute.googleapis.com/compute/v1/projects/p"
+	node1      = computeURL + "/zones/us-central1-a/instances/gke-node-1"
+	node2      = computeURL + "/zones/us-central1-a/instances/gke-node-2"
+	groupURL   = computeURL + "/zones/us-central1-a/instanceGroups/k8s-ig--abc"
+)
+
+// fakeCompute keeps target pool and instance group membership so removals are observed by polls
+type fakeCompute struct {
+	instances []Instance
+	pools     map[string]*TargetPool
+	services  []BackendService
+	groups    map[string][]string
+	removals  []string
+	filters   []string
+	err       error
+}
+
+func newFakeCompute() *fakeCompute {
+	instance := Instance{
+		Name:     "gke-node-1",
+		Zone:     computeURL + "/zones/us-central1-a",
+		SelfLink: node1,
+		Labels:   map[string]string{clusterLabel: "prod"},
+	}
+	instance.NetworkInterfaces = append(instance.NetworkInterfaces, struct {
+		Network   string `json:"network"`
+		NetworkIP string `json:"networkIP"`
+	}{Network: computeURL + "/global/networks/default", NetworkIP: "10.0.0.1"})
+
+	service := BackendService{Name: "k8s2-web", Description: `{"kubernetes.io/service-name":"default/web"}`}
+	service.Backends = append(service.Backends, struct {
+		Group string `json:"group"`
+	}{Group: groupURL})
+
+	return &fakeCompute{
+		instances: []Instance{instance},
+		pools: map[string]*TargetPool{
+			"a123":   {Name: "a123", Description: `{"kubernetes.io/service-name":"default/api"}`, Instances: []string{node1, node2}},
+			"manual": {Name: "manual", Description: "hand made", Instances: []string{node1}},
+		},
+		services: []BackendService{service},
+		groups:   map[string][]string{"k8s-ig--abc": {node1, node2}},
+	}
+}
+
+func (f *fakeCompute) AggregatedInstances(ctx context.Context, filter string) ([]Instance, error) {
+	f.filters = append(f.filters, filter)
+	return f.instances, f.err
+}
+
+func (f *fakeCompute) ListTargetPools(ctx context.Context, region string) ([]TargetPool, error) {
+	pools := []TargetPool{}
+	for _, pool := range f.pools {
+		pools = append(pools, *pool)
+	}
+	return pools, f.err
+}
+
+func (f *fakeCompute) GetTargetPool(ctx context.Context, region string, name string) (*TargetPool, error) {
+	return f.pools[name], f.err
+}
+
+func (f *fakeCompute) RemoveTargetPoolInstance(ctx context.Context, region string, pool string, instance string) error {
+	f.removals = append(f.removals, pool)
+	f.pools[pool].Instances = remove(f.pools[pool].Instances, instance)
+	return f.err
+}
+
+func (f *fakeCompute) ListBackendServices(ctx context.Context, region string) ([]BackendService, error) {
+	return f.services, f.err
+}
+
+func (f *fakeCompute) ListInstanceGroupInstances(ctx context.Context, zone string, group string) ([]string, error) {
+	return f.groups[group], f.err
+}
+
+func (f *fakeCompute) RemoveInstanceGroupInstance(ctx context.Context, zone string, group string, instance string) error {
+	f.removals = append(f.removals, group)
+	f.groups[group] = remove(f.groups[group], instance)
+	return f.err
+}
+
+func (f *fakeCompute) GetBackendServiceHealth(ctx context.Context, region string, service string, group string) ([]HealthStatus, error) {
+	health := []HealthStatus{}
+	for _, instance := range f.groups[lastSegment(group)] {
+		health = append(health, HealthStatus{Instance: instance, HealthState: "HEALTHY"})
+	}
+	return health, f.err
+}
+
+func remove(lst []string, s string) []string {
+	kept := []string{}
+	for _, a := range lst {
+		if a != s {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+func TestDrainRemovesNodeFromClusterLoadBalancers(t *testing.T) {
+	compute := newFakeCompute()
+	provider := &CloudProvider{Base: deregister.Base{Timeout: time.Second, PollInterval: time.Millisecond}, Compute: compute, Region: "us-central1"}
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if result.NodeID != "gke-node-1" || result.ClusterName != "prod" || len(result.LoadBalancers) != 2 || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v", result)
+	}
+	if strings.Join(compute.removals, ",") != "a123,k8s-ig--abc" {
+		t.Fatalf("failed - expected removal from the kubernetes pool and group only, got %v", compute.removals)
+	}
+	if len(compute.pools["manual"].Instances) != 1 || len(compute.groups["k8s-ig--abc"]) != 1 {
+		t.Fatalf("failed - expected other members to be untouched")
+	}
+}
+
+func TestDrainWaitsForConnectionDraining(t *testing.T) {
+	compute := newFakeCompute()
+	compute.services[0].ConnectionDraining = &struct {
+		DrainingTimeoutSec int64 `json:"drainingTimeoutSec"`
+	}{DrainingTimeoutSec: 30}
+	provider := &CloudProvider{Base: deregister.Base{Timeout: 20 * time.Millisecond, PollInterval: time.Millisecond}, Compute: compute, Region: "us-central1"}
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "gke-node-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if result.LoadBalancers[0].TimedOut || !result.LoadBalancers[1].TimedOut {
+		t.Fatalf("failed - expected only the backend service to time out while draining, got %+v", result.LoadBalancers)
+	}
+}
+
+func TestDryRunPlansWithoutRemoving(t *testing.T) {
+	compute := newFakeCompute()
+	compute.services[0].ConnectionDraining = &struct {
+		DrainingTimeoutSec int64 `json:"drainingTimeoutSec"`
+	}{DrainingTimeoutSec: 30}
+	provider := &CloudProvider{Base: deregister.Base{Timeout: time.Minute}, Compute: compute, Region: "us-central1"}
+	dryRun, _ := deregister.Wrap(provider, deregister.WithDryRun(true))
+
+	result, err := dryRun.DrainNodeFromLoadBalancer(context.Background(), "gke-node-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if len(compute.removals) != 0 {
+		t.Fatalf("failed - expected no removals in dry-run, got %v", compute.removals)
+	}
+
+	service := result.Plan.LoadBalancers[1]
+	if service.State != "HEALTHY" || service.DeregistrationDelay != 30*time.Second || result.Plan.EstimatedDuration != 30*time.Second {
+		t.Fatalf("failed - unexpected plan %+v", result.Plan)
+	}
+}
+
+func TestFindInstanceErrors(t *testing.T) {
+	compute := newFakeCompute()
+	compute.instances[0].Labels = nil
+	provider := &CloudProvider{Compute: compute, Region: "us-central1"}
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "gke-node-1"); err == nil || !strings.Contains(err.Error(), clusterLabel) {
+		t.Fatalf("failed - expected missing cluster label error, got %v", err)
+	}
+	if _, err := provider.DescribeNode(context.Background(), "10.9.9.9"); err == nil {
+		t.Fatalf("failed - expected unknown IP to fail")
+	}
+
+	compute.err = errors.New("quota exceeded")
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "gke-node-1"); err != compute.err {
+		t.Fatalf("failed - expected the api error, got %v", err)
+	}
+}
+
+func TestDescribeNode(t *testing.T) {
+	compute := newFakeCompute()
+	provider := &CloudProvider{Compute: compute, Region: "us-central1"}
+
+	info, err := provider.DescribeNode(context.Background(), "gke-node-1")
+	if err != nil || info.ClusterName != "prod" || info.VPCID != "default" {
+		t.Fatalf("failed - unexpected node info %+v %v", info, err)
+	}
+	if info, err := provider.DescribeNode(context.Background(), "10.0.0.1"); err != nil || info.ID != "gke-node-1" {
+		t.Fatalf("failed - expected the node to be found by IP, got %+v %v", info, err)
+	}
+	expected := []string{`name = "gke-node-1"`, `networkInterfaces.networkIP = "10.0.0.1"`}
+	if len(compute.filters) != 2 || compute.filters[0] != expected[0] || compute.filters[1] != expected[1] {
+		t.Fatalf("failed - expected instances to be filtered by name and IP, got %v", compute.filters)
+	}
+	var _ deregister.NodeDescriber = provider
+	var _ deregister.Planner = provider
+}
@@ -0,0 +1,43 @@
+package metallb
+
+import (
+	"errors"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+func init() {
+	deregister.Register("metallb", New)
+}
+
+// Settings are the MetalLB provider's own settings
+type Settings struct {
+	// Namespace is where MetalLB is installed and its speakers publish their status
+	Namespace   string `yaml:"namespace"`
+	ClusterName string `yaml:"clusterName"`
+}
+
+// New builds the MetalLB provider, reaching the cluster in-cluster or through the
+// kubeconfig named by KUBECONFIG
+func New(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	if len(cfg.TagSelectors) > 0 {
+		return nil, errors.New("tagSelectors (LB_TAG_SELECTORS) are not supported by the metallb provider")
+	}
+	settings := Settings{Namespace: "metallb-system"}
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	client, err := kube.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CloudProvider{
+		Base:        deregister.NewBase(cfg),
+		Kubernetes:  client,
+		Namespace:   settings.Namespace,
+		ClusterName: settings.ClusterName,
+	}, nil
+}
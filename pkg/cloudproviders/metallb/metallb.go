@@ -0,0 +1,255 @@
+package metallb
+
+import (
+	"context"
+	"net/url"
+	"sort"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// nodeLabel is set by the speakers on their status resources, naming the announcing node
+const nodeLabel = "metallb.io/node"
+
+// statusResources are the MetalLB status resources speakers publish for each service they
+// announce, by the load balancer type they are reported as
+var statusResources = []struct {
+	resource string
+	lbType   string
+}{
+	{"servicel2statuses", metrics.LoadBalancerMetalLBL2},
+	{"servicebgpstatuses", metrics.LoadBalancerMetalLBBGP},
+}
+
+// KubernetesAPI is the subset of the Kubernetes client the provider uses
+type KubernetesAPI interface {
+	GetNode(nodeName string) (*kube.Node, error)
+	ExcludeFromLoadBalancers(n *kube.Node) (bool, error)
+	IncludeInLoadBalancers(n *kube.Node) (bool, error)
+	Do(method string, path string, contentType string, in interface{}, out interface{}) error
+}
+
+// CloudProvider drains bare-metal nodes from MetalLB by excluding them from external load
+// balancers, then waiting for the speakers to stop announcing services from them.
+// It implements the CloudProvider interface
+type CloudProvider struct {
+	deregister.Base
+	Kubernetes  KubernetesAPI
+	Namespace   string
+	ClusterName string
+}
+
+// announcement is a service a speaker on the node announces
+type announcement struct {
+	service string
+	lbType  string
+}
+
+type serviceStatusList struct {
+	Items []struct {
+		Status struct {
+			Node             string `json:"node"`
+			ServiceName      string `json:"serviceName"`
+			ServiceNamespace string `json:"serviceNamespace"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// DrainNodeFromLoadBalancer labels the node to exclude it from external load balancers,
+// then waits until no speaker announces a service from it
+func (m *CloudProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling deregistration for node")
+	start := m.Now()
+	result, err := m.drainNode(ctx, nodeName)
+	result.Duration = m.Since(start)
+	return result, err
+}
+
+func (m *CloudProvider) drainNode(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result := &deregister.DrainResult{ClusterName: m.ClusterName}
+	node, err := m.getNode(ctx, nodeName)
+	if err != nil {
+		return result, err
+	}
+	result.NodeID = node.Name
+
+	announcements, err := m.announcements(ctx, node.Name)
+	if err != nil {
+		return result, err
+	}
+
+	log.Info().Str("node", node.Name).Msg("excluding node from external load balancers")
+	finish := m.StartAPICall(ctx, "kubernetes.nodes.patch")
+	_, err = m.Kubernetes.ExcludeFromLoadBalancers(node)
+	finish(err)
+	if err != nil {
+		return result, err
+	}
+	excludedAt := m.Now()
+
+	pending := map[announcement]*deregister.LoadBalancerResult{}
+	result.LoadBalancers = make([]deregister.LoadBalancerResult, len(announcements))
+	for i, a := range announcements {
+		result.LoadBalancers[i] = deregister.LoadBalancerResult{Name: a.service, Type: a.lbType, Deregistered: true}
+		pending[a] = &result.LoadBalancers[i]
+	}
+
+	for polls := 1; len(pending) > 0; polls++ {
+		current, err := m.announcements(ctx, node.Name)
+		if err != nil {
+			log.Warn().Err(err).Str("node", node.Name).Msg("error checking drain progress")
+		} else {
+			announcing := map[announcement]bool{}
+			for _, a := range current {
+				announcing[a] = true
+			}
+			for a := range pending {
+				if !announcing[a] {
+					log.Debug().Str("service", a.service).Str("type", a.lbType).Int("polls", polls).Msg("speaker stopped announcing service from node")
+					m.Recorder().LoadBalancerDrained(m.ClusterName, a.lbType, m.Since(excludedAt), false)
+					delete(pending, a)
+				}
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		if m.Since(excludedAt) > m.DrainTimeout(ctx) {
+			for a, lbResult := range pending {
+				log.Warn().
+					Str("service", a.service).
+					Str("node", node.Name).
+					Dur("timeout", m.DrainTimeout(ctx)).
+					Msg("node did not drain within timeout")
+				m.Recorder().LoadBalancerDrained(m.ClusterName, a.lbType, m.Since(excludedAt), true)
+				lbResult.TimedOut = true
+			}
+			break
+		}
+
+		if err := m.Wait(ctx); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RestoreNodeToLoadBalancer removes the exclude label if a drain added it. A label added by
+// someone else is left in place
+func (m *CloudProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	node, err := m.getNode(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	finish := m.StartAPICall(ctx, "kubernetes.nodes.patch")
+	restored, err := m.Kubernetes.IncludeInLoadBalancers(node)
+	finish(err)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		log.Info().Str("node", node.Name).Msg("node was not excluded by a drain, leaving its labels alone")
+	}
+	return nil
+}
+
+// DescribeNode gets the node's name and labels
+func (m *CloudProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	node, err := m.getNode(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	return &deregister.NodeInfo{ID: node.Name, ClusterName: m.ClusterName, Tags: node.Labels}, nil
+}
+
+// PlanDrain reports what draining the node would do without labelling it
+func (m *CloudProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	node, err := m.getNode(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	announcements, err := m.announcements(ctx, node.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, excluded := node.Labels[kube.ExcludeFromExternalLoadBalancersLabel]
+	plan := &deregister.Plan{
+		NodeID:        node.Name,
+		ClusterName:   m.ClusterName,
+		Timeout:       m.DrainTimeout(ctx),
+		LoadBalancers: []deregister.PlannedLoadBalancer{},
+	}
+	for _, a := range announcements {
+		planned := deregister.PlannedLoadBalancer{
+			Name:   a.service,
+			Type:   a.lbType,
+			State:  "Announcing",
+			Action: deregister.PlanDeregister,
+		}
+		if excluded {
+			planned.Action = deregister.PlanWait
+			planned.Reason = "node is already excluded from external load balancers"
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, planned)
+	}
+
+	plan.Simulate()
+	return plan, nil
+}
+
+func (m *CloudProvider) getNode(ctx context.Context, nodeName string) (*kube.Node, error) {
+	finish := m.StartAPICall(ctx, "kubernetes.nodes.get")
+	node, err := m.Kubernetes.GetNode(nodeName)
+	finish(err)
+	return node, err
+}
+
+// announcements lists the services speakers announce from the node, sorted by service. A status
+// resource which is not served, as with older MetalLB releases or without BGP, is skipped
+func (m *CloudProvider) announcements(ctx context.Context, nodeName string) ([]announcement, error) {
+	announcements := []announcement{}
+	for _, status := range statusResources {
+		path := "/apis/metallb.io/v1beta1/namespaces/" + m.namespace() + "/" + status.resource +
+			"?labelSelector=" + url.QueryEscape(nodeLabel+"="+nodeName)
+
+		var list serviceStatusList
+		finish := m.StartAPICall(ctx, "metallb."+status.resource+".list")
+		err := m.Kubernetes.Do("GET", path, "", nil, &list)
+		finish(err)
+		if kube.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			if item.Status.Node != nodeName {
+				continue
+			}
+			announcements = append(announcements, announcement{
+				service: item.Status.ServiceNamespace + "/" + item.Status.ServiceName,
+				lbType:  status.lbType,
+			})
+		}
+	}
+
+	sort.SliceStable(announcements, func(i, j int) bool { return announcements[i].service < announcements[j].service })
+	return announcements, nil
+}
+
+func (m *CloudProvider) namespace() string {
+	if m.Namespace == "" {
+		return "metallb-system"
+	}
+	return m.Namespace
+}
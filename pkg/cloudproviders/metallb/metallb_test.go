@@ -0,0 +1,186 @@
+package metallb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/kube"
+)
+
+type fakeStatus struct {
+	node      string
+	namespace string
+	name      string
+}
+
+// fakeKubernetes serves a node and the speakers' status resources. Once the node is
+// excluded, its statuses are withdrawn after withdrawAfter lists
+type fakeKubernetes struct {
+	mu            sync.Mutex
+	node          kube.Node
+	statuses      map[string][]fakeStatus
+	withdrawAfter int
+	lists         int
+	excluded      bool
+	included      bool
+	selectors     []string
+}
+
+func newFakeKubernetes() *fakeKubernetes {
+	return &fakeKubernetes{
+		node: kube.Node{Name: "metal-1", Labels: map[string]string{"kubernetes.io/hostname": "metal-1"}},
+		statuses: map[string][]fakeStatus{
+			"servicel2statuses": {
+				{node: "metal-1", namespace: "default", name: "web"},
+				{node: "metal-2", namespace: "default", name: "api"},
+			},
+			"servicebgpstatuses": {
+				{node: "metal-1", namespace: "ingress", name: "nginx"},
+			},
+		},
+		withdrawAfter: 2,
+	}
+}
+
+func (f *fakeKubernetes) GetNode(nodeName string) (*kube.Node, error) {
+	if nodeName != f.node.Name {
+		return nil, &kube.StatusError{StatusCode: http.StatusNotFound}
+	}
+	node := f.node
+	return &node, nil
+}
+
+func (f *fakeKubernetes) ExcludeFromLoadBalancers(n *kube.Node) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.excluded = true
+	return true, nil
+}
+
+func (f *fakeKubernetes) IncludeInLoadBalancers(n *kube.Node) (bool, error) {
+	f.included = true
+	return true, nil
+}
+
+func (f *fakeKubernetes) Do(method string, path string, contentType string, in interface{}, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(path, "?", 2)
+	resource := parts[0][strings.LastIndex(parts[0], "/")+1:]
+	statuses, ok := f.statuses[resource]
+	if !ok || !strings.HasPrefix(path, "/apis/metallb.io/v1beta1/namespaces/metallb-system/") {
+		return &kube.StatusError{StatusCode: http.StatusNotFound}
+	}
+	f.selectors = append(f.selectors, parts[1])
+
+	if f.excluded {
+		f.lists++
+		if f.withdrawAfter >= 0 && f.lists > f.withdrawAfter*len(f.statuses) {
+			remaining := []fakeStatus{}
+			for _, status := range statuses {
+				if status.node != f.node.Name {
+					remaining = append(remaining, status)
+				}
+			}
+			f.statuses[resource] = remaining
+			statuses = remaining
+		}
+	}
+
+	items := []map[string]interface{}{}
+	for _, status := range statuses {
+		items = append(items, map[string]interface{}{"status": map[string]string{
+			"node":             status.node,
+			"serviceName":      status.name,
+			"serviceNamespace": status.namespace,
+		}})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"items": items})
+	return json.Unmarshal(data, out)
+}
+
+func newProvider(f *fakeKubernetes) *CloudProvider {
+	return &CloudProvider{
+		Base:        deregister.Base{Timeout: time.Second, PollInterval: time.Millisecond},
+		Kubernetes:  f,
+		ClusterName: "metal",
+	}
+}
+
+func TestDrainNodeWaitsForSpeakersToWithdraw(t *testing.T) {
+	f := newFakeKubernetes()
+	result, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "metal-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	if !f.excluded || result.NodeID != "metal-1" || result.ClusterName != "metal" || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v", result)
+	}
+	if len(result.LoadBalancers) != 2 ||
+		result.LoadBalancers[0] != (deregister.LoadBalancerResult{Name: "default/web", Type: "metallb-l2", Deregistered: true}) ||
+		result.LoadBalancers[1] != (deregister.LoadBalancerResult{Name: "ingress/nginx", Type: "metallb-bgp", Deregistered: true}) {
+		t.Fatalf("failed - unexpected load balancers %+v", result.LoadBalancers)
+	}
+	if f.selectors[0] != "labelSelector=metallb.io%2Fnode%3Dmetal-1" {
+		t.Fatalf("failed - unexpected label selector %s", f.selectors[0])
+	}
+	if len(f.statuses["servicel2statuses"]) != 1 {
+		t.Fatal("failed - expected other nodes to keep announcing")
+	}
+}
+
+func TestDrainNodeTimesOutWhileSpeakersAnnounce(t *testing.T) {
+	f := newFakeKubernetes()
+	f.withdrawAfter = -1
+	provider := newProvider(f)
+	provider.Timeout = 10 * time.Millisecond
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "metal-1")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+}
+
+func TestDrainNodeWithoutBGPStatuses(t *testing.T) {
+	f := newFakeKubernetes()
+	delete(f.statuses, "servicebgpstatuses")
+	result, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "metal-1")
+	if err != nil || len(result.LoadBalancers) != 1 || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+}
+
+func TestDrainUnknownNode(t *testing.T) {
+	f := newFakeKubernetes()
+	if _, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "metal-9"); err == nil || f.excluded {
+		t.Fatalf("failed - expected error without labelling, got %v", err)
+	}
+}
+
+func TestDryRunDrainDoesNotLabel(t *testing.T) {
+	f := newFakeKubernetes()
+	f.node.Labels[kube.ExcludeFromExternalLoadBalancersLabel] = ""
+	provider, _ := deregister.Wrap(newProvider(f), deregister.WithDryRun(false))
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{DryRun: true})
+	result, err := provider.DrainNodeFromLoadBalancer(ctx, "metal-1")
+	if err != nil || !result.DryRun || result.Plan == nil || f.excluded {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if len(result.Plan.LoadBalancers) != 2 || result.Plan.LoadBalancers[0].Action != deregister.PlanWait {
+		t.Fatalf("failed - expected already excluded node to wait, got %+v", result.Plan.LoadBalancers)
+	}
+}
+
+func TestRestoreNode(t *testing.T) {
+	f := newFakeKubernetes()
+	if err := newProvider(f).RestoreNodeToLoadBalancer(context.Background(), "metal-1"); err != nil || !f.included {
+		t.Fatalf("failed - expected node to be included, got %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package openstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadBalancer is an Octavia load balancer
+type LoadBalancer struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	ProvisioningStatus string `json:"provisioning_status"`
+}
+
+// Pool is an Octavia pool of members
+type Pool struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Member is a backend in an Octavia pool
+type Member struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Address            string `json:"address"`
+	ProtocolPort       int    `json:"protocol_port"`
+	Weight             int    `json:"weight"`
+	ProvisioningStatus string `json:"provisioning_status"`
+	OperatingStatus    string `json:"operating_status"`
+}
+
+// Credentials authenticate with Keystone using either an application credential or a password
+type Credentials struct {
+	ApplicationCredentialID     string
+	ApplicationCredentialSecret string
+	Username                    string
+	Password                    string
+	UserDomainName              string
+	ProjectID                   string
+}
+
+// StatusError is returned when an OpenStack API responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("openstack api returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether the error is a 404
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether the error is a 409, returned while a load balancer is immutable
+// because an earlier change is still being applied
+func IsConflict(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict
+}
+
+// Client is a minimal JSON client for Octavia and Nova, authenticating with Keystone and
+// finding their endpoints in the service catalog
+type Client struct {
+	AuthURL     string
+	Region      string
+	Credentials Credentials
+	HTTP        *http.Client
+
+	// Endpoints override the catalog by service type, e.g. load-balancer or compute
+	Endpoints map[string]string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	catalog map[string]string
+}
+
+// NewClient builds a client which authenticates with the Keystone v3 API at authURL
+func NewClient(authURL string, region string, credentials Credentials, endpoints map[string]string) *Client {
+	return &Client{
+		AuthURL:     strings.TrimSuffix(authURL, "/"),
+		Region:      region,
+		Credentials: credentials,
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		Endpoints:   endpoints,
+	}
+}
+
+// ListLoadBalancers lists the project's load balancers
+func (c *Client) ListLoadBalancers(ctx context.Context) ([]LoadBalancer, error) {
+	var page struct {
+		LoadBalancers []LoadBalancer `json:"loadbalancers"`
+	}
+	err := c.do(ctx, "load-balancer", "GET", "/v2/lbaas/loadbalancers", nil, &page)
+	return page.LoadBalancers, err
+}
+
+// ListPools lists the pools of a load balancer
+func (c *Client) ListPools(ctx context.Context, loadBalancerID string) ([]Pool, error) {
+	var page struct {
+		Pools []Pool `json:"pools"`
+	}
+	err := c.do(ctx, "load-balancer", "GET", "/v2/lbaas/pools?loadbalancer_id="+url.QueryEscape(loadBalancerID), nil, &page)
+	return page.Pools, err
+}
+
+// ListMembers lists the members of a pool
+func (c *Client) ListMembers(ctx context.Context, poolID string) ([]Member, error) {
+	var page struct {
+		Members []Member `json:"members"`
+	}
+	err := c.do(ctx, "load-balancer", "GET", "/v2/lbaas/pools/"+poolID+"/members", nil, &page)
+	return page.Members, err
+}
+
+// GetMember gets a pool member
+func (c *Client) GetMember(ctx context.Context, poolID string, memberID string) (*Member, error) {
+	var body struct {
+		Member Member `json:"member"`
+	}
+	err := c.do(ctx, "load-balancer", "GET", "/v2/lbaas/pools/"+poolID+"/members/"+memberID, nil, &body)
+	return &body.Member, err
+}
+
+// UpdateMemberWeight sets a member's weight. A weight of 0 stops new connections to it
+func (c *Client) UpdateMemberWeight(ctx context.Context, poolID string, memberID string, weight int) error {
+	body := map[string]interface{}{"member": map[string]int{"weight": weight}}
+	return c.do(ctx, "load-balancer", "PUT", "/v2/lbaas/pools/"+poolID+"/members/"+memberID, body, nil)
+}
+
+// DeleteMember removes a member from its pool
+func (c *Client) DeleteMember(ctx context.Context, poolID string, memberID string) error {
+	return c.do(ctx, "load-balancer", "DELETE", "/v2/lbaas/pools/"+poolID+"/members/"+memberID, nil, nil)
+}
+
+// ServerAddresses returns the fixed IP addresses of the server with the given name
+func (c *Client) ServerAddresses(ctx context.Context, name string) ([]string, error) {
+	var page struct {
+		Servers []struct {
+			Name      string `json:"name"`
+			Addresses map[string][]struct {
+				Addr string `json:"addr"`
+				Type string `json:"OS-EXT-IPS:type"`
+			} `json:"addresses"`
+		} `json:"servers"`
+	}
+	// the name filter is a regular expression, so it is anchored to match exactly
+	if err := c.do(ctx, "compute", "GET", "/servers/detail?name="+url.QueryEscape("^"+name+"$"), nil, &page); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, server := range page.Servers {
+		for _, network := range server.Addresses {
+			for _, address := range network {
+				if address.Type != "floating" {
+					addresses = append(addresses, address.Addr)
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// do sends a request to a service from the catalog, decoding a JSON response into out if not nil
+func (c *Client) do(ctx context.Context, service string, method string, path string, in interface{}, out interface{}) error {
+	token, endpoint, err := c.authenticate(ctx, service)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if in != nil {
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Auth-Token", token)
+
+	_, respBody, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func (c *Client) send(req *http.Request) (*http.Response, []byte, error) {
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	return resp, respBody, nil
+}
+
+// authenticate returns a Keystone token and the service's endpoint, requesting a new token
+// shortly before the cached one expires
+func (c *Client) authenticate(ctx context.Context, service string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" || time.Now().Add(time.Minute).After(c.expires) {
+		if err := c.requestToken(ctx); err != nil {
+			return "", "", err
+		}
+	}
+
+	endpoint := c.Endpoints[service]
+	if endpoint == "" {
+		endpoint = c.catalog[service]
+	}
+	if endpoint == "" {
+		return "", "", fmt.Errorf("no public %s endpoint in region %q of the service catalog", service, c.Region)
+	}
+	return c.token, endpoint, nil
+}
+
+func (c *Client) requestToken(ctx context.Context) error {
+	identity := map[string]interface{}{}
+	var scope interface{}
+	if c.Credentials.ApplicationCredentialID != "" {
+		identity["methods"] = []string{"application_credential"}
+		identity["application_credential"] = map[string]string{
+			"id":     c.Credentials.ApplicationCredentialID,
+			"secret": c.Credentials.ApplicationCredentialSecret,
+		}
+	} else {
+		identity["methods"] = []string{"password"}
+		identity["password"] = map[string]interface{}{
+			"user": map[string]interface{}{
+				"name":     c.Credentials.Username,
+				"password": c.Credentials.Password,
+				"domain":   map[string]string{"name": c.Credentials.UserDomainName},
+			},
+		}
+		scope = map[string]interface{}{"project": map[string]string{"id": c.Credentials.ProjectID}}
+	}
+
+	auth := map[string]interface{}{"identity": identity}
+	if scope != nil {
+		auth["scope"] = scope
+	}
+	body, err := json.Marshal(map[string]interface{}{"auth": auth})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.AuthURL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, respBody, err := c.send(req)
+	if err != nil {
+		return fmt.Errorf("keystone authentication failed: %v", err)
+	}
+
+	var token struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal(respBody, &token); err != nil {
+		return err
+	}
+
+	c.catalog = map[string]string{}
+	for _, service := range token.Token.Catalog {
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface == "public" && (c.Region == "" || endpoint.Region == c.Region) {
+				c.catalog[service.Type] = endpoint.URL
+			}
+		}
+	}
+	c.token = resp.Header.Get("X-Subject-Token")
+	c.expires = token.Token.ExpiresAt
+	return nil
+}
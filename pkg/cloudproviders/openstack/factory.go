@@ -0,0 +1,79 @@
+package openstack
+
+import (
+	"errors"
+	"os"
+
+	"github.com/briankopp/hasta-la-vista/pkg/config"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func init() {
+	deregister.Register("openstack", New)
+}
+
+// Settings are the OpenStack provider's own settings. Secrets left empty are read from the
+// OS_PASSWORD and OS_APPLICATION_CREDENTIAL_SECRET environment variables
+type Settings struct {
+	AuthURL                     string          `yaml:"authUrl"`
+	Region                      string          `yaml:"region"`
+	ApplicationCredentialID     string          `yaml:"applicationCredentialId"`
+	ApplicationCredentialSecret string          `yaml:"applicationCredentialSecret"`
+	Username                    string          `yaml:"username"`
+	Password                    string          `yaml:"password"`
+	UserDomainName              string          `yaml:"userDomainName"`
+	ProjectID                   string          `yaml:"projectId"`
+	ClusterName                 string          `yaml:"clusterName"`
+	DrainDelay                  config.Duration `yaml:"drainDelay"`
+	LoadBalancerEndpoint        string          `yaml:"loadBalancerEndpoint"`
+	ComputeEndpoint             string          `yaml:"computeEndpoint"`
+}
+
+// New builds the OpenStack provider for the configured cluster
+func New(cfg deregister.ProviderConfig) (deregister.CloudProvider, error) {
+	if len(cfg.TagSelectors) > 0 {
+		return nil, errors.New("tagSelectors (LB_TAG_SELECTORS) are not supported by the openstack provider")
+	}
+	settings := Settings{
+		ClusterName:    "kubernetes",
+		UserDomainName: "Default",
+	}
+	if err := cfg.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings.Password == "" {
+		settings.Password = os.Getenv("OS_PASSWORD")
+	}
+	if settings.ApplicationCredentialSecret == "" {
+		settings.ApplicationCredentialSecret = os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET")
+	}
+	if settings.AuthURL == "" {
+		return nil, errors.New("providers.openstack.authUrl is required")
+	}
+	if settings.ApplicationCredentialID == "" && (settings.Username == "" || settings.ProjectID == "") {
+		return nil, errors.New("providers.openstack requires applicationCredentialId, or username and projectId")
+	}
+
+	endpoints := map[string]string{}
+	if settings.LoadBalancerEndpoint != "" {
+		endpoints["load-balancer"] = settings.LoadBalancerEndpoint
+	}
+	if settings.ComputeEndpoint != "" {
+		endpoints["compute"] = settings.ComputeEndpoint
+	}
+	credentials := Credentials{
+		ApplicationCredentialID:     settings.ApplicationCredentialID,
+		ApplicationCredentialSecret: settings.ApplicationCredentialSecret,
+		Username:                    settings.Username,
+		Password:                    settings.Password,
+		UserDomainName:              settings.UserDomainName,
+		ProjectID:                   settings.ProjectID,
+	}
+
+	return &CloudProvider{
+		Base:        deregister.NewBase(cfg),
+		Octavia:     NewClient(settings.AuthURL, settings.Region, credentials, endpoints),
+		ClusterName: settings.ClusterName,
+		DrainDelay:  settings.DrainDelay.Duration(),
+	}, nil
+}
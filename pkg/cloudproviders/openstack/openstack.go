@@ -0,0 +1,330 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// loadBalancerPrefix starts the names cloud-provider-openstack gives the load balancers
+// it creates for services, followed by the cluster name, namespace and service name
+const loadBalancerPrefix = "kube_service_"
+
+const (
+	provisioningActive = "ACTIVE"
+	operatingOnline    = "ONLINE"
+)
+
+// OctaviaAPI is the subset of the Octavia and Nova APIs the provider uses
+type OctaviaAPI interface {
+	ListLoadBalancers(ctx context.Context) ([]LoadBalancer, error)
+	ListPools(ctx context.Context, loadBalancerID string) ([]Pool, error)
+	ListMembers(ctx context.Context, poolID string) ([]Member, error)
+	GetMember(ctx context.Context, poolID string, memberID string) (*Member, error)
+	UpdateMemberWeight(ctx context.Context, poolID string, memberID string, weight int) error
+	DeleteMember(ctx context.Context, poolID string, memberID string) error
+	ServerAddresses(ctx context.Context, name string) ([]string, error)
+}
+
+// CloudProvider drains nodes from the pools of the Octavia load balancers cloud-provider-openstack
+// creates for a cluster's services. It implements the CloudProvider interface
+type CloudProvider struct {
+	deregister.Base
+	Octavia     OctaviaAPI
+	ClusterName string
+
+	// DrainDelay is how long members keep a weight of 0 before they are removed, giving
+	// established connections time to finish
+	DrainDelay time.Duration
+}
+
+// poolMember is one of the node's memberships of a cluster load balancer pool
+type poolMember struct {
+	loadBalancer LoadBalancer
+	pool         Pool
+	member       Member
+}
+
+func (p poolMember) name() string {
+	pool := p.pool.Name
+	if pool == "" {
+		pool = p.pool.ID
+	}
+	return p.loadBalancer.Name + "/" + pool
+}
+
+// DrainNodeFromLoadBalancer sets the weight of every pool member for the node to 0, then
+// removes each once it has stopped taking new connections
+func (m *CloudProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("handling deregistration for node")
+	start := m.Now()
+	result, err := m.drainNode(ctx, nodeName)
+	result.Duration = m.Since(start)
+	return result, err
+}
+
+func (m *CloudProvider) drainNode(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result := &deregister.DrainResult{NodeID: nodeName, ClusterName: m.ClusterName}
+	addresses, err := m.nodeAddresses(ctx, nodeName)
+	if err != nil {
+		return result, err
+	}
+
+	members, err := m.clusterMembers(ctx, addresses)
+	if err != nil {
+		return result, err
+	}
+
+	for _, member := range members {
+		result.LoadBalancers = append(result.LoadBalancers, deregister.LoadBalancerResult{Name: member.name(), Type: metrics.LoadBalancerOctavia, Deregistered: true})
+	}
+	start := m.Now()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(members))
+	for i, member := range members {
+		wg.Add(1)
+		go func(i int, member poolMember, lbResult *deregister.LoadBalancerResult) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "pollOctaviaMember", trace.WithAttributes(
+				attribute.String("lb.name", member.loadBalancer.Name),
+				attribute.String("pool.id", member.pool.ID),
+				attribute.String("member.id", member.member.ID),
+			))
+			defer span.End()
+			timedOut, err := m.drainMember(ctx, member, start)
+			if err != nil {
+				log.Error().Err(err).Str("lbName", lbResult.Name).Str("memberId", member.member.ID).Msg("error draining pool member")
+				lbResult.Deregistered = false
+				errs[i] = err
+				return
+			}
+			if timedOut {
+				log.Warn().
+					Str("lbName", lbResult.Name).
+					Str("memberId", member.member.ID).
+					Dur("timeout", m.DrainTimeout(ctx)).
+					Msg("node did not drain within timeout")
+				span.SetAttributes(attribute.Bool("timedOut", true))
+				lbResult.TimedOut = true
+			}
+			m.Recorder().LoadBalancerDrained(m.ClusterName, metrics.LoadBalancerOctavia, m.Since(start), timedOut)
+		}(i, member, &result.LoadBalancers[i])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// drainMember steps a pool member through the graceful drain, reporting whether it timed out. Octavia
+// rejects changes with a conflict while an earlier change to the load balancer is being applied, so
+// conflicting calls are retried on the next poll
+func (m *CloudProvider) drainMember(ctx context.Context, pm poolMember, start time.Time) (bool, error) {
+	weighted := pm.member.Weight == 0
+	weightedAt := start
+	removed := false
+
+	for {
+		var err error
+		switch {
+		case !weighted:
+			log.Info().Str("lbName", pm.name()).Str("address", pm.member.Address).Msg("setting pool member weight to 0, starting graceful drain")
+			finish := m.StartAPICall(ctx, "octavia.members.update")
+			err = m.Octavia.UpdateMemberWeight(ctx, pm.pool.ID, pm.member.ID, 0)
+			finish(err)
+			if err == nil {
+				weighted = true
+				weightedAt = m.Now()
+			}
+
+		case !removed:
+			var drained bool
+			drained, err = m.memberDrained(ctx, pm, m.Since(weightedAt))
+			if err == nil && drained {
+				log.Info().Str("lbName", pm.name()).Str("address", pm.member.Address).Msg("removing drained pool member")
+				finish := m.StartAPICall(ctx, "octavia.members.delete")
+				err = m.Octavia.DeleteMember(ctx, pm.pool.ID, pm.member.ID)
+				finish(err)
+				removed = err == nil
+			}
+
+		default:
+			finish := m.StartAPICall(ctx, "octavia.members.get")
+			_, err = m.Octavia.GetMember(ctx, pm.pool.ID, pm.member.ID)
+			finish(err)
+		}
+
+		if IsNotFound(err) {
+			// the member is gone, either removed above or by cloud-provider-openstack reconciling
+			return false, nil
+		}
+		if err != nil && !IsConflict(err) {
+			return false, err
+		}
+
+		if m.Since(start) > m.DrainTimeout(ctx) {
+			return true, nil
+		}
+		if err := m.Wait(ctx); err != nil {
+			return false, err
+		}
+	}
+}
+
+// memberDrained reports whether a member with a weight of 0 can be removed. Its weight change must
+// have been applied and the member must have stopped reporting ONLINE, which it does once the
+// amphorae stop sending it new connections. The drain delay is honored for established connections
+func (m *CloudProvider) memberDrained(ctx context.Context, pm poolMember, sinceWeighted time.Duration) (bool, error) {
+	finish := m.StartAPICall(ctx, "octavia.members.get")
+	member, err := m.Octavia.GetMember(ctx, pm.pool.ID, pm.member.ID)
+	finish(err)
+	if err != nil {
+		return false, err
+	}
+
+	log.Debug().
+		Str("lbName", pm.name()).
+		Str("provisioningStatus", member.ProvisioningStatus).
+		Str("operatingStatus", member.OperatingStatus).
+		Msg("checked pool member status")
+	return member.ProvisioningStatus == provisioningActive &&
+		member.OperatingStatus != operatingOnline &&
+		sinceWeighted >= m.DrainDelay, nil
+}
+
+// nodeAddresses returns the node's addresses, looking its server up in Nova unless the node is an IP
+func (m *CloudProvider) nodeAddresses(ctx context.Context, nodeName string) ([]string, error) {
+	if net.ParseIP(nodeName) != nil {
+		return []string{nodeName}, nil
+	}
+
+	finish := m.StartAPICall(ctx, "nova.servers.list")
+	addresses, err := m.Octavia.ServerAddresses(ctx, nodeName)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no server found for node %s", nodeName)
+	}
+	return addresses, nil
+}
+
+// clusterMembers finds the members for the addresses in every pool of the cluster's load balancers
+func (m *CloudProvider) clusterMembers(ctx context.Context, addresses []string) ([]poolMember, error) {
+	ctx, span := tracing.Start(ctx, "clusterMembers")
+	defer span.End()
+
+	finish := m.StartAPICall(ctx, "octavia.loadbalancers.list")
+	lbs, err := m.Octavia.ListLoadBalancers(ctx)
+	finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []poolMember{}
+	prefix := loadBalancerPrefix + m.ClusterName + "_"
+	for _, lb := range lbs {
+		if !strings.HasPrefix(lb.Name, prefix) {
+			continue
+		}
+
+		finish := m.StartAPICall(ctx, "octavia.pools.list")
+		pools, err := m.Octavia.ListPools(ctx, lb.ID)
+		finish(err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pool := range pools {
+			finish := m.StartAPICall(ctx, "octavia.members.list")
+			poolMembers, err := m.Octavia.ListMembers(ctx, pool.ID)
+			finish(err)
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range poolMembers {
+				if contains(addresses, member.Address) {
+					members = append(members, poolMember{loadBalancer: lb, pool: pool, member: member})
+				}
+			}
+		}
+	}
+
+	log.Debug().
+		Strs("addresses", addresses).
+		Int("members", len(members)).
+		Msg("found pool members for node")
+	return members, nil
+}
+
+// DescribeNode gets the node's cluster. Nodes are identified by name or address
+func (m *CloudProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	if _, err := m.nodeAddresses(ctx, nodeName); err != nil {
+		return nil, err
+	}
+	return &deregister.NodeInfo{ID: nodeName, ClusterName: m.ClusterName}, nil
+}
+
+// PlanDrain reports what draining the node would do without changing any load balancer
+func (m *CloudProvider) PlanDrain(ctx context.Context, nodeName string) (*deregister.Plan, error) {
+	addresses, err := m.nodeAddresses(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := m.clusterMembers(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &deregister.Plan{
+		NodeID:        nodeName,
+		ClusterName:   m.ClusterName,
+		Timeout:       m.DrainTimeout(ctx),
+		LoadBalancers: []deregister.PlannedLoadBalancer{},
+	}
+	for _, pm := range members {
+		planned := deregister.PlannedLoadBalancer{
+			Name:                pm.name(),
+			Type:                metrics.LoadBalancerOctavia,
+			State:               pm.member.OperatingStatus,
+			Action:              deregister.PlanDeregister,
+			DeregistrationDelay: m.DrainDelay,
+		}
+		if pm.member.ProvisioningStatus == "PENDING_DELETE" {
+			planned.Action = deregister.PlanWait
+			planned.Reason = "member is already being removed"
+		}
+		plan.LoadBalancers = append(plan.LoadBalancers, planned)
+	}
+
+	plan.Simulate()
+	return plan, nil
+}
+
+func contains(lst []string, s string) bool {
+	for _, a := range lst {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
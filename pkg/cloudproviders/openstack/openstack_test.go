@@ -0,0 +1,348 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+type fakeMember struct {
+	Member
+	poolID string
+	// polls counts down the GETs until a pending change is applied
+	polls int
+}
+
+// fakeOctavia is a local Keystone, Nova and Octavia API. Changes to members stay pending
+// for a couple of GETs, as they do while the amphorae are reconfigured
+type fakeOctavia struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	loadBalancers []LoadBalancer
+	pools         map[string][]Pool
+	members       []*fakeMember
+	servers       map[string]string
+	// conflicts is how many member changes are rejected while the load balancer is busy
+	conflicts int
+	// stayOnline keeps members reporting ONLINE after their weight is set to 0
+	stayOnline bool
+	tokens     int
+	changes    []string
+}
+
+func newFakeOctavia(t *testing.T) *fakeOctavia {
+	f := &fakeOctavia{
+		loadBalancers: []LoadBalancer{
+			{ID: "lb-1", Name: "kube_service_kubernetes_default_web", ProvisioningStatus: "ACTIVE"},
+			{ID: "lb-2", Name: "kube_service_kubernetes_default_api", ProvisioningStatus: "ACTIVE"},
+			{ID: "lb-3", Name: "kube_service_other_default_web", ProvisioningStatus: "ACTIVE"},
+		},
+		pools: map[string][]Pool{
+			"lb-1": {{ID: "pool-1", Name: "pool_web_80"}, {ID: "pool-2", Name: "pool_web_443"}},
+			"lb-2": {{ID: "pool-3", Name: "pool_api_8080"}},
+			"lb-3": {{ID: "pool-4", Name: "pool_other_80"}},
+		},
+		servers: map[string]string{"node-1": "10.0.0.5"},
+	}
+	for _, pool := range []string{"pool-1", "pool-2", "pool-3", "pool-4"} {
+		for i, address := range []string{"10.0.0.5", "10.0.0.6"} {
+			f.members = append(f.members, &fakeMember{
+				poolID: pool,
+				Member: Member{
+					ID:                 pool + "-member-" + string(rune('a'+i)),
+					Address:            address,
+					ProtocolPort:       30080,
+					Weight:             1,
+					ProvisioningStatus: "ACTIVE",
+					OperatingStatus:    "ONLINE",
+				},
+			})
+		}
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeOctavia) client() *Client {
+	return &Client{
+		AuthURL:     f.server.URL + "/identity/v3",
+		Region:      "RegionOne",
+		Credentials: Credentials{ApplicationCredentialID: "id", ApplicationCredentialSecret: "secret"},
+	}
+}
+
+func (f *fakeOctavia) serveHTTP(response http.ResponseWriter, request *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := request.URL.Path
+	if path == "/identity/v3/auth/tokens" {
+		f.tokens++
+		response.Header().Set("X-Subject-Token", "token")
+		response.WriteHeader(http.StatusCreated)
+		json.NewEncoder(response).Encode(map[string]interface{}{"token": map[string]interface{}{
+			"expires_at": time.Now().Add(time.Hour),
+			"catalog": []map[string]interface{}{
+				{"type": "load-balancer", "endpoints": []map[string]string{
+					{"interface": "public", "region": "RegionOne", "url": f.server.URL + "/octavia"},
+					{"interface": "public", "region": "RegionTwo", "url": "http://elsewhere"},
+				}},
+				{"type": "compute", "endpoints": []map[string]string{
+					{"interface": "public", "region": "RegionOne", "url": f.server.URL + "/nova/v2.1"},
+				}},
+			},
+		}})
+		return
+	}
+	if request.Header.Get("X-Auth-Token") != "token" {
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case path == "/nova/v2.1/servers/detail":
+		name := strings.Trim(request.URL.Query().Get("name"), "^$")
+		servers := []map[string]interface{}{}
+		if address, ok := f.servers[name]; ok {
+			servers = append(servers, map[string]interface{}{"name": name, "addresses": map[string]interface{}{
+				"private": []map[string]string{{"addr": address, "OS-EXT-IPS:type": "fixed"}, {"addr": "172.24.4.10", "OS-EXT-IPS:type": "floating"}},
+			}})
+		}
+		json.NewEncoder(response).Encode(map[string]interface{}{"servers": servers})
+
+	case path == "/octavia/v2/lbaas/loadbalancers":
+		json.NewEncoder(response).Encode(map[string]interface{}{"loadbalancers": f.loadBalancers})
+
+	case path == "/octavia/v2/lbaas/pools":
+		json.NewEncoder(response).Encode(map[string]interface{}{"pools": f.pools[request.URL.Query().Get("loadbalancer_id")]})
+
+	case strings.HasSuffix(path, "/members"):
+		poolID := strings.Split(path, "/")[5]
+		members := []Member{}
+		for _, member := range f.members {
+			if member.poolID == poolID {
+				members = append(members, member.Member)
+			}
+		}
+		json.NewEncoder(response).Encode(map[string]interface{}{"members": members})
+
+	case strings.Contains(path, "/members/"):
+		parts := strings.Split(path, "/")
+		f.serveMember(response, request, parts[5], parts[7])
+
+	default:
+		response.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeOctavia) serveMember(response http.ResponseWriter, request *http.Request, poolID string, memberID string) {
+	index := -1
+	for i, member := range f.members {
+		if member.poolID == poolID && member.ID == memberID {
+			index = i
+		}
+	}
+	if index < 0 {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+	member := f.members[index]
+
+	if request.Method != "GET" && (f.conflicts > 0 || member.polls > 0) {
+		f.conflicts--
+		response.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		if member.polls > 0 {
+			member.polls--
+			if member.polls == 0 {
+				if member.ProvisioningStatus == "PENDING_DELETE" {
+					f.members = append(f.members[:index], f.members[index+1:]...)
+					response.WriteHeader(http.StatusNotFound)
+					return
+				}
+				member.ProvisioningStatus = "ACTIVE"
+				if member.Weight == 0 && !f.stayOnline {
+					member.OperatingStatus = "DRAINING"
+				}
+			}
+		}
+		json.NewEncoder(response).Encode(map[string]interface{}{"member": member.Member})
+
+	case "PUT":
+		var body struct {
+			Member struct {
+				Weight int `json:"weight"`
+			} `json:"member"`
+		}
+		json.NewDecoder(request.Body).Decode(&body)
+		member.Weight = body.Member.Weight
+		member.ProvisioningStatus = "PENDING_UPDATE"
+		member.polls = 2
+		f.changes = append(f.changes, "weight "+memberID)
+		response.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		member.ProvisioningStatus = "PENDING_DELETE"
+		member.polls = 2
+		f.changes = append(f.changes, "delete "+memberID)
+		response.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeOctavia) member(id string) *fakeMember {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, member := range f.members {
+		if member.ID == id {
+			return member
+		}
+	}
+	return nil
+}
+
+func newProvider(f *fakeOctavia) *CloudProvider {
+	return &CloudProvider{
+		Base:        deregister.Base{Timeout: time.Second, PollInterval: time.Millisecond},
+		Octavia:     f.client(),
+		ClusterName: "kubernetes",
+	}
+}
+
+func TestDrainNodeFromLoadBalancer(t *testing.T) {
+	f := newFakeOctavia(t)
+	result, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "node-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	if len(result.LoadBalancers) != 3 || result.TimedOut() || result.ClusterName != "kubernetes" {
+		t.Fatalf("failed - unexpected result %+v", result)
+	}
+	if result.LoadBalancers[0].Name != "kube_service_kubernetes_default_web/pool_web_80" || !result.LoadBalancers[0].Deregistered {
+		t.Fatalf("failed - unexpected load balancer result %+v", result.LoadBalancers[0])
+	}
+
+	for _, id := range []string{"pool-1-member-a", "pool-2-member-a", "pool-3-member-a"} {
+		if f.member(id) != nil {
+			t.Fatalf("failed - expected member %s to be removed", id)
+		}
+	}
+	for _, id := range []string{"pool-1-member-b", "pool-4-member-a"} {
+		if member := f.member(id); member == nil || member.Weight != 1 {
+			t.Fatalf("failed - expected member %s to be untouched, got %+v", id, member)
+		}
+	}
+
+	weighted := map[string]bool{}
+	for _, change := range f.changes {
+		parts := strings.Fields(change)
+		if parts[0] == "weight" {
+			weighted[parts[1]] = true
+		} else if !weighted[parts[1]] {
+			t.Fatalf("failed - member %s removed before its weight was set to 0: %v", parts[1], f.changes)
+		}
+	}
+	if f.tokens != 1 {
+		t.Fatalf("failed - expected the token to be reused, requested %d", f.tokens)
+	}
+}
+
+func TestDrainNodeRetriesConflicts(t *testing.T) {
+	f := newFakeOctavia(t)
+	f.conflicts = 4
+	result, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "10.0.0.5")
+	if err != nil || len(result.LoadBalancers) != 3 || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if f.member("pool-3-member-a") != nil {
+		t.Fatal("failed - expected member to be removed after conflicts")
+	}
+}
+
+func TestDrainNodeTimesOutWhileMembersStayOnline(t *testing.T) {
+	f := newFakeOctavia(t)
+	f.stayOnline = true
+	provider := newProvider(f)
+	provider.Timeout = 20 * time.Millisecond
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "node-1")
+	if err != nil || !result.TimedOut() {
+		t.Fatalf("failed - expected timeout, got %+v %v", result, err)
+	}
+
+	member := f.member("pool-1-member-a")
+	if member == nil || member.Weight != 0 {
+		t.Fatalf("failed - expected member to be kept with weight 0, got %+v", member)
+	}
+}
+
+func TestDrainNodeWaitsForDrainDelay(t *testing.T) {
+	f := newFakeOctavia(t)
+	provider := newProvider(f)
+	provider.DrainDelay = 50 * time.Millisecond
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "node-1")
+	if err != nil || result.TimedOut() {
+		t.Fatalf("failed - unexpected result %+v %v", result, err)
+	}
+	if result.Duration < provider.DrainDelay {
+		t.Fatalf("failed - expected drain to wait %v, took %v", provider.DrainDelay, result.Duration)
+	}
+}
+
+func TestDrainNodeNotFound(t *testing.T) {
+	f := newFakeOctavia(t)
+	if _, err := newProvider(f).DrainNodeFromLoadBalancer(context.Background(), "node-2"); err == nil {
+		t.Fatal("failed - expected error for unknown node")
+	}
+}
+
+func TestDryRunDrainChangesNothing(t *testing.T) {
+	f := newFakeOctavia(t)
+	provider := newProvider(f)
+	provider.DrainDelay = 30 * time.Second
+	dryRun, _ := deregister.Wrap(provider, deregister.WithDryRun(false))
+	ctx := deregister.WithOptions(context.Background(), deregister.DrainOptions{DryRun: true})
+	result, err := dryRun.DrainNodeFromLoadBalancer(ctx, "node-1")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	if !result.DryRun || result.Plan == nil || len(result.Plan.LoadBalancers) != 3 {
+		t.Fatalf("failed - unexpected result %+v", result)
+	}
+	planned := result.Plan.LoadBalancers[0]
+	if planned.Action != deregister.PlanDeregister || planned.State != "ONLINE" || !planned.WouldTimeOut {
+		t.Fatalf("failed - unexpected plan %+v", planned)
+	}
+	if len(f.changes) != 0 {
+		t.Fatalf("failed - expected no changes, got %v", f.changes)
+	}
+}
+
+func TestNewReadsDrainDelayInSeconds(t *testing.T) {
+	for value, expected := range map[interface{}]time.Duration{30: 30 * time.Second, "2m": 2 * time.Minute} {
+		provider, err := New(deregister.ProviderConfig{Name: "openstack", Settings: map[string]interface{}{
+			"authUrl":                 "https://keystone.example.org/v3",
+			"applicationCredentialId": "credential",
+			"drainDelay":              value,
+		}})
+		if err != nil {
+			t.Fatalf("failed - unexpected error %v", err)
+		}
+		if delay := provider.(*CloudProvider).DrainDelay; delay != expected {
+			t.Fatalf("failed - expected drainDelay %v to be %v, got %v", value, expected, delay)
+		}
+	}
+}
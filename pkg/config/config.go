@@ -0,0 +1,363 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v2"
+)
+
+// Run modes
+const (
+	ModeServer = "server"
+	ModeSQS    = "sqs"
+	ModeIMDS   = "imds"
+	ModeLambda = "lambda"
+)
+
+// Config is the complete application configuration, shared by the server and lambdas
+type Config struct {
+	Mode          string    `yaml:"mode" json:"mode"`
+	LogLevel      string    `yaml:"logLevel" json:"logLevel"`
+	LogFormat     string    `yaml:"logFormat" json:"logFormat"`
+	CloudProvider string    `yaml:"cloudProvider" json:"cloudProvider"`
+	AWS           AWSConfig `yaml:"aws" json:"aws"`
+	// Providers holds each cloud provider's own settings by provider name. They may
+	// include credentials so are never served
+	Providers        map[string]map[string]interface{} `yaml:"providers" json:"-"`
+	Timeout          Duration                          `yaml:"timeout" json:"timeout"`
+	MaxTimeout       Duration                          `yaml:"maxTimeout" json:"maxTimeout"`
+	PollInterval     Duration                          `yaml:"pollInterval" json:"pollInterval"`
+	DryRun           bool                              `yaml:"dryRun" json:"dryRun"`
+	DrainAttempts    int                               `yaml:"drainAttempts" json:"drainAttempts"`
+	ListenAddress    string                            `yaml:"listenAddress" json:"listenAddress"`
+	TagSelectors     map[string]string                 `yaml:"tagSelectors" json:"tagSelectors"`
+	Auth             AuthConfig                        `yaml:"auth" json:"auth"`
+	TLS              TLSConfig                         `yaml:"tls" json:"tls"`
+	PolicyFile       string                            `yaml:"policyFile" json:"policyFile"`
+	NotifiersFile    string                            `yaml:"notifiersFile" json:"notifiersFile"`
+	KubernetesEvents bool                              `yaml:"kubernetesEvents" json:"kubernetesEvents"`
+	SQS              SQSConfig                         `yaml:"sqs" json:"sqs"`
+	IMDS             IMDSConfig                        `yaml:"imds" json:"imds"`
+	EMFNamespace     string                            `yaml:"emfNamespace" json:"emfNamespace"`
+}
+
+// AWSConfig configures the AWS cloud provider
+type AWSConfig struct {
+	Region string `yaml:"region" json:"region"`
+}
+
+// AuthConfig configures how API requests are authenticated
+type AuthConfig struct {
+	Mode                 string            `yaml:"mode" json:"mode"`
+	Secret               string            `yaml:"secret" json:"-"`
+	SecretFile           string            `yaml:"secretFile" json:"secretFile"`
+	Tokens               map[string]string `yaml:"tokens" json:"-"`
+	HMACKeys             map[string]string `yaml:"hmacKeys" json:"-"`
+	HMACMaxSkew          Duration          `yaml:"hmacMaxSkew" json:"hmacMaxSkew"`
+	TokenReviewAudiences []string          `yaml:"tokenReviewAudiences" json:"tokenReviewAudiences"`
+	AllowedClients       []string          `yaml:"allowedClients" json:"allowedClients"`
+}
+
+// TLSConfig configures serving the API over TLS
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile" json:"certFile"`
+	KeyFile      string `yaml:"keyFile" json:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile" json:"clientCAFile"`
+}
+
+// SQSConfig configures the SQS consumer mode
+type SQSConfig struct {
+	QueueURL string `yaml:"queueURL" json:"queueURL"`
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// MaxReceives is how many times a notice is received before it is given up on, or 0 to
+	// leave it to the queue's redrive policy
+	MaxReceives int `yaml:"maxReceives" json:"maxReceives"`
+}
+
+// IMDSConfig configures the instance metadata watcher mode
+type IMDSConfig struct {
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// ScheduledEventLeadTime is how long before a scheduled maintenance event the instance is drained
+	ScheduledEventLeadTime Duration `yaml:"scheduledEventLeadTime" json:"scheduledEventLeadTime"`
+}
+
+// Default returns the configuration used before the file and environment are applied
+func Default() *Config {
+	return &Config{
+		Mode:          ModeServer,
+		LogLevel:      "info",
+		LogFormat:     "console",
+		Timeout:       Duration(60 * time.Second),
+		MaxTimeout:    Duration(15 * time.Minute),
+		PollInterval:  Duration(5 * time.Second),
+		DrainAttempts: 1,
+		Auth: AuthConfig{
+			Mode:        "bearer",
+			HMACMaxSkew: Duration(5 * time.Minute),
+		},
+		SQS: SQSConfig{
+			MaxReceives: 5,
+		},
+		IMDS: IMDSConfig{
+			ScheduledEventLeadTime: Duration(10 * time.Minute),
+		},
+		EMFNamespace: "HastaLaVista",
+	}
+}
+
+// Load builds the configuration from defaults, the optional YAML or JSON file at
+// path and environment variable overrides, returning every problem found at once
+func Load(path string) (*Config, error) {
+	return load(path, "", os.LookupEnv)
+}
+
+// LoadLambda loads the configuration for the lambdas and the hlv CLI, which ignore the run mode
+// and serving settings
+func LoadLambda(path string) (*Config, error) {
+	return load(path, ModeLambda, os.LookupEnv)
+}
+
+func load(path string, mode string, lookup func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// JSON is valid YAML, so one decoder reads both formats
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %v", path, err)
+		}
+	}
+
+	problems := c.applyEnv(lookup)
+	if c.Auth.SecretFile != "" {
+		secret, err := ioutil.ReadFile(c.Auth.SecretFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("auth.secretFile (SECRET_FILE): %v", err))
+		}
+		c.Auth.Secret = strings.TrimSpace(string(secret))
+	}
+	if mode != "" {
+		c.Mode = mode
+	}
+	c.applyDefaults()
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return c, nil
+}
+
+// applyDefaults fills settings derived from others
+func (c *Config) applyDefaults() {
+	if len(c.Auth.Tokens) == 0 && c.Auth.Secret != "" {
+		c.Auth.Tokens = map[string]string{"default": c.Auth.Secret}
+	}
+	if len(c.Auth.HMACKeys) == 0 && c.Auth.Secret != "" {
+		c.Auth.HMACKeys = map[string]string{"default": c.Auth.Secret}
+	}
+}
+
+// ProviderConfig returns the registry configuration for the cloud provider. The aws section
+// predates providers and supplies the region of the AWS backed providers when it is not set there
+func (c *Config) ProviderConfig(recorder metrics.Recorder) deregister.ProviderConfig {
+	settings := map[string]interface{}{}
+	for key, value := range c.Providers[c.CloudProvider] {
+		settings[key] = value
+	}
+	if _, ok := settings["region"]; !ok && usesAWS(c.CloudProvider) {
+		settings["region"] = c.AWS.Region
+	}
+
+	return deregister.ProviderConfig{
+		Name:         c.CloudProvider,
+		Timeout:      c.Timeout.Duration(),
+		PollInterval: c.PollInterval.Duration(),
+		TagSelectors: c.TagSelectors,
+		Metrics:      recorder,
+		Settings:     settings,
+	}
+}
+
+// AWSRegion returns the region of the AWS clients built outside of the provider, such as the
+// SQS consumer's. The provider's own region takes precedence over the aws section
+func (c *Config) AWSRegion() string {
+	if usesAWS(c.CloudProvider) {
+		if region, ok := c.Providers[c.CloudProvider]["region"].(string); ok && region != "" {
+			return region
+		}
+	}
+	return c.AWS.Region
+}
+
+// usesAWS reports whether the provider polls AWS load balancers
+func usesAWS(cloudProvider string) bool {
+	return cloudProvider == "aws" || cloudProvider == "kubernetes"
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch c.Mode {
+	case ModeServer, ModeSQS, ModeIMDS, ModeLambda:
+	default:
+		problem("mode (MODE) must be one of server, sqs or imds, got %q", c.Mode)
+	}
+
+	if _, err := zerolog.ParseLevel(strings.ToLower(c.LogLevel)); err != nil || c.LogLevel == "" {
+		problem("logLevel (LOGLEVEL) must be one of debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.LogFormat != "console" && c.LogFormat != "emf" {
+		problem("logFormat (LOG_FORMAT) must be console or emf, got %q", c.LogFormat)
+	}
+
+	switch c.CloudProvider {
+	case "":
+		problem("cloudProvider (CLOUDPROVIDER) is required")
+	case "aws", "kubernetes":
+		if c.AWSRegion() == "" {
+			problem("aws.region (AWS_REGION) is required")
+		}
+	}
+
+	if c.Timeout <= 0 {
+		problem("timeout (TIMEOUT) must be positive")
+	}
+	if c.MaxTimeout < c.Timeout {
+		problem("maxTimeout (MAX_TIMEOUT) must be at least timeout (TIMEOUT)")
+	}
+	if c.PollInterval <= 0 {
+		problem("pollInterval (POLL_INTERVAL) must be positive")
+	}
+	if c.DrainAttempts < 1 {
+		problem("drainAttempts (DRAIN_ATTEMPTS) must be at least 1")
+	}
+	if c.IMDS.ScheduledEventLeadTime < 0 {
+		problem("imds.scheduledEventLeadTime (IMDS_SCHEDULED_EVENT_LEAD_TIME) must not be negative")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		problem("tls.certFile (TLS_CERT_FILE) and tls.keyFile (TLS_KEY_FILE) must be set together")
+	}
+
+	switch c.Mode {
+	case ModeServer:
+		problems = append(problems, c.validateAuth()...)
+	case ModeSQS:
+		if c.SQS.QueueURL == "" {
+			problem("sqs.queueURL (SQS_QUEUE_URL) is required in sqs mode")
+		}
+		if c.AWSRegion() == "" && !usesAWS(c.CloudProvider) {
+			problem("aws.region (AWS_REGION) is required in sqs mode")
+		}
+		if c.SQS.MaxReceives < 0 {
+			problem("sqs.maxReceives (SQS_MAX_RECEIVES) must not be negative")
+		}
+	}
+	return problems
+}
+
+func (c *Config) validateAuth() []string {
+	switch c.Auth.Mode {
+	case "bearer":
+		if len(c.Auth.Tokens) == 0 {
+			return []string{"auth.secret (SECRET) or auth.tokens (AUTH_TOKENS) is required in bearer mode"}
+		}
+	case "hmac":
+		if len(c.Auth.HMACKeys) == 0 {
+			return []string{"auth.secret (SECRET) or auth.hmacKeys (HMAC_KEYS) is required in hmac mode"}
+		}
+		if c.Auth.HMACMaxSkew <= 0 {
+			return []string{"auth.hmacMaxSkew (HMAC_MAX_SKEW) must be positive"}
+		}
+	case "tokenreview":
+	case "clientcert":
+		if c.TLS.ClientCAFile == "" {
+			return []string{"tls.clientCAFile (TLS_CLIENT_CA_FILE) is required in clientcert mode"}
+		}
+	default:
+		return []string{fmt.Sprintf("auth.mode (AUTH_MODE) must be one of bearer, hmac, tokenreview or clientcert, got %q", c.Auth.Mode)}
+	}
+	return nil
+}
+
+// Level is the parsed log level
+func (c *Config) Level() zerolog.Level {
+	level, err := zerolog.ParseLevel(strings.ToLower(c.LogLevel))
+	if err != nil {
+		return zerolog.InfoLevel
+	}
+	return level
+}
+
+// Addr is the address to serve the API on, port 443 with TLS and 80 without by default
+func (c *Config) Addr() string {
+	if c.ListenAddress != "" {
+		return c.ListenAddress
+	}
+	if c.TLS.CertFile != "" {
+		return ":443"
+	}
+	return ":80"
+}
+
+// Duration is a time.Duration read from a Go duration string such as "90s", or
+// a plain number of seconds
+type Duration time.Duration
+
+// ParseDuration parses a Go duration string or a number of seconds
+func ParseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, expected seconds or a duration such as 90s", value)
+	}
+	return Duration(d), nil
+}
+
+// UnmarshalYAML reads a duration string or number of seconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	parsed, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Duration returns the value as a time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
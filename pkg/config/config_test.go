@@ -0,0 +1,166 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileWithEnvOverrides(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+cloudProvider: aws
+aws:
+  region: us-east-1
+timeout: 2m
+pollInterval: 10
+tagSelectors:
+  team: payments
+auth:
+  secret: file-secret
+`)
+
+	c, err := load(path, "", env(map[string]string{"TIMEOUT": "90", "DRYRUN": "true", "LOGLEVEL": "DEBUG"}))
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	if c.Timeout.Duration() != 90*time.Second {
+		t.Fatalf("failed - expected TIMEOUT to override the file, got %v", c.Timeout.Duration())
+	}
+	if c.PollInterval.Duration() != 10*time.Second {
+		t.Fatalf("failed - expected plain numbers to be seconds, got %v", c.PollInterval.Duration())
+	}
+	if !c.DryRun || c.Level().String() != "debug" || c.TagSelectors["team"] != "payments" {
+		t.Fatalf("failed - unexpected config %+v", c)
+	}
+	if c.Auth.Tokens["default"] != "file-secret" {
+		t.Fatalf("failed - expected the secret to be the default token, got %v", c.Auth.Tokens)
+	}
+	if c.Addr() != ":80" {
+		t.Fatalf("failed - expected plaintext default address, got %v", c.Addr())
+	}
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{"cloudProvider": "aws", "aws": {"region": "eu-west-1"}, "auth": {"mode": "tokenreview"}, "listenAddress": ":8080"}`)
+
+	c, err := load(path, "", env(nil))
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if c.AWS.Region != "eu-west-1" || c.Addr() != ":8080" {
+		t.Fatalf("failed - unexpected config %+v", c)
+	}
+}
+
+func TestLoadAggregatesProblems(t *testing.T) {
+	_, err := load("", "", env(map[string]string{
+		"TIMEOUT":     "abc",
+		"DRYRUN":      "yes",
+		"LOGLEVEL":    "verbose",
+		"AUTH_TOKENS": "missing-value",
+	}))
+	if err == nil {
+		t.Fatalf("failed - expected invalid configuration to be rejected")
+	}
+
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("failed - expected a validation error, got %v", err)
+	}
+
+	expected := []string{"TIMEOUT", "DRYRUN", "AUTH_TOKENS", "LOGLEVEL", "CLOUDPROVIDER", "SECRET"}
+	for _, key := range expected {
+		if !strings.Contains(validationErr.Error(), key) {
+			t.Fatalf("failed - expected a problem mentioning %v, got %v", key, validationErr.Problems)
+		}
+	}
+}
+
+func TestLoadLambdaDoesNotRequireServerSettings(t *testing.T) {
+	c, err := load("", ModeLambda, env(map[string]string{"CLOUDPROVIDER": "aws", "AWS_REGION": "us-east-1", "MODE": "server"}))
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if c.Mode != ModeLambda {
+		t.Fatalf("failed - expected lambda mode, got %v", c.Mode)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := writeFile(t, "config.yaml", "cloudProvider: aws\ntimout: 30s\n")
+	if _, err := load(path, "", env(nil)); err == nil {
+		t.Fatalf("failed - expected misspelled field to be rejected")
+	}
+}
+
+func TestProviderConfig(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+cloudProvider: aws
+drainAttempts: 3
+providers:
+  aws:
+    region: eu-west-1
+auth:
+  secret: file-secret
+`)
+
+	c, err := load(path, "", env(map[string]string{"AWS_REGION": "us-east-1"}))
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	provider := c.ProviderConfig(nil)
+	if provider.Name != "aws" || provider.Settings["region"] != "eu-west-1" || provider.Timeout != time.Minute {
+		t.Fatalf("failed - expected the providers section to take precedence, got %+v", provider)
+	}
+	if c.DrainAttempts != 3 {
+		t.Fatalf("failed - expected 3 drain attempts, got %v", c.DrainAttempts)
+	}
+	if region := c.AWSRegion(); region != "eu-west-1" {
+		t.Fatalf("failed - expected the provider region for the other AWS clients, got %v", region)
+	}
+
+	c.Providers = nil
+	if region := c.ProviderConfig(nil).Settings["region"]; region != "us-east-1" {
+		t.Fatalf("failed - expected AWS_REGION to be the aws region, got %v", region)
+	}
+
+	c.CloudProvider = "kubernetes"
+	if region := c.ProviderConfig(nil).Settings["region"]; region != "us-east-1" {
+		t.Fatalf("failed - expected AWS_REGION to be the kubernetes provider region, got %v", region)
+	}
+
+	if _, err := load("", "", env(map[string]string{"CLOUDPROVIDER": "aws", "AWS_REGION": "us-east-1", "SECRET": "s", "DRAIN_ATTEMPTS": "0"})); err == nil {
+		t.Fatalf("failed - expected drainAttempts below 1 to be rejected")
+	}
+
+	sqs := map[string]string{"MODE": "sqs", "CLOUDPROVIDER": "gcp", "SQS_QUEUE_URL": "queue"}
+	if _, err := load("", "", env(sqs)); err == nil || !strings.Contains(err.Error(), "required in sqs mode") {
+		t.Fatalf("failed - expected the sqs consumer to need an AWS region, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// applyEnv overrides the configuration with any environment variables which are set,
+// returning a problem for each value which cannot be parsed
+func (c *Config) applyEnv(lookup func(string) (string, bool)) []string {
+	var problems []string
+	str := func(key string, target *string) {
+		if value, ok := lookup(key); ok && value != "" {
+			*target = value
+		}
+	}
+	boolean := func(key string, target *bool) {
+		if value, ok := lookup(key); ok && value != "" {
+			switch strings.ToLower(value) {
+			case "1", "true":
+				*target = true
+			case "0", "false":
+				*target = false
+			default:
+				problems = append(problems, fmt.Sprintf("%s must be 1, 0, true or false, got %q", key, value))
+			}
+		}
+	}
+	duration := func(key string, target *Duration) {
+		if value, ok := lookup(key); ok && value != "" {
+			d, err := ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+				return
+			}
+			*target = d
+		}
+	}
+	integer := func(key string, target *int) {
+		if value, ok := lookup(key); ok && value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a whole number, got %q", key, value))
+				return
+			}
+			*target = n
+		}
+	}
+	list := func(key string, target *[]string) {
+		if value, ok := lookup(key); ok && value != "" {
+			*target = splitList(value)
+		}
+	}
+	pairs := func(key string, target *map[string]string, allowEmpty bool) {
+		if value, ok := lookup(key); ok && value != "" {
+			parsed, err := splitPairs(value, allowEmpty)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s %v", key, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	str("MODE", &c.Mode)
+	str("LOGLEVEL", &c.LogLevel)
+	str("LOG_FORMAT", &c.LogFormat)
+	str("CLOUDPROVIDER", &c.CloudProvider)
+	str("AWS_REGION", &c.AWS.Region)
+	duration("TIMEOUT", &c.Timeout)
+	duration("MAX_TIMEOUT", &c.MaxTimeout)
+	duration("POLL_INTERVAL", &c.PollInterval)
+	boolean("DRYRUN", &c.DryRun)
+	integer("DRAIN_ATTEMPTS", &c.DrainAttempts)
+	str("LISTEN_ADDRESS", &c.ListenAddress)
+	pairs("LB_TAG_SELECTORS", &c.TagSelectors, true)
+	str("AUTH_MODE", &c.Auth.Mode)
+	str("SECRET", &c.Auth.Secret)
+	str("SECRET_FILE", &c.Auth.SecretFile)
+	pairs("AUTH_TOKENS", &c.Auth.Tokens, false)
+	pairs("HMAC_KEYS", &c.Auth.HMACKeys, false)
+	duration("HMAC_MAX_SKEW", &c.Auth.HMACMaxSkew)
+	list("TOKENREVIEW_AUDIENCES", &c.Auth.TokenReviewAudiences)
+	list("TLS_ALLOWED_CLIENTS", &c.Auth.AllowedClients)
+	str("TLS_CERT_FILE", &c.TLS.CertFile)
+	str("TLS_KEY_FILE", &c.TLS.KeyFile)
+	str("TLS_CLIENT_CA_FILE", &c.TLS.ClientCAFile)
+	str("POLICY_FILE", &c.PolicyFile)
+	str("NOTIFIERS_FILE", &c.NotifiersFile)
+	boolean("KUBERNETES_EVENTS", &c.KubernetesEvents)
+	str("SQS_QUEUE_URL", &c.SQS.QueueURL)
+	str("SQS_ENDPOINT", &c.SQS.Endpoint)
+	integer("SQS_MAX_RECEIVES", &c.SQS.MaxReceives)
+	str("IMDS_ENDPOINT", &c.IMDS.Endpoint)
+	duration("IMDS_SCHEDULED_EVENT_LEAD_TIME", &c.IMDS.ScheduledEventLeadTime)
+	str("EMF_NAMESPACE", &c.EMFNamespace)
+	return problems
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitPairs parses comma separated name=value pairs
+func splitPairs(value string, allowEmpty bool) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if parts[0] == "" || (!allowEmpty && (len(parts) != 2 || parts[1] == "")) {
+			return nil, fmt.Errorf("must be comma separated name=value pairs")
+		}
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Store holds the active configuration, reloading it when its file or a file it
+// references changes
+type Store struct {
+	path string
+	load func() (*Config, error)
+
+	mu        sync.RWMutex
+	current   *Config
+	version   int
+	checksum  string
+	loadedAt  time.Time
+	listeners []func(previous *Config, current *Config)
+}
+
+// NewStore loads the configuration at path and environment into a store
+func NewStore(path string) (*Store, error) {
+	return newStore(path, func() (*Config, error) { return Load(path) })
+}
+
+func newStore(path string, load func() (*Config, error)) (*Store, error) {
+	s := &Store{path: path, load: load}
+	c, err := load()
+	if err != nil {
+		return nil, err
+	}
+	s.set(c)
+	return s, nil
+}
+
+// Current returns the active configuration, which must not be modified
+func (s *Store) Current() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// OnChange registers a function called with the previous and new configuration after each reload
+func (s *Store) OnChange(listener func(previous *Config, current *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Reload loads the configuration again, swapping it in and notifying listeners if it changed.
+// An invalid configuration is returned as an error and the active configuration kept
+func (s *Store) Reload() (bool, error) {
+	c, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	if s.checksum == checksum(s.path, c) {
+		s.mu.Unlock()
+		return false, nil
+	}
+	previous := s.current
+	s.set(c)
+	version := s.version
+	listeners := append([]func(*Config, *Config){}, s.listeners...)
+	s.mu.Unlock()
+
+	log.Info().
+		Int("version", version).
+		Strs("changes", Diff(previous, c)).
+		Msg("reloaded configuration")
+	for _, listener := range listeners {
+		listener(previous, c)
+	}
+	return true, nil
+}
+
+// Watch reloads the configuration on an interval until the context is cancelled.
+// Polling picks up both edited files and the symlink swaps of mounted ConfigMaps and Secrets
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.Reload(); err != nil {
+			log.Error().Err(err).Msg("error reloading configuration, keeping previous configuration")
+		}
+	}
+}
+
+// ServeHTTP reports the active configuration version, without secrets
+func (s *Store) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	s.mu.RLock()
+	status := struct {
+		Version  int       `json:"version"`
+		LoadedAt time.Time `json:"loadedAt"`
+		Config   *Config   `json:"config"`
+	}{s.version, s.loadedAt, s.current}
+	s.mu.RUnlock()
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(status)
+}
+
+// set swaps in the configuration, the caller must hold the lock or own the store
+func (s *Store) set(c *Config) {
+	s.current = c
+	s.version++
+	s.checksum = checksum(s.path, c)
+	s.loadedAt = time.Now()
+}
+
+// checksum hashes the contents of the configuration file and of the policy, notifiers and
+// secret files it references, which are read when the configuration is applied. TLS files
+// are left to the certificate reloader. A missing file hashes differently to an empty one
+func checksum(path string, c *Config) string {
+	hash := sha256.New()
+	for _, file := range []string{path, c.PolicyFile, c.NotifiersFile, c.Auth.SecretFile} {
+		if file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(hash, "%s: %v\n", file, err)
+			continue
+		}
+		contents := sha256.Sum256(data)
+		fmt.Fprintf(hash, "%s: %x\n", file, contents)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// RequiresRestart lists changed settings which only take effect when the process restarts
+func RequiresRestart(previous *Config, current *Config) []string {
+	var fields []string
+	if previous.Mode != current.Mode {
+		fields = append(fields, "mode")
+	}
+	if previous.Addr() != current.Addr() {
+		fields = append(fields, "listenAddress")
+	}
+	if previous.TLS != current.TLS {
+		fields = append(fields, "tls")
+	}
+	if previous.LogFormat != current.LogFormat {
+		fields = append(fields, "logFormat")
+	}
+	return fields
+}
+
+// Diff describes each setting which differs between two configurations. Secret values are redacted
+func Diff(previous *Config, current *Config) []string {
+	before, after := map[string]field{}, map[string]field{}
+	flatten("", reflect.ValueOf(*previous), false, before)
+	flatten("", reflect.ValueOf(*current), false, after)
+
+	var changes []string
+	for name, f := range after {
+		old, ok := before[name]
+		if ok && old.value == f.value {
+			continue
+		}
+		if f.secret {
+			changes = append(changes, name+" changed")
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, old.value, f.value))
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, name+" removed")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+type field struct {
+	value  string
+	secret bool
+}
+
+// flatten records every leaf setting by its YAML path. Fields hidden from JSON are secret
+func flatten(prefix string, v reflect.Value, secret bool, fields map[string]field) {
+	if v.Kind() != reflect.Struct {
+		fields[prefix] = field{value: fmt.Sprintf("%v", v.Interface()), secret: secret}
+		return
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		value := v.Field(i)
+		if d, ok := value.Interface().(Duration); ok {
+			fields[name] = field{value: d.Duration().String()}
+			continue
+		}
+		flatten(name, value, secret || structField.Tag.Get("json") == "-", fields)
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "cloudProvider: aws\naws:\n  region: us-east-1\nauth:\n  secret: first\n")
+	store, err := newStore(path, func() (*Config, error) { return load(path, "", env(nil)) })
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	var changes [][2]*Config
+	store.OnChange(func(previous *Config, current *Config) {
+		changes = append(changes, [2]*Config{previous, current})
+	})
+
+	if changed, err := store.Reload(); changed || err != nil {
+		t.Fatalf("failed - expected no change for an unchanged file, got %v %v", changed, err)
+	}
+
+	ioutil.WriteFile(path, []byte("cloudProvider: aws\naws:\n  region: us-east-1\ntimeout: 2m\ndryRun: true\nauth:\n  secret: second\n"), 0600)
+	if changed, err := store.Reload(); !changed || err != nil {
+		t.Fatalf("failed - expected the changed file to be reloaded, got %v %v", changed, err)
+	}
+	if len(changes) != 1 || changes[0][0].DryRun || !changes[0][1].DryRun {
+		t.Fatalf("failed - expected listeners to see the previous and new config, got %v", changes)
+	}
+	if current := store.Current(); current.Timeout.Duration() != 2*time.Minute || current.Auth.Tokens["default"] != "second" {
+		t.Fatalf("failed - expected the new config to be active, got %+v", current)
+	}
+
+	ioutil.WriteFile(path, []byte("cloudProvider: gcp\n"), 0600)
+	if _, err := store.Reload(); err == nil {
+		t.Fatalf("failed - expected invalid config to be rejected")
+	}
+	if store.Current().CloudProvider != "aws" || len(changes) != 1 {
+		t.Fatalf("failed - expected the previous config to be kept")
+	}
+
+	response := httptest.NewRecorder()
+	store.ServeHTTP(response, httptest.NewRequest("GET", "/debug/config", nil))
+	if strings.Contains(response.Body.String(), "second") {
+		t.Fatalf("failed - expected secrets to be hidden, got %v", response.Body.String())
+	}
+	var status struct {
+		Version int `json:"version"`
+	}
+	json.NewDecoder(response.Body).Decode(&status)
+	if status.Version != 2 {
+		t.Fatalf("failed - expected version 2, got %v", status.Version)
+	}
+}
+
+func TestStoreReloadsReferencedFiles(t *testing.T) {
+	policyPath := writeFile(t, "policy.json", `{"rules": []}`)
+	path := writeFile(t, "config.yaml", "cloudProvider: aws\naws:\n  region: us-east-1\nauth:\n  secret: first\npolicyFile: "+policyPath+"\n")
+	store, err := newStore(path, func() (*Config, error) { return load(path, "", env(nil)) })
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	changes := 0
+	store.OnChange(func(previous *Config, current *Config) { changes++ })
+
+	if changed, err := store.Reload(); changed || err != nil {
+		t.Fatalf("failed - expected no change for unchanged files, got %v %v", changed, err)
+	}
+
+	ioutil.WriteFile(policyPath, []byte(`{"rules": [{"identities": ["ci"], "actions": ["drain"]}]}`), 0600)
+	if changed, err := store.Reload(); !changed || err != nil {
+		t.Fatalf("failed - expected an edited policy file to reload, got %v %v", changed, err)
+	}
+	if changes != 1 {
+		t.Fatalf("failed - expected listeners to be notified once, got %v", changes)
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	previous, current := Default(), Default()
+	previous.Auth.Secret = "first"
+	current.Auth.Secret = "second"
+	current.Timeout = Duration(2 * time.Minute)
+	current.TagSelectors = map[string]string{"team": "payments"}
+
+	changes := strings.Join(Diff(previous, current), "\n")
+	if strings.Contains(changes, "first") || strings.Contains(changes, "second") {
+		t.Fatalf("failed - expected secret values to be redacted, got %v", changes)
+	}
+	for _, expected := range []string{"auth.secret changed", "timeout: 1m0s -> 2m0s", "tagSelectors: map[] -> map[team:payments]"} {
+		if !strings.Contains(changes, expected) {
+			t.Fatalf("failed - expected %q in diff, got %v", expected, changes)
+		}
+	}
+}
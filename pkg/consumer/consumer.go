@@ -0,0 +1,235 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errDrainTimedOut is returned when the node was still draining from a load balancer at the timeout
+var errDrainTimedOut = errors.New("timed out draining node from load balancers")
+
+// MySQSAPI is a subset of the AWS SQS API interface
+type MySQSAPI interface {
+	ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// MyAutoscalingAPI is a subset of the AWS Autoscaling API interface
+type MyAutoscalingAPI interface {
+	CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error)
+}
+
+// Consumer polls an SQS queue for termination notices and drains
+// the referenced instances with the cloud provider
+type Consumer struct {
+	SQS               MySQSAPI
+	Autoscaling       MyAutoscalingAPI
+	Provider          deregister.CloudProvider
+	QueueURL          string
+	VisibilityTimeout time.Duration
+	WaitTime          time.Duration
+
+	// MaxReceives is how many times a notice is received before a drain which keeps failing
+	// or timing out is given up on and the message deleted. Zero leaves it on the queue for
+	// the queue's redrive policy
+	MaxReceives int
+}
+
+// Run polls the queue until the context is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	log.Info().Str("queueUrl", c.QueueURL).Msg("starting sqs consumer")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("stopping sqs consumer")
+			return nil
+		default:
+		}
+
+		err := c.poll(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("error receiving messages from queue")
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+func (c *Consumer) poll(ctx context.Context) error {
+	output, err := c.SQS.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.QueueURL),
+		MaxNumberOfMessages: aws.Int64(1),
+		VisibilityTimeout:   aws.Int64(int64(c.VisibilityTimeout.Seconds())),
+		WaitTimeSeconds:     aws.Int64(int64(c.WaitTime.Seconds())),
+		AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range output.Messages {
+		c.handleMessage(ctx, msg)
+	}
+	return nil
+}
+
+func (c *Consumer) handleMessage(ctx context.Context, msg *sqs.Message) {
+	messageID := aws.StringValue(msg.MessageId)
+	ctx, span := tracing.Start(ctx, "HandleQueueMessage", trace.WithAttributes(
+		attribute.String("messaging.message_id", messageID),
+	))
+	defer span.End()
+
+	notice, err := ParseNotice([]byte(aws.StringValue(msg.Body)))
+	if err == errIgnoredMessage {
+		log.Info().Str("messageId", messageID).Msg("message does not require a drain, deleting")
+		c.deleteMessage(msg)
+		return
+	}
+
+	// an undecodable message would be received and fail again forever, so it is dropped
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("messageId", messageID).
+			Str("body", aws.StringValue(msg.Body)).
+			Msg("unable to decode message, deleting")
+		c.deleteMessage(msg)
+		return
+	}
+
+	done := make(chan struct{})
+	go c.extendVisibility(msg, done)
+	err = c.drain(ctx, notice)
+	close(done)
+
+	if err != nil && c.exhausted(msg) {
+		log.Error().
+			Err(err).
+			Str("messageId", messageID).
+			Str("instanceId", notice.InstanceID).
+			Int("maxReceives", c.MaxReceives).
+			Msg("termination notice received too many times, deleting")
+		c.deleteMessage(msg)
+		return
+	}
+	if err == errDrainTimedOut {
+		log.Warn().
+			Str("messageId", messageID).
+			Str("instanceId", notice.InstanceID).
+			Msg("node still draining at timeout, leaving on queue to retry")
+		return
+	}
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("messageId", messageID).
+			Str("instanceId", notice.InstanceID).
+			Msg("error handling termination notice, leaving on queue")
+		return
+	}
+
+	log.Info().
+		Str("messageId", messageID).
+		Str("instanceId", notice.InstanceID).
+		Msg("successfully handled termination notice")
+	c.deleteMessage(msg)
+}
+
+// exhausted reports whether the message has been received as many times as allowed
+func (c *Consumer) exhausted(msg *sqs.Message) bool {
+	if c.MaxReceives <= 0 {
+		return false
+	}
+	count, err := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	return err == nil && count >= c.MaxReceives
+}
+
+// drain drains the instance, completing its lifecycle action only once no load balancer is
+// still sending it traffic. A dry-run leaves the lifecycle action for its heartbeat timeout,
+// so the instance is not terminated early when nothing was drained
+func (c *Consumer) drain(ctx context.Context, notice *Notice) error {
+	result, err := c.Provider.DrainNodeFromLoadBalancer(ctx, notice.InstanceID)
+	if err != nil {
+		return err
+	}
+	if result.TimedOut() {
+		return errDrainTimedOut
+	}
+
+	if notice.Lifecycle == nil {
+		return nil
+	}
+	if result.DryRun {
+		log.Info().
+			Str("instanceId", notice.InstanceID).
+			Str("lifecycleHook", notice.Lifecycle.LifecycleHookName).
+			Msg("DRY-RUN (no action taken)---not completing lifecycle action")
+		return nil
+	}
+
+	_, err = c.Autoscaling.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		LifecycleActionResult: aws.String("CONTINUE"),
+		LifecycleActionToken:  aws.String(notice.Lifecycle.LifecycleActionToken),
+		LifecycleHookName:     aws.String(notice.Lifecycle.LifecycleHookName),
+		AutoScalingGroupName:  aws.String(notice.Lifecycle.AutoscalingGroupName),
+		InstanceId:            aws.String(notice.InstanceID),
+	})
+	return err
+}
+
+// extendVisibility keeps the message hidden from other consumers until done is closed
+func (c *Consumer) extendVisibility(msg *sqs.Message, done <-chan struct{}) {
+	interval := c.VisibilityTimeout / 2
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := c.SQS.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(c.QueueURL),
+				ReceiptHandle:     msg.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(int64(c.VisibilityTimeout.Seconds())),
+			})
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("messageId", aws.StringValue(msg.MessageId)).
+					Msg("error extending message visibility")
+			}
+		}
+	}
+}
+
+func (c *Consumer) deleteMessage(msg *sqs.Message) {
+	_, err := c.SQS.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.QueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("messageId", aws.StringValue(msg.MessageId)).
+			Msg("error deleting message")
+	}
+}
@@ -0,0 +1,237 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeQueue is an in-memory stand-in for an SQS queue
+type fakeQueue struct {
+	mu         sync.Mutex
+	messages   []*sqs.Message
+	deleted    []string
+	extensions int
+}
+
+func (q *fakeQueue) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.messages) == 0 {
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+	return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{msg}}, nil
+}
+
+func (q *fakeQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deleted = append(q.deleted, *input.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *fakeQueue) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.extensions++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+type fakeAutoscaling struct {
+	completed []*autoscaling.CompleteLifecycleActionInput
+}
+
+func (a *fakeAutoscaling) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	a.completed = append(a.completed, input)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+type fakeProvider struct {
+	drained  []string
+	delay    time.Duration
+	timedOut bool
+	dryRun   bool
+	err      error
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	time.Sleep(p.delay)
+	p.drained = append(p.drained, nodeName)
+	return &deregister.DrainResult{
+		NodeID:        nodeName,
+		DryRun:        p.dryRun,
+		LoadBalancers: []deregister.LoadBalancerResult{{Name: "lb-1", Deregistered: true, TimedOut: p.timedOut}},
+	}, p.err
+}
+
+func newMessage(handle string, body string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String(handle),
+		ReceiptHandle: aws.String(handle),
+		Body:          aws.String(body),
+	}
+}
+
+func TestConsumerDeletesOnSuccess(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", lifecycleDetail)}}
+	asg := &fakeAutoscaling{}
+	provider := &fakeProvider{}
+	c := &Consumer{SQS: queue, Autoscaling: asg, Provider: provider, QueueURL: "queue"}
+
+	if err := c.poll(context.Background()); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if len(provider.drained) != 1 || provider.drained[0] != "i-0123456789" {
+		t.Fatalf("failed - expected i-0123456789 to be drained, got %v", provider.drained)
+	}
+	if len(asg.completed) != 1 || *asg.completed[0].LifecycleActionToken != "token-1" {
+		t.Fatalf("failed - expected lifecycle action to be completed, got %v", asg.completed)
+	}
+	if len(queue.deleted) != 1 || queue.deleted[0] != "msg-1" {
+		t.Fatalf("failed - expected msg-1 to be deleted, got %v", queue.deleted)
+	}
+}
+
+func TestConsumerKeepsMessageOnFailure(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", spotEvent)}}
+	asg := &fakeAutoscaling{}
+	provider := &fakeProvider{err: errors.New("drain failed")}
+	c := &Consumer{SQS: queue, Autoscaling: asg, Provider: provider, QueueURL: "queue"}
+
+	c.poll(context.Background())
+	if len(queue.deleted) != 0 {
+		t.Fatalf("failed - expected no messages to be deleted, got %v", queue.deleted)
+	}
+}
+
+func TestConsumerKeepsMessageOnTimeout(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", lifecycleDetail)}}
+	asg := &fakeAutoscaling{}
+	provider := &fakeProvider{timedOut: true}
+	c := &Consumer{SQS: queue, Autoscaling: asg, Provider: provider, QueueURL: "queue"}
+
+	c.poll(context.Background())
+	if len(provider.drained) != 1 {
+		t.Fatalf("failed - expected a drain, got %v", provider.drained)
+	}
+	if len(asg.completed) != 0 || len(queue.deleted) != 0 {
+		t.Fatalf("failed - expected a timed out drain to be retried, completed %v deleted %v", asg.completed, queue.deleted)
+	}
+}
+
+func TestConsumerDeletesAfterMaxReceives(t *testing.T) {
+	msg := newMessage("msg-1", lifecycleDetail)
+	msg.Attributes = map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("2")}
+	queue := &fakeQueue{messages: []*sqs.Message{msg}}
+	asg := &fakeAutoscaling{}
+	c := &Consumer{SQS: queue, Autoscaling: asg, Provider: &fakeProvider{timedOut: true}, QueueURL: "queue", MaxReceives: 3}
+
+	c.poll(context.Background())
+	if len(queue.deleted) != 0 {
+		t.Fatalf("failed - expected the message to be retried before its last receive, deleted %v", queue.deleted)
+	}
+
+	msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String("3")
+	queue.messages = []*sqs.Message{msg}
+	c.poll(context.Background())
+	if len(queue.deleted) != 1 || len(asg.completed) != 0 {
+		t.Fatalf("failed - expected the message to be given up on, completed %v deleted %v", asg.completed, queue.deleted)
+	}
+}
+
+func TestConsumerDryRunLeavesLifecycleAction(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", lifecycleDetail)}}
+	asg := &fakeAutoscaling{}
+	c := &Consumer{SQS: queue, Autoscaling: asg, Provider: &fakeProvider{dryRun: true}, QueueURL: "queue"}
+
+	c.poll(context.Background())
+	if len(asg.completed) != 0 || len(queue.deleted) != 1 {
+		t.Fatalf("failed - expected the lifecycle action to be left for a dry-run, completed %v deleted %v", asg.completed, queue.deleted)
+	}
+}
+
+func TestConsumerDeletesUndecodableMessage(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", "not json")}}
+	provider := &fakeProvider{}
+	c := &Consumer{SQS: queue, Autoscaling: &fakeAutoscaling{}, Provider: provider, QueueURL: "queue"}
+
+	c.poll(context.Background())
+	if len(provider.drained) != 0 {
+		t.Fatalf("failed - expected no drain, got %v", provider.drained)
+	}
+	if len(queue.deleted) != 1 {
+		t.Fatalf("failed - expected undecodable message to be deleted, got %v", queue.deleted)
+	}
+}
+
+func TestConsumerDeletesIgnoredMessage(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", `{"Event": "autoscaling:TEST_NOTIFICATION"}`)}}
+	provider := &fakeProvider{}
+	c := &Consumer{SQS: queue, Autoscaling: &fakeAutoscaling{}, Provider: provider, QueueURL: "queue"}
+
+	c.poll(context.Background())
+	if len(provider.drained) != 0 {
+		t.Fatalf("failed - expected no drain, got %v", provider.drained)
+	}
+	if len(queue.deleted) != 1 {
+		t.Fatalf("failed - expected ignored message to be deleted, got %v", queue.deleted)
+	}
+}
+
+func TestConsumerExtendsVisibilityDuringDrain(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", spotEvent)}}
+	provider := &fakeProvider{delay: 50 * time.Millisecond}
+	c := &Consumer{
+		SQS:               queue,
+		Autoscaling:       &fakeAutoscaling{},
+		Provider:          provider,
+		QueueURL:          "queue",
+		VisibilityTimeout: 20 * time.Millisecond,
+	}
+
+	c.poll(context.Background())
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.extensions == 0 {
+		t.Fatalf("failed - expected message visibility to be extended")
+	}
+	if len(queue.deleted) != 1 {
+		t.Fatalf("failed - expected message to be deleted, got %v", queue.deleted)
+	}
+}
+
+func TestConsumerRunStopsOnCancel(t *testing.T) {
+	queue := &fakeQueue{messages: []*sqs.Message{newMessage("msg-1", spotEvent)}}
+	provider := &fakeProvider{}
+	c := &Consumer{SQS: queue, Autoscaling: &fakeAutoscaling{}, Provider: provider, QueueURL: "queue"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("failed - consumer did not stop after cancel")
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if len(queue.deleted) != 1 {
+		t.Fatalf("failed - expected message to be handled, got %v", queue.deleted)
+	}
+}
@@ -0,0 +1,141 @@
+package consumer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	spotInterruptionDetailType = "EC2 Spot Instance Interruption Warning"
+	lifecycleActionDetailType  = "EC2 Instance-terminate Lifecycle Action"
+	terminatingTransition      = "autoscaling:EC2_INSTANCE_TERMINATING"
+	testNotificationEvent      = "autoscaling:TEST_NOTIFICATION"
+)
+
+// errIgnoredMessage is returned for well formed messages that do not require a drain,
+// e.g. ASG test notifications or non-terminate spot actions
+var errIgnoredMessage = errors.New("message does not require a drain")
+
+// Notice is a decoded termination notice for an instance
+type Notice struct {
+	InstanceID string
+	Lifecycle  *LifecycleAction
+}
+
+// LifecycleAction holds the details required to complete an ASG lifecycle hook
+type LifecycleAction struct {
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	AutoscalingGroupName string `json:"AutoScalingGroupName"`
+	Event                string `json:"Event"`
+}
+
+// spotDetail is the detail of a spot interruption warning
+type spotDetail struct {
+	InstanceID     string `json:"instance-id"`
+	InstanceAction string `json:"instance-action"`
+}
+
+// envelope holds the fields used to detect how a message body is wrapped
+type envelope struct {
+	// SNS notification fields
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+
+	// EventBridge / CloudWatch event fields
+	DetailType string          `json:"detail-type"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// ParseNotice decodes a queue message body into a termination notice. Bodies may be
+// raw ASG lifecycle or spot interruption details, EventBridge events, or either of those
+// wrapped in an SNS notification.
+func ParseNotice(body []byte) (*Notice, error) {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, err
+	}
+
+	if env.Type == "Notification" {
+		return ParseNotice([]byte(env.Message))
+	}
+
+	if env.DetailType != "" {
+		return parseEvent(env.DetailType, env.Detail)
+	}
+
+	return parseRaw(body)
+}
+
+func parseEvent(detailType string, detail json.RawMessage) (*Notice, error) {
+	switch detailType {
+	case spotInterruptionDetailType:
+		return parseSpotDetail(detail)
+	case lifecycleActionDetailType:
+		return parseLifecycleAction(detail)
+	}
+
+	return nil, fmt.Errorf("unexpected detail-type %q", detailType)
+}
+
+func parseRaw(body []byte) (*Notice, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["instance-action"]; ok {
+		return parseSpotDetail(body)
+	}
+
+	if _, ok := fields["LifecycleTransition"]; ok {
+		return parseLifecycleAction(body)
+	}
+
+	if _, ok := fields["Event"]; ok {
+		return parseLifecycleAction(body)
+	}
+
+	return nil, errors.New("unrecognized message format")
+}
+
+func parseSpotDetail(raw []byte) (*Notice, error) {
+	var detail spotDetail
+	if err := json.Unmarshal(raw, &detail); err != nil {
+		return nil, err
+	}
+
+	if detail.InstanceAction != "terminate" {
+		return nil, errIgnoredMessage
+	}
+
+	if detail.InstanceID == "" {
+		return nil, errors.New("spot interruption missing instance-id")
+	}
+
+	return &Notice{InstanceID: detail.InstanceID}, nil
+}
+
+func parseLifecycleAction(raw []byte) (*Notice, error) {
+	var action LifecycleAction
+	if err := json.Unmarshal(raw, &action); err != nil {
+		return nil, err
+	}
+
+	if action.Event == testNotificationEvent {
+		return nil, errIgnoredMessage
+	}
+
+	if action.LifecycleTransition != terminatingTransition {
+		return nil, errIgnoredMessage
+	}
+
+	if action.EC2InstanceID == "" {
+		return nil, errors.New("lifecycle action missing EC2InstanceId")
+	}
+
+	return &Notice{InstanceID: action.EC2InstanceID, Lifecycle: &action}, nil
+}
@@ -0,0 +1,95 @@
+package consumer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const lifecycleDetail = `{
+	"LifecycleActionToken": "token-1",
+	"AutoScalingGroupName": "my-asg",
+	"LifecycleHookName": "drain-hook",
+	"EC2InstanceId": "i-0123456789",
+	"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING"
+}`
+
+const spotEvent = `{
+	"version": "0",
+	"detail-type": "EC2 Spot Instance Interruption Warning",
+	"source": "aws.ec2",
+	"detail": {
+		"instance-id": "i-0123456789",
+		"instance-action": "terminate"
+	}
+}`
+
+func snsWrap(t *testing.T, body string) string {
+	wrapped, err := json.Marshal(map[string]string{
+		"Type":    "Notification",
+		"Message": body,
+	})
+	if err != nil {
+		t.Fatalf("failed to wrap message: %v", err)
+	}
+	return string(wrapped)
+}
+
+func TestParseNotice(t *testing.T) {
+	lifecycleEvent := `{"detail-type": "EC2 Instance-terminate Lifecycle Action", "source": "aws.autoscaling", "detail": ` + lifecycleDetail + `}`
+	cases := []struct {
+		Name          string
+		Body          string
+		InstanceID    string
+		WantLifecycle bool
+	}{
+		{Name: "raw lifecycle", Body: lifecycleDetail, InstanceID: "i-0123456789", WantLifecycle: true},
+		{Name: "raw spot", Body: `{"instance-id": "i-0123456789", "instance-action": "terminate"}`, InstanceID: "i-0123456789"},
+		{Name: "eventbridge spot", Body: spotEvent, InstanceID: "i-0123456789"},
+		{Name: "eventbridge lifecycle", Body: lifecycleEvent, InstanceID: "i-0123456789", WantLifecycle: true},
+		{Name: "sns lifecycle", Body: snsWrap(t, lifecycleDetail), InstanceID: "i-0123456789", WantLifecycle: true},
+		{Name: "sns eventbridge spot", Body: snsWrap(t, spotEvent), InstanceID: "i-0123456789"},
+	}
+
+	for _, c := range cases {
+		notice, err := ParseNotice([]byte(c.Body))
+		if err != nil {
+			t.Fatalf("%s failed - unexpected error %v", c.Name, err)
+		}
+		if notice.InstanceID != c.InstanceID {
+			t.Fatalf("%s failed - expected instance %v, got %v", c.Name, c.InstanceID, notice.InstanceID)
+		}
+		if (notice.Lifecycle != nil) != c.WantLifecycle {
+			t.Fatalf("%s failed - expected lifecycle action %v, got %v", c.Name, c.WantLifecycle, notice.Lifecycle)
+		}
+	}
+}
+
+func TestParseNoticeIgnored(t *testing.T) {
+	cases := []string{
+		`{"Event": "autoscaling:TEST_NOTIFICATION", "AutoScalingGroupName": "my-asg"}`,
+		`{"instance-id": "i-0123456789", "instance-action": "stop"}`,
+		`{"EC2InstanceId": "i-0123456789", "LifecycleTransition": "autoscaling:EC2_INSTANCE_LAUNCHING"}`,
+	}
+
+	for i, c := range cases {
+		_, err := ParseNotice([]byte(c))
+		if err != errIgnoredMessage {
+			t.Fatalf("%d failed - expected message to be ignored, got %v", i, err)
+		}
+	}
+}
+
+func TestParseNoticeInvalid(t *testing.T) {
+	cases := []string{
+		`not json`,
+		`{"detail-type": "Some Other Event", "detail": {}}`,
+		`{"unrelated": "field"}`,
+	}
+
+	for i, c := range cases {
+		_, err := ParseNotice([]byte(c))
+		if err == nil || err == errIgnoredMessage {
+			t.Fatalf("%d failed - expected decode error, got %v", i, err)
+		}
+	}
+}
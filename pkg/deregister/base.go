@@ -0,0 +1,90 @@
+package deregister
+
+import (
+	"context"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Base holds the settings every provider shares, with helpers to time drains, wait between
+// polls and instrument cloud API calls. Providers embed it
+type Base struct {
+	Timeout time.Duration
+	Metrics metrics.Recorder
+
+	// PollInterval is how often drain progress is checked, defaulting to 5s
+	PollInterval time.Duration
+
+	// Clock times drains and waits between polls, defaulting to the system clock
+	Clock Clock
+}
+
+// NewBase returns the shared settings from the provider's configuration
+func NewBase(cfg ProviderConfig) Base {
+	return Base{Timeout: cfg.Timeout, Metrics: cfg.Metrics, PollInterval: cfg.PollInterval}
+}
+
+// DrainTimeout returns how long to wait for the node to drain from each load balancer,
+// preferring the drain options' timeout
+func (b *Base) DrainTimeout(ctx context.Context) time.Duration {
+	if timeout := OptionsFromContext(ctx).Timeout; timeout > 0 {
+		return timeout
+	}
+	return b.Timeout
+}
+
+// Interval returns how long to wait between drain progress checks
+func (b *Base) Interval() time.Duration {
+	if b.PollInterval <= 0 {
+		return 5 * time.Second
+	}
+	return b.PollInterval
+}
+
+// Wait waits out the poll interval, returning the context's error if it is cancelled first
+func (b *Base) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.clock().After(b.Interval()):
+		return nil
+	}
+}
+
+// Now returns the time on the provider's clock
+func (b *Base) Now() time.Time {
+	return b.clock().Now()
+}
+
+// Since returns the time elapsed on the provider's clock
+func (b *Base) Since(t time.Time) time.Duration {
+	return b.clock().Now().Sub(t)
+}
+
+// Recorder returns the metrics recorder, discarding metrics if none is configured
+func (b *Base) Recorder() metrics.Recorder {
+	if b.Metrics == nil {
+		return metrics.Nop{}
+	}
+	return b.Metrics
+}
+
+// StartAPICall starts a span for a cloud API call. The returned function records
+// the call metrics and ends the span
+func (b *Base) StartAPICall(ctx context.Context, operation string) func(err error) {
+	_, span := tracing.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient))
+	return func(err error) {
+		b.Recorder().APICall(operation, err)
+		tracing.End(span, err)
+	}
+}
+
+func (b *Base) clock() Clock {
+	if b.Clock == nil {
+		return RealClock{}
+	}
+	return b.Clock
+}
@@ -0,0 +1,23 @@
+package deregister
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBaseWaitStopsWhenCancelled(t *testing.T) {
+	clock := &fakeClock{}
+	base := Base{PollInterval: time.Second, Clock: clock}
+
+	if err := base.Wait(context.Background()); err != nil || len(clock.waits) != 1 || clock.waits[0] != time.Second {
+		t.Fatalf("failed - expected to wait out the poll interval, got %v after %v", err, clock.waits)
+	}
+
+	clock.blocked = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := base.Wait(ctx); err != context.Canceled {
+		t.Fatalf("failed - expected the cancelled context's error, got %v", err)
+	}
+}
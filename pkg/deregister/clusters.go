@@ -0,0 +1,39 @@
+package deregister
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClusterNotFound is returned when no nodes of the cluster can be found
+var ErrClusterNotFound = errors.New("cluster not found")
+
+// ClusterLister is implemented by providers which can report the health of every load
+// balancer in a cluster
+type ClusterLister interface {
+	ListClusterLoadBalancers(ctx context.Context, clusterName string) (*ClusterLoadBalancers, error)
+}
+
+// ClusterLoadBalancers are the load balancers and target groups of a cluster
+type ClusterLoadBalancers struct {
+	ClusterName   string               `json:"clusterName"`
+	LoadBalancers []LoadBalancerHealth `json:"loadBalancers"`
+}
+
+// LoadBalancerHealth counts the targets of a load balancer, or of a target group under it,
+// by their health
+type LoadBalancerHealth struct {
+	LoadBalancer string `json:"loadBalancer"`
+	// TargetGroup is set for load balancers which route to targets through target groups
+	TargetGroup string `json:"targetGroup,omitempty"`
+	Type        string `json:"type"`
+	Healthy     int    `json:"healthy"`
+	// Unhealthy counts targets which are registered but not serving, including those
+	// still waiting for their first health checks
+	Unhealthy int `json:"unhealthy"`
+	Draining  int `json:"draining"`
+	Unused    int `json:"unused"`
+	// StaleTargets are registered instances which no longer exist, such as nodes
+	// terminated without being drained
+	StaleTargets []string `json:"staleTargets"`
+}
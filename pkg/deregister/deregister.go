@@ -1,6 +1,66 @@
 package deregister
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned when the cloud provider does not implement an optional operation
+var ErrNotSupported = errors.New("operation not supported by cloud provider")
+
 // CloudProvider interface implements the DrainNodeFromLoadBalancer function
 type CloudProvider interface {
-	DrainNodeFromLoadBalancer(nodeName string) error
+	DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error)
+}
+
+// DrainResult summarizes a drain of a node from its load balancers
+type DrainResult struct {
+	NodeID        string               `json:"nodeId"`
+	ClusterName   string               `json:"clusterName"`
+	LoadBalancers []LoadBalancerResult `json:"loadBalancers"`
+	Duration      time.Duration        `json:"duration"`
+	DryRun        bool                 `json:"dryRun"`
+	// Plan is set for dry-runs
+	Plan *Plan `json:"plan,omitempty"`
+}
+
+// LoadBalancerResult is the outcome of draining a node from a single load balancer or target group
+type LoadBalancerResult struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	TimedOut bool   `json:"timedOut"`
+	// Deregistered is true if the node was registered and deregistration was requested,
+	// or in dry-run would have been
+	Deregistered bool `json:"deregistered"`
+}
+
+// TimedOut reports whether the node failed to drain from any load balancer in time
+func (r *DrainResult) TimedOut() bool {
+	for _, lb := range r.LoadBalancers {
+		if lb.TimedOut {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeInfo describes where a node runs, used to scope which callers may act on it
+type NodeInfo struct {
+	ID               string
+	VPCID            string
+	ClusterName      string
+	AutoscalingGroup string
+	Tags             map[string]string
+}
+
+// NodeDescriber is implemented by providers which can look up a node's placement
+type NodeDescriber interface {
+	DescribeNode(ctx context.Context, nodeName string) (*NodeInfo, error)
+}
+
+// Restorer is implemented by providers which can register a previously
+// drained node with its load balancers again
+type Restorer interface {
+	RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error
 }
@@ -0,0 +1,73 @@
+package deregister
+
+import "context"
+
+// Forwarder passes every operation through to the wrapped provider, returning ErrNotSupported
+// for the optional operations it does not implement. Wrappers embed it and override only the
+// operations they decorate
+type Forwarder struct {
+	Provider CloudProvider
+}
+
+// DrainNodeFromLoadBalancer drains the node with the wrapped provider
+func (f Forwarder) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	return f.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+}
+
+// RestoreNodeToLoadBalancer restores the node with the wrapped provider
+func (f Forwarder) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	restorer, ok := f.Provider.(Restorer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return restorer.RestoreNodeToLoadBalancer(ctx, nodeName)
+}
+
+// DescribeNode describes the node with the wrapped provider
+func (f Forwarder) DescribeNode(ctx context.Context, nodeName string) (*NodeInfo, error) {
+	describer, ok := f.Provider.(NodeDescriber)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return describer.DescribeNode(ctx, nodeName)
+}
+
+// PlanDrain plans the drain with the wrapped provider
+func (f Forwarder) PlanDrain(ctx context.Context, nodeName string) (*Plan, error) {
+	planner, ok := f.Provider.(Planner)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return planner.PlanDrain(ctx, nodeName)
+}
+
+// ListRegistrations lists the node's registrations with the wrapped provider
+func (f Forwarder) ListRegistrations(ctx context.Context, nodeName string) (*NodeRegistrations, error) {
+	lister, ok := f.Provider.(RegistrationLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.ListRegistrations(ctx, nodeName)
+}
+
+// ListClusterLoadBalancers reports the cluster's load balancers with the wrapped provider
+func (f Forwarder) ListClusterLoadBalancers(ctx context.Context, clusterName string) (*ClusterLoadBalancers, error) {
+	lister, ok := f.Provider.(ClusterLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.ListClusterLoadBalancers(ctx, clusterName)
+}
+
+// clocked is implemented by providers embedding Base and by the wrappers around them
+type clocked interface {
+	clock() Clock
+}
+
+// clock returns the wrapped provider's clock, so wrappers time drains as the provider does
+func (f Forwarder) clock() Clock {
+	if provider, ok := f.Provider.(clocked); ok {
+		return provider.clock()
+	}
+	return RealClock{}
+}
@@ -0,0 +1,146 @@
+package deregister
+
+import (
+	"context"
+
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithDryRun reports what drains and restores would do without changing any load balancer,
+// when enabled or when the drain options ask for a dry-run. Drains are reported from the
+// provider's plan, so it must be applied closest to the provider
+func WithDryRun(enabled bool) Wrapper {
+	return func(provider CloudProvider) (CloudProvider, error) {
+		return &dryRunProvider{Forwarder: Forwarder{Provider: provider}, Enabled: enabled}, nil
+	}
+}
+
+type dryRunProvider struct {
+	Forwarder
+	Enabled bool
+}
+
+func (p *dryRunProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	if !p.dryRun(ctx) {
+		return p.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+	}
+
+	clock := p.clock()
+	start := clock.Now()
+	result := &DrainResult{DryRun: true}
+	plan, err := p.PlanDrain(ctx, nodeName)
+	result.Duration = clock.Now().Sub(start)
+	if err != nil {
+		return result, err
+	}
+
+	result.NodeID = plan.NodeID
+	result.ClusterName = plan.ClusterName
+	result.Plan = plan
+	for _, lb := range plan.LoadBalancers {
+		result.LoadBalancers = append(result.LoadBalancers, LoadBalancerResult{
+			Name:         lb.Name,
+			Type:         lb.Type,
+			Deregistered: lb.Action == PlanDeregister,
+		})
+	}
+
+	log.Info().
+		Str("nodeID", plan.NodeID).
+		Int("loadBalancers", len(plan.LoadBalancers)).
+		Dur("estimatedDuration", plan.EstimatedDuration).
+		Msg("DRY-RUN (no action taken)---planned drain")
+	return result, nil
+}
+
+func (p *dryRunProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	if !p.dryRun(ctx) {
+		return p.Forwarder.RestoreNodeToLoadBalancer(ctx, nodeName)
+	}
+	if _, ok := p.Provider.(Restorer); !ok {
+		return ErrNotSupported
+	}
+
+	log.Info().
+		Str("nodeName", nodeName).
+		Msg("DRY-RUN (no action taken)---Node would be registered with load balancers")
+	return nil
+}
+
+func (p *dryRunProvider) dryRun(ctx context.Context) bool {
+	return p.Enabled || OptionsFromContext(ctx).DryRun
+}
+
+// WithMetrics records drains in flight and their outcome. A nil recorder leaves the provider unchanged
+func WithMetrics(recorder metrics.Recorder) Wrapper {
+	return func(provider CloudProvider) (CloudProvider, error) {
+		if recorder == nil {
+			return provider, nil
+		}
+		return &metricsProvider{Forwarder: Forwarder{Provider: provider}, Recorder: recorder}, nil
+	}
+}
+
+type metricsProvider struct {
+	Forwarder
+	Recorder metrics.Recorder
+}
+
+func (p *metricsProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	clock := p.clock()
+	start := clock.Now()
+	p.Recorder.DrainStarted()
+	result, err := p.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+
+	cluster := ""
+	if result != nil {
+		cluster = result.ClusterName
+	}
+	p.Recorder.DrainFinished(cluster, outcome(result, err), clock.Now().Sub(start))
+	return result, err
+}
+
+// WithTracing records each drain and plan as a span, parenting the spans of the cloud API calls made
+func WithTracing() Wrapper {
+	return func(provider CloudProvider) (CloudProvider, error) {
+		return &tracingProvider{Forwarder: Forwarder{Provider: provider}}, nil
+	}
+}
+
+type tracingProvider struct {
+	Forwarder
+}
+
+func (p *tracingProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	ctx, span := tracing.Start(ctx, "DrainNodeFromLoadBalancer", trace.WithAttributes(attribute.String("node.name", nodeName)))
+	result, err := p.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+
+	if result != nil {
+		span.SetAttributes(attribute.String("cluster.name", result.ClusterName), attribute.Bool("dryRun", result.DryRun))
+	}
+	span.SetAttributes(attribute.String("outcome", outcome(result, err)))
+	tracing.End(span, err)
+	return result, err
+}
+
+func (p *tracingProvider) PlanDrain(ctx context.Context, nodeName string) (*Plan, error) {
+	ctx, span := tracing.Start(ctx, "PlanDrain", trace.WithAttributes(attribute.String("node.name", nodeName)))
+	plan, err := p.Forwarder.PlanDrain(ctx, nodeName)
+	tracing.End(span, err)
+	return plan, err
+}
+
+// outcome classifies a drain for metrics and traces
+func outcome(result *DrainResult, err error) string {
+	if err != nil {
+		return metrics.OutcomeError
+	}
+	if result != nil && result.TimedOut() {
+		return metrics.OutcomeTimeout
+	}
+	return metrics.OutcomeSuccess
+}
@@ -0,0 +1,155 @@
+package deregister
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakePlanner plans a drain from a single load balancer, counting real drains
+type fakePlanner struct {
+	fakeProvider
+	restores int
+}
+
+func (p *fakePlanner) PlanDrain(ctx context.Context, nodeName string) (*Plan, error) {
+	return &Plan{
+		NodeID:      nodeName,
+		ClusterName: "prod",
+		LoadBalancers: []PlannedLoadBalancer{
+			{Name: "lb-1", Type: "elbv2", Action: PlanDeregister},
+			{Name: "lb-2", Type: "elbv2", Action: PlanNone},
+		},
+	}, nil
+}
+
+func (p *fakePlanner) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	p.restores++
+	return nil
+}
+
+type fakeRecorder struct {
+	inFlight  int
+	outcomes  []string
+	durations []time.Duration
+}
+
+func (r *fakeRecorder) DrainStarted() {
+	r.inFlight++
+}
+
+func (r *fakeRecorder) DrainFinished(cluster string, outcome string, duration time.Duration) {
+	r.inFlight--
+	r.outcomes = append(r.outcomes, outcome)
+	r.durations = append(r.durations, duration)
+}
+
+func (r *fakeRecorder) LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool) {
+}
+
+func (r *fakeRecorder) APICall(operation string, err error) {}
+
+func TestWithDryRunReportsPlan(t *testing.T) {
+	inner := &fakePlanner{}
+	provider, _ := Wrap(inner, WithDryRun(true))
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || !result.DryRun || result.Plan == nil || result.ClusterName != "prod" || inner.drains != 0 {
+		t.Fatalf("failed - expected the plan without draining, got %+v %v after %v drains", result, err, inner.drains)
+	}
+	if len(result.LoadBalancers) != 2 || !result.LoadBalancers[0].Deregistered || result.LoadBalancers[1].Deregistered {
+		t.Fatalf("failed - expected only lb-1 to be deregistered, got %+v", result.LoadBalancers)
+	}
+
+	if err := provider.(Restorer).RestoreNodeToLoadBalancer(context.Background(), "i-1"); err != nil || inner.restores != 0 {
+		t.Fatalf("failed - expected restore to change nothing, got %v after %v restores", err, inner.restores)
+	}
+}
+
+func TestWithDryRunFromOptions(t *testing.T) {
+	inner := &fakePlanner{}
+	provider, _ := Wrap(inner, WithDryRun(false))
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err != nil || inner.drains != 1 {
+		t.Fatalf("failed - expected a real drain, got %v after %v drains", err, inner.drains)
+	}
+
+	ctx := WithOptions(context.Background(), DrainOptions{DryRun: true})
+	result, err := provider.DrainNodeFromLoadBalancer(ctx, "i-1")
+	if err != nil || !result.DryRun || inner.drains != 1 {
+		t.Fatalf("failed - expected the options to ask for a dry-run, got %+v %v", result, err)
+	}
+
+	unplanned, _ := Wrap(&fakeProvider{}, WithDryRun(true))
+	if _, err := unplanned.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err != ErrNotSupported {
+		t.Fatalf("failed - expected dry-run to need a planner, got %v", err)
+	}
+}
+
+func TestWithMetricsRecordsOutcome(t *testing.T) {
+	recorder := &fakeRecorder{}
+	provider, _ := Wrap(&fakeProvider{failures: 1}, WithMetrics(recorder))
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err == nil {
+		t.Fatalf("failed - expected the first drain to fail")
+	}
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if recorder.inFlight != 0 || len(recorder.outcomes) != 2 || recorder.outcomes[0] != "error" || recorder.outcomes[1] != "success" {
+		t.Fatalf("failed - expected error then success with none in flight, got %v %v", recorder.outcomes, recorder.inFlight)
+	}
+
+	unchanged, _ := Wrap(&fakeProvider{}, WithMetrics(nil))
+	if _, ok := unchanged.(*fakeProvider); !ok {
+		t.Fatalf("failed - expected a nil recorder to leave the provider unwrapped")
+	}
+}
+
+// steppingClock moves a second forward each time it is read
+type steppingClock struct {
+	now time.Time
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func (c *steppingClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type clockedPlanner struct {
+	Base
+	fakePlanner
+}
+
+func TestWrappersUseProviderClock(t *testing.T) {
+	recorder := &fakeRecorder{}
+	inner := &clockedPlanner{Base: Base{Clock: &steppingClock{}}}
+	provider, _ := Wrap(inner, WithDryRun(true), WithMetrics(recorder), WithTracing())
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.Duration != time.Second {
+		t.Fatalf("failed - expected the dry-run to be timed on the provider's clock, got %+v %v", result, err)
+	}
+	if len(recorder.durations) != 1 || recorder.durations[0] != 3*time.Second {
+		t.Fatalf("failed - expected the drain metrics to be timed on the provider's clock, got %v", recorder.durations)
+	}
+}
+
+func TestForwarderReportsUnsupported(t *testing.T) {
+	provider, _ := Wrap(&fakeProvider{failures: 1}, WithTracing())
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err == nil || errors.Is(err, ErrNotSupported) {
+		t.Fatalf("failed - expected the provider's error to be forwarded, got %v", err)
+	}
+	if _, err := provider.(Planner).PlanDrain(context.Background(), "i-1"); err != ErrNotSupported {
+		t.Fatalf("failed - expected planning to be unsupported, got %v", err)
+	}
+	if _, err := provider.(ClusterLister).ListClusterLoadBalancers(context.Background(), "prod"); err != ErrNotSupported {
+		t.Fatalf("failed - expected cluster listing to be unsupported, got %v", err)
+	}
+}
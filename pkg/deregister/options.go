@@ -0,0 +1,28 @@
+package deregister
+
+import (
+	"context"
+	"time"
+)
+
+// DrainOptions override the provider's configured behavior for a single drain
+type DrainOptions struct {
+	// DryRun reports what would be drained without changing any load balancer.
+	// It can enable, but not disable, a provider configured for dry-run
+	DryRun bool
+	// Timeout replaces the provider's timeout if positive
+	Timeout time.Duration
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a context carrying drain options for the provider
+func WithOptions(ctx context.Context, options DrainOptions) context.Context {
+	return context.WithValue(ctx, optionsKey{}, options)
+}
+
+// OptionsFromContext returns the drain options set with WithOptions
+func OptionsFromContext(ctx context.Context) DrainOptions {
+	options, _ := ctx.Value(optionsKey{}).(DrainOptions)
+	return options
+}
@@ -0,0 +1,92 @@
+package deregister
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Planned actions for a load balancer
+const (
+	// PlanDeregister means the node is registered and would be deregistered
+	PlanDeregister = "deregister"
+	// PlanWait means the node is already draining and the drain would wait for it
+	PlanWait = "wait"
+	// PlanNone means the node is not registered, or not in a state which needs draining
+	PlanNone = "none"
+)
+
+// Planner is implemented by providers which can report what a drain would do without
+// changing any load balancer
+type Planner interface {
+	PlanDrain(ctx context.Context, nodeName string) (*Plan, error)
+}
+
+// Plan describes what draining a node would do
+type Plan struct {
+	NodeID        string                `json:"nodeId"`
+	ClusterName   string                `json:"clusterName"`
+	VPCID         string                `json:"vpcId"`
+	Timeout       time.Duration         `json:"timeout"`
+	LoadBalancers []PlannedLoadBalancer `json:"loadBalancers"`
+	// EstimatedDuration is the longest deregistration delay of the load balancers the node
+	// would be drained from, capped at the timeout
+	EstimatedDuration time.Duration   `json:"estimatedDuration"`
+	Timeline          []TimelineEvent `json:"timeline"`
+}
+
+// PlannedLoadBalancer is the current state of the node at a load balancer or target group
+// and what a drain would do there
+type PlannedLoadBalancer struct {
+	Name                string        `json:"name"`
+	Type                string        `json:"type"`
+	State               string        `json:"state"`
+	Reason              string        `json:"reason,omitempty"`
+	Action              string        `json:"action"`
+	DeregistrationDelay time.Duration `json:"deregistrationDelay"`
+	// WouldTimeOut is true if the deregistration delay is longer than the drain timeout
+	WouldTimeOut bool `json:"wouldTimeOut"`
+}
+
+// TimelineEvent is a simulated point in a drain, relative to its start
+type TimelineEvent struct {
+	Offset       time.Duration `json:"offset"`
+	LoadBalancer string        `json:"loadBalancer"`
+	Event        string        `json:"event"`
+}
+
+// Simulate estimates the drain duration and builds its timeline, assuming each load balancer
+// takes its full deregistration delay to drain
+func (p *Plan) Simulate() {
+	p.EstimatedDuration = 0
+	p.Timeline = []TimelineEvent{}
+	for i := range p.LoadBalancers {
+		lb := &p.LoadBalancers[i]
+		if lb.Action == PlanNone {
+			continue
+		}
+
+		lb.WouldTimeOut = p.Timeout > 0 && lb.DeregistrationDelay > p.Timeout
+		start := "deregistration requested"
+		if lb.Action == PlanWait {
+			start = "already draining"
+		}
+		p.Timeline = append(p.Timeline, TimelineEvent{LoadBalancer: lb.Name, Event: start})
+
+		drained := lb.DeregistrationDelay
+		if lb.WouldTimeOut {
+			p.Timeline = append(p.Timeline, TimelineEvent{Offset: p.Timeout, LoadBalancer: lb.Name, Event: "timed out"})
+			drained = p.Timeout
+		} else {
+			p.Timeline = append(p.Timeline, TimelineEvent{Offset: drained, LoadBalancer: lb.Name, Event: "drained"})
+		}
+
+		if drained > p.EstimatedDuration {
+			p.EstimatedDuration = drained
+		}
+	}
+
+	sort.SliceStable(p.Timeline, func(i, j int) bool {
+		return p.Timeline[i].Offset < p.Timeline[j].Offset
+	})
+}
@@ -0,0 +1,37 @@
+package deregister
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	plan := &Plan{
+		Timeout: time.Minute,
+		LoadBalancers: []PlannedLoadBalancer{
+			{Name: "slow", Action: PlanDeregister, DeregistrationDelay: 45 * time.Second},
+			{Name: "idle", Action: PlanNone, DeregistrationDelay: 10 * time.Minute},
+			{Name: "fast", Action: PlanWait, DeregistrationDelay: 5 * time.Second},
+		},
+	}
+	plan.Simulate()
+
+	if plan.EstimatedDuration != 45*time.Second {
+		t.Fatalf("failed - expected a 45s estimate, got %v", plan.EstimatedDuration)
+	}
+
+	expected := []TimelineEvent{
+		{Offset: 0, LoadBalancer: "slow", Event: "deregistration requested"},
+		{Offset: 0, LoadBalancer: "fast", Event: "already draining"},
+		{Offset: 5 * time.Second, LoadBalancer: "fast", Event: "drained"},
+		{Offset: 45 * time.Second, LoadBalancer: "slow", Event: "drained"},
+	}
+	if len(plan.Timeline) != len(expected) {
+		t.Fatalf("failed - expected timeline %v, got %v", expected, plan.Timeline)
+	}
+	for i, event := range expected {
+		if plan.Timeline[i] != event {
+			t.Fatalf("failed - expected timeline %v, got %v", expected, plan.Timeline)
+		}
+	}
+}
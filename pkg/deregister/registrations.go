@@ -0,0 +1,30 @@
+package deregister
+
+import "context"
+
+// RegistrationLister is implemented by providers which can list the load balancers a node is
+// registered with, without changing any of them
+type RegistrationLister interface {
+	ListRegistrations(ctx context.Context, nodeName string) (*NodeRegistrations, error)
+}
+
+// NodeRegistrations are the load balancers and target groups in the node's cluster which
+// the node is registered with
+type NodeRegistrations struct {
+	NodeID        string         `json:"nodeId"`
+	ClusterName   string         `json:"clusterName"`
+	Registrations []Registration `json:"registrations"`
+}
+
+// Registration is the node's health at a single load balancer or target group
+type Registration struct {
+	LoadBalancer string `json:"loadBalancer"`
+	// TargetGroup is set for load balancers which route to the node through target groups
+	TargetGroup string `json:"targetGroup,omitempty"`
+	Type        string `json:"type"`
+	// Port is the port traffic is sent to, where the load balancer registers one
+	Port        int64  `json:"port,omitempty"`
+	State       string `json:"state"`
+	Reason      string `json:"reason,omitempty"`
+	Description string `json:"description,omitempty"`
+}
@@ -0,0 +1,89 @@
+package deregister
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// ProviderConfig is the configuration every provider receives from the registry
+type ProviderConfig struct {
+	Name         string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	TagSelectors map[string]string
+	Metrics      metrics.Recorder
+
+	// Settings are the provider's own settings, read into a typed struct with Decode
+	Settings map[string]interface{}
+}
+
+// Decode reads the provider's settings into out, rejecting unknown settings
+func (c ProviderConfig) Decode(out interface{}) error {
+	raw, err := yaml.Marshal(c.Settings)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(raw, out); err != nil {
+		return fmt.Errorf("invalid %s provider settings: %v", c.Name, err)
+	}
+	return nil
+}
+
+// Factory builds a provider from its configuration
+type Factory func(cfg ProviderConfig) (CloudProvider, error)
+
+var (
+	registryMu sync.RWMutex
+	factories  = map[string]Factory{}
+)
+
+// Register makes a provider available by name, usually from the provider package's init.
+// It panics if the name is already registered
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic("deregister: provider registered twice: " + name)
+	}
+	factories[name] = factory
+}
+
+// unregister removes a provider, letting tests register theirs again
+func unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(factories, name)
+}
+
+// Registered returns the names of the registered providers in order
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the provider registered as cfg.Name
+func New(cfg ProviderConfig) (CloudProvider, error) {
+	registryMu.RLock()
+	factory, ok := factories[cfg.Name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unrecognized cloud provider %q, expected one of %s", cfg.Name, strings.Join(Registered(), ", "))
+	}
+	return factory(cfg)
+}
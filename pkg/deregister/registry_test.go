@@ -0,0 +1,121 @@
+package deregister
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	failures int
+	drains   int
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	p.drains++
+	if p.drains <= p.failures {
+		return &DrainResult{}, errors.New("throttled")
+	}
+	return &DrainResult{NodeID: nodeName}, nil
+}
+
+// fakeClock records waits, returning at once unless blocked
+type fakeClock struct {
+	waits   []time.Duration
+	blocked bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Time{}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	if !c.blocked {
+		ch <- time.Time{}
+	}
+	return ch
+}
+
+func TestNewUsesRegisteredFactory(t *testing.T) {
+	type settings struct {
+		Project string `yaml:"project"`
+	}
+
+	var decoded settings
+	Register("test-registry", func(cfg ProviderConfig) (CloudProvider, error) {
+		if err := cfg.Decode(&decoded); err != nil {
+			return nil, err
+		}
+		return &fakeProvider{}, nil
+	})
+	t.Cleanup(func() { unregister("test-registry") })
+
+	provider, err := New(ProviderConfig{Name: "test-registry", Settings: map[string]interface{}{"project": "prod"}})
+	if err != nil || provider == nil || decoded.Project != "prod" {
+		t.Fatalf("failed - expected the provider with decoded settings, got %v %+v", err, decoded)
+	}
+
+	if _, err := New(ProviderConfig{Name: "test-registry", Settings: map[string]interface{}{"projcet": "prod"}}); err == nil {
+		t.Fatalf("failed - expected unknown settings to be rejected")
+	}
+
+	_, err = New(ProviderConfig{Name: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "test-registry") {
+		t.Fatalf("failed - expected an error listing registered providers, got %v", err)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	inner := &fakeProvider{failures: 2}
+	provider, err := Wrap(inner, WithRetries(3, 0))
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	result, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1")
+	if err != nil || result.NodeID != "i-1" || inner.drains != 3 {
+		t.Fatalf("failed - expected the third attempt to succeed, got %v after %v drains", err, inner.drains)
+	}
+	if err := provider.(Restorer).RestoreNodeToLoadBalancer(context.Background(), "i-1"); err != ErrNotSupported {
+		t.Fatalf("failed - expected restore to be unsupported, got %v", err)
+	}
+
+	unchanged, _ := Wrap(inner, WithRetries(1, 0))
+	if unchanged != CloudProvider(inner) {
+		t.Fatalf("failed - expected a single attempt to leave the provider unwrapped")
+	}
+}
+
+func TestWithRetriesBacksOff(t *testing.T) {
+	inner := &fakeProvider{failures: 5}
+	clock := &fakeClock{}
+	provider := &retryProvider{Forwarder: Forwarder{Provider: inner}, Attempts: 4, Backoff: time.Second, Clock: clock}
+
+	if _, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-1"); err == nil {
+		t.Fatalf("failed - expected the last error once attempts ran out")
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if inner.drains != 4 || !reflect.DeepEqual(clock.waits, expected) {
+		t.Fatalf("failed - expected 4 drains with doubling backoff, got %v drains waiting %v", inner.drains, clock.waits)
+	}
+}
+
+func TestWithRetriesStopsWhenCancelled(t *testing.T) {
+	inner := &fakeProvider{failures: 5}
+	clock := &fakeClock{blocked: true}
+	provider := &retryProvider{Forwarder: Forwarder{Provider: inner}, Attempts: 4, Backoff: time.Hour, Clock: clock}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.DrainNodeFromLoadBalancer(ctx, "i-1"); err == nil {
+		t.Fatalf("failed - expected the error from the only attempt")
+	}
+	if inner.drains != 1 || len(clock.waits) != 1 {
+		t.Fatalf("failed - expected cancellation to end the backoff, got %v drains", inner.drains)
+	}
+}
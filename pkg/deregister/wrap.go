@@ -0,0 +1,89 @@
+package deregister
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Wrapper decorates a provider, for example to record or notify drains. A wrapper
+// which is not enabled returns the provider unchanged
+type Wrapper func(provider CloudProvider) (CloudProvider, error)
+
+// Wrap applies the wrappers in order, so the first wrapper is closest to the provider
+func Wrap(provider CloudProvider, wrappers ...Wrapper) (CloudProvider, error) {
+	for _, wrapper := range wrappers {
+		wrapped, err := wrapper(provider)
+		if err != nil {
+			return nil, err
+		}
+		provider = wrapped
+	}
+	return provider, nil
+}
+
+// WithRetries retries failed drains and restores up to attempts times in total, doubling
+// the backoff between attempts. Fewer than two attempts leaves the provider unchanged
+func WithRetries(attempts int, backoff time.Duration) Wrapper {
+	return func(provider CloudProvider) (CloudProvider, error) {
+		if attempts < 2 {
+			return provider, nil
+		}
+		return &retryProvider{Forwarder: Forwarder{Provider: provider}, Attempts: attempts, Backoff: backoff}, nil
+	}
+}
+
+type retryProvider struct {
+	Forwarder
+	Attempts int
+	Backoff  time.Duration
+	// Clock waits out the backoff, defaulting to the system clock
+	Clock Clock
+}
+
+func (p *retryProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*DrainResult, error) {
+	var result *DrainResult
+	err := p.retry(ctx, nodeName, func() error {
+		var err error
+		result, err = p.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+		return err
+	})
+	return result, err
+}
+
+func (p *retryProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	return p.retry(ctx, nodeName, func() error {
+		return p.Forwarder.RestoreNodeToLoadBalancer(ctx, nodeName)
+	})
+}
+
+func (p *retryProvider) retry(ctx context.Context, nodeName string, operation func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || err == ErrNotSupported || attempt >= p.Attempts {
+			return err
+		}
+
+		log.Warn().
+			Err(err).
+			Str("nodeName", nodeName).
+			Int("attempt", attempt).
+			Dur("backoff", backoff).
+			Msg("operation failed, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-p.clock().After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (p *retryProvider) clock() Clock {
+	if p.Clock == nil {
+		return RealClock{}
+	}
+	return p.Clock
+}
@@ -0,0 +1,118 @@
+package imds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpoint is the address of the EC2 instance metadata service
+const DefaultEndpoint = "http://169.254.169.254"
+
+const tokenTTL = 6 * time.Hour
+
+// Client is a minimal EC2 instance metadata service client using IMDSv2 session tokens
+type Client struct {
+	Endpoint string
+	HTTP     *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient creates a metadata client against the given endpoint
+func NewClient(endpoint string) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+
+	return &Client{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		HTTP:     &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// GetMetadata gets a metadata path, returning found false when the path does not exist
+func (c *Client) GetMetadata(path string) (value string, found bool, err error) {
+	token, err := c.getToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	req, err := http.NewRequest("GET", c.Endpoint+path, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.resetToken()
+		return "", false, fmt.Errorf("metadata token rejected for %s", path)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, path)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(body), true, nil
+}
+
+func (c *Client) getToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequest("PUT", c.Endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(int(tokenTTL.Seconds())))
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d fetching metadata token", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// refresh well before the token actually expires
+	c.token = string(body)
+	c.tokenExpiry = time.Now().Add(tokenTTL - time.Minute)
+	return c.token, nil
+}
+
+func (c *Client) resetToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
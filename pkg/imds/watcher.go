@@ -0,0 +1,202 @@
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	instanceIDPath      = "/latest/meta-data/instance-id"
+	spotActionPath      = "/latest/meta-data/spot/instance-action"
+	scheduledEventsPath = "/latest/meta-data/events/maintenance/scheduled"
+	lifecycleStatePath  = "/latest/meta-data/autoscaling/target-lifecycle-state"
+
+	// scheduledEventTimeFormat is the format of NotBefore, such as "21 Jan 2019 09:00:43 GMT"
+	scheduledEventTimeFormat = "2 Jan 2006 15:04:05 MST"
+)
+
+// DefaultScheduledEventLeadTime is how long before a scheduled event starts the instance is drained
+const DefaultScheduledEventLeadTime = 10 * time.Minute
+
+// scheduledEventCodes are the maintenance events which take the instance out of service
+var scheduledEventCodes = []string{"instance-stop", "instance-retirement", "instance-reboot", "system-reboot"}
+
+type spotAction struct {
+	Action string `json:"action"`
+	Time   string `json:"time"`
+}
+
+type scheduledEvent struct {
+	Code      string `json:"Code"`
+	State     string `json:"State"`
+	NotBefore string `json:"NotBefore"`
+}
+
+// MetadataGetter gets values from the instance metadata service
+type MetadataGetter interface {
+	GetMetadata(path string) (value string, found bool, err error)
+}
+
+// Watcher polls the instance metadata service of the instance it runs on and
+// drains that instance once a termination is signalled
+type Watcher struct {
+	Metadata     MetadataGetter
+	Provider     deregister.CloudProvider
+	PollInterval time.Duration
+	// ScheduledEventLeadTime is how long before a scheduled maintenance event starts the
+	// instance is drained, DefaultScheduledEventLeadTime if zero
+	ScheduledEventLeadTime time.Duration
+	Clock                  deregister.Clock
+}
+
+// Run polls the metadata service until the instance is drained or the context is cancelled
+func (w *Watcher) Run(ctx context.Context) error {
+	instanceID, found, err := w.Metadata.GetMetadata(instanceIDPath)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("instance id not found in metadata")
+	}
+
+	log.Info().Str("instanceId", instanceID).Msg("watching instance metadata for termination")
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		reason, err := w.terminationReason()
+		if err != nil {
+			log.Warn().Err(err).Msg("error polling instance metadata")
+		}
+
+		if reason != "" {
+			log.Info().
+				Str("instanceId", instanceID).
+				Str("reason", reason).
+				Msg("termination signalled, draining instance")
+			result, err := w.Provider.DrainNodeFromLoadBalancer(ctx, instanceID)
+			switch {
+			case err != nil:
+				log.Error().Err(err).Str("instanceId", instanceID).Msg("error draining instance, retrying")
+			case result.TimedOut():
+				log.Warn().Str("instanceId", instanceID).Msg("node still draining at timeout, retrying")
+			default:
+				log.Info().Str("instanceId", instanceID).Msg("successfully drained instance")
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("stopping metadata watcher")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// terminationReason checks every metadata source, returning a non-empty reason if the
+// instance is about to be taken out of service. A source which cannot be read does not
+// stop the others being checked
+func (w *Watcher) terminationReason() (string, error) {
+	var problems []string
+	for _, source := range []func() (string, error){w.spotReason, w.scheduledReason, w.lifecycleReason} {
+		reason, err := source()
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if reason != "" {
+			return reason, nil
+		}
+	}
+
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return "", nil
+}
+
+func (w *Watcher) spotReason() (string, error) {
+	value, found, err := w.Metadata.GetMetadata(spotActionPath)
+	if err != nil || !found {
+		return "", err
+	}
+
+	var action spotAction
+	if err := json.Unmarshal([]byte(value), &action); err != nil {
+		return "", fmt.Errorf("decoding spot instance action: %v", err)
+	}
+	if action.Action == "terminate" || action.Action == "stop" {
+		return "spot " + action.Action, nil
+	}
+	return "", nil
+}
+
+// scheduledReason returns a reason once an active maintenance event is due to start within
+// the lead time, so events scheduled days ahead do not drain the instance straight away
+func (w *Watcher) scheduledReason() (string, error) {
+	value, found, err := w.Metadata.GetMetadata(scheduledEventsPath)
+	if err != nil || !found || strings.TrimSpace(value) == "" {
+		return "", err
+	}
+
+	var events []scheduledEvent
+	if err := json.Unmarshal([]byte(value), &events); err != nil {
+		return "", fmt.Errorf("decoding scheduled events: %v", err)
+	}
+	for _, event := range events {
+		if event.State == "Completed" || event.State == "Canceled" || !contains(scheduledEventCodes, event.Code) {
+			continue
+		}
+
+		notBefore, err := time.Parse(scheduledEventTimeFormat, event.NotBefore)
+		if err != nil {
+			return "", fmt.Errorf("parsing NotBefore of scheduled event %s: %v", event.Code, err)
+		}
+		if notBefore.Sub(w.clock().Now()) <= w.leadTime() {
+			return "scheduled " + event.Code, nil
+		}
+	}
+	return "", nil
+}
+
+func (w *Watcher) lifecycleReason() (string, error) {
+	value, found, err := w.Metadata.GetMetadata(lifecycleStatePath)
+	if err != nil || !found {
+		return "", err
+	}
+	if strings.HasSuffix(value, "Terminated") {
+		return "autoscaling " + value, nil
+	}
+	return "", nil
+}
+
+func (w *Watcher) leadTime() time.Duration {
+	if w.ScheduledEventLeadTime <= 0 {
+		return DefaultScheduledEventLeadTime
+	}
+	return w.ScheduledEventLeadTime
+}
+
+func (w *Watcher) clock() deregister.Clock {
+	if w.Clock == nil {
+		return deregister.RealClock{}
+	}
+	return w.Clock
+}
+
+func contains(lst []string, s string) bool {
+	for _, a := range lst {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package imds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeIMDS is a local stand-in for the instance metadata service which requires IMDSv2 tokens
+type fakeIMDS struct {
+	mu     sync.Mutex
+	paths  map[string]string
+	tokens int
+}
+
+func (f *fakeIMDS) set(path string, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths[path] = value
+}
+
+func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.tokens++
+		w.Write([]byte("test-token"))
+		return
+	}
+
+	if r.Header.Get("X-aws-ec2-metadata-token") != "test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	value, ok := f.paths[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(value))
+}
+
+type fakeProvider struct {
+	mu       sync.Mutex
+	drained  []string
+	timeouts int
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drained = append(p.drained, nodeName)
+	timedOut := len(p.drained) <= p.timeouts
+	return &deregister.DrainResult{
+		NodeID:        nodeName,
+		LoadBalancers: []deregister.LoadBalancerResult{{Name: "lb-1", Deregistered: true, TimedOut: timedOut}},
+	}, nil
+}
+
+func newFakeIMDS() (*fakeIMDS, *httptest.Server) {
+	fake := &fakeIMDS{paths: map[string]string{
+		instanceIDPath:     "i-0123456789",
+		lifecycleStatePath: "InService",
+	}}
+	return fake, httptest.NewServer(fake)
+}
+
+func TestClientReusesToken(t *testing.T) {
+	fake, server := newFakeIMDS()
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	for i := 0; i < 3; i++ {
+		value, found, err := client.GetMetadata(instanceIDPath)
+		if err != nil || !found || value != "i-0123456789" {
+			t.Fatalf("failed - unexpected result %v %v %v", value, found, err)
+		}
+	}
+
+	_, found, err := client.GetMetadata(spotActionPath)
+	if err != nil || found {
+		t.Fatalf("failed - expected missing path to be not found, got %v %v", found, err)
+	}
+
+	if fake.tokens != 1 {
+		t.Fatalf("failed - expected a single token request, got %v", fake.tokens)
+	}
+}
+
+func TestTerminationReason(t *testing.T) {
+	soon := time.Now().Add(5 * time.Minute).UTC().Format(scheduledEventTimeFormat)
+	later := time.Now().Add(72 * time.Hour).UTC().Format(scheduledEventTimeFormat)
+	cases := []struct {
+		Path     string
+		Value    string
+		Expected string
+	}{
+		{Path: spotActionPath, Value: `{"action": "terminate", "time": "2020-01-01T01:00:00Z"}`, Expected: "spot terminate"},
+		{Path: scheduledEventsPath, Value: `[{"Code": "instance-retirement", "State": "active", "NotBefore": "` + soon + `"}]`, Expected: "scheduled instance-retirement"},
+		{Path: scheduledEventsPath, Value: `[{"Code": "instance-retirement", "State": "active", "NotBefore": "` + later + `"}]`, Expected: ""},
+		{Path: scheduledEventsPath, Value: `[{"Code": "instance-stop", "State": "Completed", "NotBefore": "` + soon + `"}]`, Expected: ""},
+		{Path: lifecycleStatePath, Value: "Terminated", Expected: "autoscaling Terminated"},
+		{Path: lifecycleStatePath, Value: "InService", Expected: ""},
+	}
+
+	for i, c := range cases {
+		fake, server := newFakeIMDS()
+		fake.set(c.Path, c.Value)
+		w := &Watcher{Metadata: NewClient(server.URL)}
+		reason, err := w.terminationReason()
+		server.Close()
+		if err != nil {
+			t.Fatalf("%d failed - unexpected error %v", i, err)
+		}
+		if reason != c.Expected {
+			t.Fatalf("%d failed - expected reason %q, got %q", i, c.Expected, reason)
+		}
+	}
+}
+
+func TestTerminationReasonChecksEverySource(t *testing.T) {
+	fake, server := newFakeIMDS()
+	defer server.Close()
+	fake.set(spotActionPath, "not json")
+	fake.set(lifecycleStatePath, "Terminated")
+
+	w := &Watcher{Metadata: NewClient(server.URL)}
+	reason, err := w.terminationReason()
+	if err != nil || reason != "autoscaling Terminated" {
+		t.Fatalf("failed - expected the lifecycle state despite the spot error, got %q %v", reason, err)
+	}
+
+	fake.set(lifecycleStatePath, "InService")
+	fake.set(scheduledEventsPath, `[{"Code": "instance-stop", "State": "active", "NotBefore": "soon"}]`)
+	_, err = w.terminationReason()
+	if err == nil || !strings.Contains(err.Error(), "spot instance action") || !strings.Contains(err.Error(), "NotBefore") {
+		t.Fatalf("failed - expected both errors to be returned, got %v", err)
+	}
+}
+
+func TestWatcherDrainsOnSpotInterruption(t *testing.T) {
+	fake, server := newFakeIMDS()
+	defer server.Close()
+
+	provider := &fakeProvider{}
+	w := &Watcher{
+		Metadata:     NewClient(server.URL),
+		Provider:     provider,
+		PollInterval: 5 * time.Millisecond,
+	}
+
+	finished := make(chan error)
+	go func() {
+		finished <- w.Run(context.Background())
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	provider.mu.Lock()
+	if len(provider.drained) != 0 {
+		t.Fatalf("failed - expected no drain before interruption, got %v", provider.drained)
+	}
+	provider.mu.Unlock()
+
+	fake.set(spotActionPath, `{"action": "terminate", "time": "2020-01-01T01:00:00Z"}`)
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatalf("failed - unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed - watcher did not drain the instance")
+	}
+
+	if len(provider.drained) != 1 || provider.drained[0] != "i-0123456789" {
+		t.Fatalf("failed - expected i-0123456789 to be drained once, got %v", provider.drained)
+	}
+}
+
+func TestWatcherRetriesTimedOutDrain(t *testing.T) {
+	fake, server := newFakeIMDS()
+	defer server.Close()
+	fake.set(spotActionPath, `{"action": "terminate", "time": "2020-01-01T01:00:00Z"}`)
+
+	provider := &fakeProvider{timeouts: 1}
+	w := &Watcher{
+		Metadata:     NewClient(server.URL),
+		Provider:     provider,
+		PollInterval: 5 * time.Millisecond,
+	}
+
+	finished := make(chan error)
+	go func() {
+		finished <- w.Run(context.Background())
+	}()
+
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatalf("failed - unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed - watcher did not finish draining the instance")
+	}
+
+	if len(provider.drained) != 2 {
+		t.Fatalf("failed - expected the timed out drain to be retried once, got %v", provider.drained)
+	}
+}
+
+func TestWatcherStopsOnCancel(t *testing.T) {
+	_, server := newFakeIMDS()
+	defer server.Close()
+
+	w := &Watcher{
+		Metadata:     NewClient(server.URL),
+		Provider:     &fakeProvider{},
+		PollInterval: 5 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- w.Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatalf("failed - watcher did not stop after cancel")
+	}
+}
@@ -0,0 +1,178 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// tokenFileTTL is how long a token read from a file is used before the file is read again,
+// well within the hour after which kubelet rotates projected service account tokens
+const tokenFileTTL = time.Minute
+
+// Client is a minimal JSON client for the Kubernetes API server
+type Client struct {
+	Host        string
+	BearerToken string
+	HTTP        *http.Client
+
+	// tokenSource supplies short lived tokens, e.g. from a kubeconfig exec plugin or a
+	// rotated service account token file
+	tokenSource func() (string, error)
+}
+
+// StatusError is returned when the API server responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubernetes api returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether the error is a 404 from the API server
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether the request failed because the object changed since it was read
+func IsConflict(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict
+}
+
+// NewInClusterClient creates a client using the pod service account
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster")
+	}
+
+	tokens := &fileTokenSource{path: serviceAccountDir + "/token", ttl: tokenFileTTL}
+	if _, err := tokens.Token(); err != nil {
+		return nil, err
+	}
+
+	caData, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, errors.New("unable to parse service account CA bundle")
+	}
+
+	return &Client{
+		Host: "https://" + net.JoinHostPort(host, port),
+		HTTP: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		tokenSource: tokens.Token,
+	}, nil
+}
+
+// fileTokenSource reads a token from a file which is rotated in place, caching it for ttl
+type fileTokenSource struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	readAt time.Time
+}
+
+func (s *fileTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	if s.token != "" && now.Sub(s.readAt) < s.ttl {
+		return s.token, nil
+	}
+
+	token, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	s.token = strings.TrimSpace(string(token))
+	s.readAt = now
+	return s.token, nil
+}
+
+// Do sends a request with an optional JSON body, decoding a JSON response into out if not nil
+func (c *Client) Do(method string, path string, contentType string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.Host+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	token := c.BearerToken
+	if token == "" && c.tokenSource != nil {
+		token, err = c.tokenSource()
+		if err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,41 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTokenSourceRereadsRotatedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("token-1\n"), 0600); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	source := &fileTokenSource{path: path, ttl: time.Minute, now: func() time.Time { return now }}
+	if token, err := source.Token(); err != nil || token != "token-1" {
+		t.Fatalf("failed - expected token-1, got %q %v", token, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("token-2\n"), 0600); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if token, _ := source.Token(); token != "token-1" {
+		t.Fatalf("failed - expected the cached token within the ttl, got %q", token)
+	}
+
+	now = now.Add(time.Minute)
+	if token, err := source.Token(); err != nil || token != "token-2" {
+		t.Fatalf("failed - expected the rotated token, got %q %v", token, err)
+	}
+}
@@ -0,0 +1,208 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DrainedLoadBalancersAnnotation lists the load balancers a node was drained from
+	DrainedLoadBalancersAnnotation = "hasta-la-vista.io/drained-load-balancers"
+	// DrainedAtAnnotation records when the node was drained
+	DrainedAtAnnotation = "hasta-la-vista.io/drained-at"
+
+	eventNamespace = "default"
+	eventComponent = "hasta-la-vista"
+)
+
+// Event reasons recorded on the Node
+const (
+	ReasonDrainStarted  = "DrainStarted"
+	ReasonDrainedFromLB = "DrainedFromLB"
+	ReasonDrainTimedOut = "DrainTimedOut"
+	ReasonDrainFailed   = "DrainFailed"
+	ReasonRestored      = "RestoredToLB"
+)
+
+type objectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	GenerateName    string            `json:"generateName,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+type node struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ProviderID    string  `json:"providerID"`
+		Unschedulable bool    `json:"unschedulable,omitempty"`
+		Taints        []Taint `json:"taints,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+type nodeList struct {
+	Items []node `json:"items"`
+}
+
+type objectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type event struct {
+	APIVersion     string          `json:"apiVersion"`
+	Kind           string          `json:"kind"`
+	Metadata       objectMeta      `json:"metadata"`
+	InvolvedObject objectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Source         struct {
+		Component string `json:"component"`
+	} `json:"source"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	Count          int       `json:"count"`
+}
+
+// EventRecorder wraps a cloud provider, recording drain progress as Events on the
+// Kubernetes Node and annotating it with the load balancers it was drained from.
+// Failures talking to the API server are logged and never fail the drain.
+type EventRecorder struct {
+	deregister.Forwarder
+	Client *Client
+}
+
+// DrainNodeFromLoadBalancer drains the node with the wrapped provider, recording events as it goes
+func (r *EventRecorder) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	if deregister.OptionsFromContext(ctx).DryRun {
+		return r.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+	}
+
+	n := r.findNode(nodeName)
+	if n != nil {
+		r.recordEvent(n, "Normal", ReasonDrainStarted, "Draining node from cloud load balancers")
+	}
+
+	result, err := r.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+	if result != nil && result.DryRun {
+		return result, err
+	}
+	if n == nil && result != nil && result.NodeID != "" && result.NodeID != nodeName {
+		n = r.findNode(result.NodeID)
+	}
+	if n == nil {
+		log.Warn().Str("nodeName", nodeName).Msg("unable to find kubernetes node, not recording events")
+		return result, err
+	}
+
+	var drained []string
+	if result != nil {
+		for _, lb := range result.LoadBalancers {
+			// the node was never registered with this load balancer
+			if !lb.Deregistered {
+				continue
+			}
+			if lb.TimedOut {
+				r.recordEvent(n, "Warning", ReasonDrainTimedOut, fmt.Sprintf("Node did not drain from %s %s within timeout", lb.Type, lb.Name))
+				continue
+			}
+			drained = append(drained, lb.Name)
+			r.recordEvent(n, "Normal", ReasonDrainedFromLB, fmt.Sprintf("Node drained from %s %s", lb.Type, lb.Name))
+		}
+	}
+
+	if err != nil {
+		r.recordEvent(n, "Warning", ReasonDrainFailed, fmt.Sprintf("Error draining node from cloud load balancers: %v", err))
+	}
+
+	if len(drained) > 0 {
+		r.annotate(n, map[string]interface{}{
+			DrainedLoadBalancersAnnotation: strings.Join(drained, ","),
+			DrainedAtAnnotation:            time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	return result, err
+}
+
+// RestoreNodeToLoadBalancer restores the node with the wrapped provider and clears the drain annotations
+func (r *EventRecorder) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	err := r.Forwarder.RestoreNodeToLoadBalancer(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if n := r.findNode(nodeName); n != nil {
+		r.recordEvent(n, "Normal", ReasonRestored, "Node registered with cloud load balancers")
+		r.annotate(n, map[string]interface{}{
+			DrainedLoadBalancersAnnotation: nil,
+			DrainedAtAnnotation:            nil,
+		})
+	}
+	return nil
+}
+
+// findNode looks a node up, logging failures
+func (r *EventRecorder) findNode(nodeName string) *node {
+	n, err := r.Client.lookupNode(nodeName)
+	if err != nil {
+		log.Warn().Err(err).Str("nodeName", nodeName).Msg("error getting kubernetes node")
+		return nil
+	}
+	return n
+}
+
+func (r *EventRecorder) recordEvent(n *node, eventType string, reason string, message string) {
+	now := time.Now().UTC()
+	e := event{
+		APIVersion: "v1",
+		Kind:       "Event",
+		Metadata:   objectMeta{GenerateName: n.Metadata.Name + ".", Namespace: eventNamespace},
+		InvolvedObject: objectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       n.Metadata.Name,
+			UID:        n.Metadata.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	e.Source.Component = eventComponent
+
+	err := r.Client.Do("POST", "/api/v1/namespaces/"+eventNamespace+"/events", "", e, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("node", n.Metadata.Name).Str("reason", reason).Msg("error recording kubernetes event")
+	}
+}
+
+// annotate merge-patches the node annotations, nil values remove an annotation
+func (r *EventRecorder) annotate(n *node, annotations map[string]interface{}) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	}
+	err := r.Client.Do("PATCH", "/api/v1/nodes/"+url.PathEscape(n.Metadata.Name), "application/merge-patch+json", patch, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("node", n.Metadata.Name).Msg("error annotating kubernetes node")
+	}
+}
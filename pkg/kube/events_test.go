@@ -0,0 +1,174 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeAPIServer serves a single node and records events and patches sent to it. Patches for
+// another resource version of the node are rejected with a conflict
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	node    node
+	events  []event
+	patches []map[string]interface{}
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v1/nodes":
+		json.NewEncoder(w).Encode(nodeList{Items: []node{f.node}})
+	case r.Method == "GET" && r.URL.Path == "/api/v1/nodes/"+f.node.Metadata.Name:
+		json.NewEncoder(w).Encode(f.node)
+	case r.Method == "PATCH" && r.URL.Path == "/api/v1/nodes/"+f.node.Metadata.Name:
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var patch map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&patch)
+		f.patches = append(f.patches, patch)
+		metadata, _ := patch["metadata"].(map[string]interface{})
+		if version, ok := metadata["resourceVersion"]; ok && version != f.node.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		version, _ := strconv.Atoi(f.node.Metadata.ResourceVersion)
+		f.node.Metadata.ResourceVersion = strconv.Itoa(version + 1)
+		json.NewEncoder(w).Encode(f.node)
+	case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/default/events":
+		var e event
+		json.NewDecoder(r.Body).Decode(&e)
+		f.events = append(f.events, e)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeAPIServer) reasons() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var reasons []string
+	for _, e := range f.events {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+func newFakeAPIServer() (*fakeAPIServer, *httptest.Server) {
+	fake := &fakeAPIServer{}
+	fake.node.Metadata = objectMeta{Name: "ip-10-0-0-1.ec2.internal", UID: "node-uid", ResourceVersion: "1"}
+	fake.node.Spec.ProviderID = "aws:///us-east-1a/i-0123456789"
+	return fake, httptest.NewServer(fake)
+}
+
+type fakeProvider struct {
+	result *deregister.DrainResult
+	err    error
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	return p.result, p.err
+}
+
+func TestEventRecorderRecordsDrain(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+
+	recorder := &EventRecorder{
+		Client: &Client{Host: server.URL},
+		Forwarder: deregister.Forwarder{Provider: &fakeProvider{result: &deregister.DrainResult{
+			NodeID: "i-0123456789",
+			LoadBalancers: []deregister.LoadBalancerResult{
+				{Name: "classic-elb", Type: "elbv1", Deregistered: true},
+				{Name: "unregistered-elb", Type: "elbv1"},
+				{Name: "arn:aws:elasticloadbalancing:target-group", Type: "elbv2", Deregistered: true, TimedOut: true},
+			},
+		}}},
+	}
+
+	_, err := recorder.DrainNodeFromLoadBalancer(context.Background(), "i-0123456789")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	reasons := fake.reasons()
+	expected := []string{ReasonDrainStarted, ReasonDrainedFromLB, ReasonDrainTimedOut}
+	if len(reasons) != len(expected) {
+		t.Fatalf("failed - expected events %v, got %v", expected, reasons)
+	}
+	for i := range expected {
+		if reasons[i] != expected[i] {
+			t.Fatalf("failed - expected events %v, got %v", expected, reasons)
+		}
+	}
+
+	e := fake.events[0]
+	if e.InvolvedObject.Kind != "Node" || e.InvolvedObject.Name != "ip-10-0-0-1.ec2.internal" || e.InvolvedObject.UID != "node-uid" {
+		t.Fatalf("failed - unexpected involved object %v", e.InvolvedObject)
+	}
+	if fake.events[2].Type != "Warning" {
+		t.Fatalf("failed - expected timeout event to be a warning, got %v", fake.events[2].Type)
+	}
+
+	if len(fake.patches) != 1 {
+		t.Fatalf("failed - expected a single annotation patch, got %v", fake.patches)
+	}
+	annotations := fake.patches[0]["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	if annotations[DrainedLoadBalancersAnnotation] != "classic-elb" {
+		t.Fatalf("failed - expected only drained load balancers to be annotated, got %v", annotations)
+	}
+}
+
+func TestEventRecorderRecordsFailure(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+
+	recorder := &EventRecorder{
+		Client:    &Client{Host: server.URL},
+		Forwarder: deregister.Forwarder{Provider: &fakeProvider{result: &deregister.DrainResult{}, err: errors.New("throttled")}},
+	}
+
+	_, err := recorder.DrainNodeFromLoadBalancer(context.Background(), "ip-10-0-0-1.ec2.internal")
+	if err == nil {
+		t.Fatalf("failed - expected provider error to be returned")
+	}
+
+	reasons := fake.reasons()
+	if len(reasons) != 2 || reasons[1] != ReasonDrainFailed {
+		t.Fatalf("failed - expected started and failed events, got %v", reasons)
+	}
+	if len(fake.patches) != 0 {
+		t.Fatalf("failed - expected no annotations without drained load balancers, got %v", fake.patches)
+	}
+}
+
+func TestEventRecorderIgnoresUnknownNodes(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+
+	recorder := &EventRecorder{
+		Client:    &Client{Host: server.URL},
+		Forwarder: deregister.Forwarder{Provider: &fakeProvider{result: &deregister.DrainResult{NodeID: "i-unknown"}}},
+	}
+
+	_, err := recorder.DrainNodeFromLoadBalancer(context.Background(), "i-unknown")
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if reasons := fake.reasons(); len(reasons) != 0 {
+		t.Fatalf("failed - expected no events, got %v", reasons)
+	}
+}
@@ -0,0 +1,230 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeconfig is the subset of a kubeconfig file needed to reach the API server
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Exec                  *execConfig `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// execConfig runs a credential plugin such as `aws eks get-token`
+type execConfig struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// NewClient creates a client from the kubeconfig named by KUBECONFIG, falling
+// back to the pod service account when it is unset
+func NewClient() (*Client, error) {
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return NewClientFromKubeconfig(path)
+	}
+	return NewInClusterClient()
+}
+
+// NewClientFromKubeconfig creates a client for the current context of a kubeconfig file
+func NewClientFromKubeconfig(path string) (*Client, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse kubeconfig %s: %v", path, err)
+	}
+
+	clusterName, userName := "", ""
+	for _, c := range config.Contexts {
+		if c.Name == config.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("kubeconfig %s has no context %q", path, config.CurrentContext)
+	}
+
+	// relative file references are resolved against the kubeconfig's directory
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	client := &Client{}
+	tlsConfig := &tls.Config{}
+	for _, c := range config.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		client.Host = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		caData, err := fileOrData(resolve(c.Cluster.CertificateAuthority), c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, err
+		}
+		if caData != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caData) {
+				return nil, errors.New("unable to parse kubeconfig certificate authority")
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if client.Host == "" {
+		return nil, fmt.Errorf("kubeconfig %s has no cluster %q", path, clusterName)
+	}
+
+	for _, u := range config.Users {
+		if u.Name != userName {
+			continue
+		}
+		client.BearerToken = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolve(u.User.TokenFile))
+			if err != nil {
+				return nil, err
+			}
+			client.BearerToken = strings.TrimSpace(string(token))
+		}
+
+		certData, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, err
+		}
+		keyData, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, err
+		}
+		if certData != nil && keyData != nil {
+			cert, err := tls.X509KeyPair(certData, keyData)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		if u.User.Exec != nil {
+			client.tokenSource = (&execTokenSource{config: u.User.Exec}).Token
+		}
+	}
+
+	client.HTTP = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return client, nil
+}
+
+// fileOrData returns inline base64 data if set, otherwise the contents of the file
+func fileOrData(file string, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// execTokenSource runs a credential plugin, caching the token until it expires
+type execTokenSource struct {
+	config *execConfig
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// execCredential is the output of a client-go credential plugin
+type execCredential struct {
+	Status struct {
+		Token               string    `json:"token"`
+		ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+func (s *execTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expires.IsZero() || time.Now().Add(time.Minute).Before(s.expires)) {
+		return s.token, nil
+	}
+
+	cmd := exec.Command(s.config.Command, s.config.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range s.config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf(`KUBERNETES_EXEC_INFO={"apiVersion":%q,"kind":"ExecCredential"}`, s.config.APIVersion))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("credential plugin %s failed: %v: %s", s.config.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var credential execCredential
+	if err := json.Unmarshal(out, &credential); err != nil {
+		return "", fmt.Errorf("unable to decode credential plugin output: %v", err)
+	}
+	if credential.Status.Token == "" {
+		return "", errors.New("credential plugin returned no token")
+	}
+
+	s.token, s.expires = credential.Status.Token, credential.Status.ExpirationTimestamp
+	return s.token, nil
+}
@@ -0,0 +1,77 @@
+package kube
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewClientFromKubeconfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer file-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600)
+	kubeconfigPath := filepath.Join(dir, "config")
+	ioutil.WriteFile(kubeconfigPath, []byte(`
+apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: other
+  cluster:
+    server: https://other.example.com
+- name: test-cluster
+  cluster:
+    server: `+server.URL+`/
+contexts:
+- name: test
+  context:
+    cluster: test-cluster
+    user: test-user
+users:
+- name: test-user
+  user:
+    tokenFile: token
+`), 0600)
+
+	client, err := NewClientFromKubeconfig(kubeconfigPath)
+	if err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if client.Host != server.URL {
+		t.Fatalf("failed - expected host %v, got %v", server.URL, client.Host)
+	}
+
+	if err := client.Do("GET", "/api/v1/nodes", "", nil, nil); err != nil {
+		t.Fatalf("failed - expected token from relative tokenFile to be sent, got %v", err)
+	}
+}
+
+func TestNewClientFromKubeconfigMissingContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubeconfigPath := filepath.Join(dir, "config")
+	ioutil.WriteFile(kubeconfigPath, []byte("current-context: missing\n"), 0600)
+	if _, err := NewClientFromKubeconfig(kubeconfigPath); err == nil {
+		t.Fatalf("failed - expected missing context to be rejected")
+	}
+}
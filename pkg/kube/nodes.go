@@ -0,0 +1,227 @@
+package kube
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ExcludeFromExternalLoadBalancersLabel stops cloud controller managers and MetalLB
+	// sending a node load balancer traffic
+	ExcludeFromExternalLoadBalancersLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+	// ExcludedAnnotation marks nodes labelled by a drain, so undrain only removes labels it added
+	ExcludedAnnotation = "hasta-la-vista.io/excluded-from-load-balancers"
+	// CordonedAnnotation marks nodes cordoned by a drain
+	CordonedAnnotation = "hasta-la-vista.io/cordoned"
+	// TaintedAnnotation names the taint a drain added to the node
+	TaintedAnnotation = "hasta-la-vista.io/tainted"
+
+	// conflictAttempts is how many times a taint change is tried against a node which keeps changing
+	conflictAttempts = 5
+)
+
+// Taint is a Node taint
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// Node is the part of a Kubernetes Node providers need
+type Node struct {
+	Name          string
+	ProviderID    string
+	Addresses     []string
+	Labels        map[string]string
+	Annotations   map[string]string
+	Unschedulable bool
+	Taints        []Taint
+	// ResourceVersion is the version of the Node read, kept current by the patches made to it
+	ResourceVersion string
+}
+
+// GetNode looks a node up by name, falling back to matching its provider ID or addresses
+func (c *Client) GetNode(nodeName string) (*Node, error) {
+	n, err := c.lookupNode(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("no kubernetes node found for %s", nodeName)
+	}
+
+	result := &Node{
+		Name:            n.Metadata.Name,
+		ProviderID:      n.Spec.ProviderID,
+		Labels:          n.Metadata.Labels,
+		Annotations:     n.Metadata.Annotations,
+		Unschedulable:   n.Spec.Unschedulable,
+		Taints:          n.Spec.Taints,
+		ResourceVersion: n.Metadata.ResourceVersion,
+	}
+	for _, address := range n.Status.Addresses {
+		result.Addresses = append(result.Addresses, address.Address)
+	}
+	return result, nil
+}
+
+// ExcludeFromLoadBalancers labels the node so it is removed from external load balancers,
+// returning whether the label was added. A node which already has the label is left alone
+func (c *Client) ExcludeFromLoadBalancers(n *Node) (bool, error) {
+	if _, ok := n.Labels[ExcludeFromExternalLoadBalancersLabel]; ok {
+		return false, nil
+	}
+
+	return true, c.patchNode(n, map[string]interface{}{
+		"labels":      map[string]interface{}{ExcludeFromExternalLoadBalancersLabel: ""},
+		"annotations": map[string]interface{}{ExcludedAnnotation: "true"},
+	}, nil)
+}
+
+// IncludeInLoadBalancers removes the exclude label, if it was added by ExcludeFromLoadBalancers
+func (c *Client) IncludeInLoadBalancers(n *Node) (bool, error) {
+	if n.Annotations[ExcludedAnnotation] != "true" {
+		return false, nil
+	}
+
+	return true, c.patchNode(n, map[string]interface{}{
+		"labels":      map[string]interface{}{ExcludeFromExternalLoadBalancersLabel: nil},
+		"annotations": map[string]interface{}{ExcludedAnnotation: nil},
+	}, nil)
+}
+
+// Cordon marks the node unschedulable, returning whether it was schedulable
+func (c *Client) Cordon(n *Node) (bool, error) {
+	if n.Unschedulable {
+		return false, nil
+	}
+
+	return true, c.patchNode(n, map[string]interface{}{
+		"annotations": map[string]interface{}{CordonedAnnotation: "true"},
+	}, map[string]interface{}{"unschedulable": true})
+}
+
+// Uncordon marks the node schedulable, if it was cordoned by Cordon
+func (c *Client) Uncordon(n *Node) (bool, error) {
+	if n.Annotations[CordonedAnnotation] != "true" {
+		return false, nil
+	}
+
+	return true, c.patchNode(n, map[string]interface{}{
+		"annotations": map[string]interface{}{CordonedAnnotation: nil},
+	}, map[string]interface{}{"unschedulable": nil})
+}
+
+// AddTaint adds a NoSchedule taint with the key, returning whether the node did not already have it
+func (c *Client) AddTaint(n *Node, key string) (bool, error) {
+	return c.patchTaints(n, func(n *Node) ([]Taint, interface{}, bool) {
+		for _, taint := range n.Taints {
+			if taint.Key == key {
+				return nil, nil, false
+			}
+		}
+		return append(append([]Taint{}, n.Taints...), Taint{Key: key, Effect: "NoSchedule"}), key, true
+	})
+}
+
+// RemoveTaint removes the taint with the key, if it was added by AddTaint
+func (c *Client) RemoveTaint(n *Node, key string) (bool, error) {
+	return c.patchTaints(n, func(n *Node) ([]Taint, interface{}, bool) {
+		if n.Annotations[TaintedAnnotation] != key {
+			return nil, nil, false
+		}
+
+		taints := []Taint{}
+		for _, taint := range n.Taints {
+			if taint.Key != key {
+				taints = append(taints, taint)
+			}
+		}
+		return taints, nil, true
+	})
+}
+
+// patchTaints replaces the node's taints and sets the tainted annotation as change returns, unless
+// it reports nothing to change. A merge patch replaces the whole list, so the patch only applies
+// to the resource version the taints were read from. If the node changed since, it is read again
+// and the change made on the current taints
+func (c *Client) patchTaints(n *Node, change func(n *Node) (taints []Taint, annotation interface{}, ok bool)) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		taints, annotation, ok := change(n)
+		if !ok {
+			return false, nil
+		}
+
+		metadata := map[string]interface{}{
+			"annotations": map[string]interface{}{TaintedAnnotation: annotation},
+		}
+		if n.ResourceVersion != "" {
+			metadata["resourceVersion"] = n.ResourceVersion
+		}
+		err := c.patchNode(n, metadata, map[string]interface{}{"taints": taints})
+		if !IsConflict(err) || attempt >= conflictAttempts {
+			return err == nil, err
+		}
+
+		log.Debug().Str("node", n.Name).Int("attempt", attempt).Msg("node changed while updating taints, retrying")
+		current, err := c.GetNode(n.Name)
+		if err != nil {
+			return false, err
+		}
+		*n = *current
+	}
+}
+
+// patchNode merge-patches the node metadata and optionally its spec, nil values remove a key.
+// The node's resource version is updated from the patched Node
+func (c *Client) patchNode(n *Node, metadata map[string]interface{}, spec map[string]interface{}) error {
+	patch := map[string]interface{}{"metadata": metadata}
+	if spec != nil {
+		patch["spec"] = spec
+	}
+
+	var patched node
+	if err := c.Do("PATCH", "/api/v1/nodes/"+url.PathEscape(n.Name), "application/merge-patch+json", patch, &patched); err != nil {
+		return err
+	}
+	n.ResourceVersion = patched.Metadata.ResourceVersion
+	return nil
+}
+
+// lookupNode gets a node by name, falling back to matching its provider ID or addresses
+// so instance IDs and private IPs resolve to the Node object. It returns nil if none match
+func (c *Client) lookupNode(nodeName string) (*node, error) {
+	if nodeName == "" {
+		return nil, nil
+	}
+
+	var n node
+	err := c.Do("GET", "/api/v1/nodes/"+url.PathEscape(nodeName), "", nil, &n)
+	if err == nil {
+		return &n, nil
+	}
+	if !IsNotFound(err) {
+		return nil, err
+	}
+
+	var nodes nodeList
+	if err := c.Do("GET", "/api/v1/nodes", "", nil, &nodes); err != nil {
+		return nil, err
+	}
+
+	for i := range nodes.Items {
+		candidate := &nodes.Items[i]
+		if strings.HasSuffix(candidate.Spec.ProviderID, "/"+nodeName) {
+			return candidate, nil
+		}
+		for _, address := range candidate.Status.Addresses {
+			if address.Address == nodeName {
+				return candidate, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,121 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExcludeAndIncludeNode(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+	client := &Client{Host: server.URL}
+
+	n, err := client.GetNode("i-0123456789")
+	if err != nil || n.Name != "ip-10-0-0-1.ec2.internal" {
+		t.Fatalf("failed - unexpected node %+v %v", n, err)
+	}
+
+	added, err := client.ExcludeFromLoadBalancers(n)
+	if err != nil || !added {
+		t.Fatalf("failed - expected label to be added, got %v %v", added, err)
+	}
+	expected := map[string]interface{}{"metadata": map[string]interface{}{
+		"labels":      map[string]interface{}{ExcludeFromExternalLoadBalancersLabel: ""},
+		"annotations": map[string]interface{}{ExcludedAnnotation: "true"},
+	}}
+	if len(fake.patches) != 1 || !reflect.DeepEqual(fake.patches[0], expected) {
+		t.Fatalf("failed - unexpected patches %v", fake.patches)
+	}
+
+	// a node labelled by someone else is left alone in both directions
+	n.Labels = map[string]string{ExcludeFromExternalLoadBalancersLabel: "true"}
+	if added, err := client.ExcludeFromLoadBalancers(n); err != nil || added {
+		t.Fatalf("failed - expected labelled node to be skipped, got %v %v", added, err)
+	}
+	if included, err := client.IncludeInLoadBalancers(n); err != nil || included {
+		t.Fatalf("failed - expected node not excluded by a drain to be skipped, got %v %v", included, err)
+	}
+
+	n.Annotations = map[string]string{ExcludedAnnotation: "true"}
+	if included, err := client.IncludeInLoadBalancers(n); err != nil || !included {
+		t.Fatalf("failed - expected label to be removed, got %v %v", included, err)
+	}
+	if len(fake.patches) != 2 {
+		t.Fatalf("failed - expected 2 patches, got %v", fake.patches)
+	}
+}
+
+func TestCordonAndTaintNode(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+	client := &Client{Host: server.URL}
+	n := &Node{Name: fake.node.Metadata.Name, Taints: []Taint{{Key: "dedicated", Value: "ingress", Effect: "NoSchedule"}}}
+
+	if cordoned, err := client.Cordon(n); err != nil || !cordoned {
+		t.Fatalf("failed - expected node to be cordoned, got %v %v", cordoned, err)
+	}
+	if added, err := client.AddTaint(n, "drain"); err != nil || !added {
+		t.Fatalf("failed - expected taint to be added, got %v %v", added, err)
+	}
+	spec := fake.patches[1]["spec"].(map[string]interface{})
+	if taints := spec["taints"].([]interface{}); len(taints) != 2 {
+		t.Fatalf("failed - expected existing taints to be kept, got %v", taints)
+	}
+
+	// only changes made by a drain are undone
+	if removed, err := client.RemoveTaint(n, "drain"); err != nil || removed {
+		t.Fatalf("failed - expected taint without annotation to be kept, got %v %v", removed, err)
+	}
+	n.Annotations = map[string]string{CordonedAnnotation: "true", TaintedAnnotation: "drain"}
+	n.Taints = append(n.Taints, Taint{Key: "drain", Effect: "NoSchedule"})
+	if uncordoned, err := client.Uncordon(n); err != nil || !uncordoned {
+		t.Fatalf("failed - expected node to be uncordoned, got %v %v", uncordoned, err)
+	}
+	if removed, err := client.RemoveTaint(n, "drain"); err != nil || !removed {
+		t.Fatalf("failed - expected taint to be removed, got %v %v", removed, err)
+	}
+	spec = fake.patches[3]["spec"].(map[string]interface{})
+	if taints := spec["taints"].([]interface{}); len(taints) != 1 {
+		t.Fatalf("failed - expected only the drain taint to be removed, got %v", taints)
+	}
+}
+
+func TestTaintRetriesConflicts(t *testing.T) {
+	fake, server := newFakeAPIServer()
+	defer server.Close()
+	client := &Client{Host: server.URL}
+
+	n, err := client.GetNode(fake.node.Metadata.Name)
+	if err != nil || n.ResourceVersion != "1" {
+		t.Fatalf("failed - unexpected node %+v %v", n, err)
+	}
+
+	// another writer taints the node after it was read
+	fake.node.Metadata.ResourceVersion = "2"
+	fake.node.Spec.Taints = []Taint{{Key: "other", Effect: "NoSchedule"}}
+
+	if added, err := client.AddTaint(n, "drain"); err != nil || !added {
+		t.Fatalf("failed - expected taint to be added, got %v %v", added, err)
+	}
+	if len(fake.patches) != 2 || fake.patches[0]["metadata"].(map[string]interface{})["resourceVersion"] != "1" {
+		t.Fatalf("failed - expected a conflicting patch then a retry, got %v", fake.patches)
+	}
+	retry := fake.patches[1]
+	if version := retry["metadata"].(map[string]interface{})["resourceVersion"]; version != "2" {
+		t.Fatalf("failed - expected the retry to use the current version, got %v", version)
+	}
+	if taints := retry["spec"].(map[string]interface{})["taints"].([]interface{}); len(taints) != 2 {
+		t.Fatalf("failed - expected the other writer's taint to be kept, got %v", taints)
+	}
+	if n.ResourceVersion != "3" {
+		t.Fatalf("failed - expected the node's version to follow the patch, got %v", n.ResourceVersion)
+	}
+
+	// the change is decided again on the node as it is now
+	n.ResourceVersion = "stale"
+	n.Annotations = map[string]string{TaintedAnnotation: "drain"}
+	fake.node.Metadata.Annotations = map[string]string{TaintedAnnotation: "other"}
+	if removed, err := client.RemoveTaint(n, "drain"); err != nil || removed {
+		t.Fatalf("failed - expected a taint added by someone else after the read to be kept, got %v %v", removed, err)
+	}
+}
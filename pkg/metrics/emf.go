@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EMF is a Recorder which writes each drain as a CloudWatch Embedded Metric Format
+// document, so CloudWatch Logs extracts the metrics without PutMetricData permissions
+type EMF struct {
+	Writer     io.Writer
+	Namespace  string
+	Dimensions map[string]string
+
+	mu            sync.Mutex
+	loadBalancers int
+	timeouts      int
+	apiErrors     int
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+var emfMetrics = []emfMetric{
+	{Name: "DrainDuration", Unit: "Milliseconds"},
+	{Name: "LoadBalancersDrained", Unit: "Count"},
+	{Name: "Timeouts", Unit: "Count"},
+	{Name: "Failures", Unit: "Count"},
+	{Name: "APIErrors", Unit: "Count"},
+}
+
+// NewEMF creates a recorder writing to w with the given static dimensions, e.g.
+// the autoscaling group name. The cluster name is always added as a dimension.
+func NewEMF(w io.Writer, namespace string, dimensions map[string]string) *EMF {
+	return &EMF{Writer: w, Namespace: namespace, Dimensions: dimensions}
+}
+
+// DrainStarted resets the counts for a new drain
+func (e *EMF) DrainStarted() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadBalancers, e.timeouts, e.apiErrors = 0, 0, 0
+}
+
+// DrainFinished writes the metrics document for the drain
+func (e *EMF) DrainFinished(cluster string, outcome string, duration time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	document := map[string]interface{}{
+		"Cluster":              cluster,
+		"Outcome":              outcome,
+		"DrainDuration":        duration.Milliseconds(),
+		"LoadBalancersDrained": e.loadBalancers,
+		"Timeouts":             e.timeouts,
+		"Failures":             0,
+		"APIErrors":            e.apiErrors,
+	}
+	if outcome == OutcomeError {
+		document["Failures"] = 1
+	}
+
+	dimensions := []string{"Cluster"}
+	for name, value := range e.Dimensions {
+		document[name] = value
+		dimensions = append(dimensions, name)
+	}
+	sort.Strings(dimensions[1:])
+
+	document["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  e.Namespace,
+			Dimensions: [][]string{dimensions},
+			Metrics:    emfMetrics,
+		}},
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return
+	}
+	e.Writer.Write(append(data, '\n'))
+}
+
+// LoadBalancerDrained counts the load balancer and whether it timed out
+func (e *EMF) LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadBalancers++
+	if timedOut {
+		e.timeouts++
+	}
+}
+
+// APICall counts failed API calls
+func (e *EMF) APICall(operation string, err error) {
+	if err == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.apiErrors++
+}
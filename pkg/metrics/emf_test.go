@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEMFDocument(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEMF(&buf, "HastaLaVista", map[string]string{"AutoScalingGroupName": "prod-workers"})
+	e.DrainStarted()
+	e.LoadBalancerDrained("prod", LoadBalancerELBV1, time.Second, false)
+	e.LoadBalancerDrained("prod", LoadBalancerELBV2, time.Minute, true)
+	e.APICall("elb.DescribeTags", errors.New("throttled"))
+	e.DrainFinished("prod", OutcomeTimeout, 1500*time.Millisecond)
+
+	var document struct {
+		AWS struct {
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name string }
+			}
+		} `json:"_aws"`
+		Cluster              string
+		AutoScalingGroupName string
+		DrainDuration        int64
+		LoadBalancersDrained int
+		Timeouts             int
+		Failures             int
+		APIErrors            int
+	}
+	if err := json.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatalf("failed - document is not valid json: %v", err)
+	}
+
+	if document.Cluster != "prod" || document.AutoScalingGroupName != "prod-workers" {
+		t.Fatalf("failed - unexpected dimension values %+v", document)
+	}
+	if document.DrainDuration != 1500 || document.LoadBalancersDrained != 2 || document.Timeouts != 1 || document.APIErrors != 1 {
+		t.Fatalf("failed - unexpected metric values %+v", document)
+	}
+	if document.Failures != 0 {
+		t.Fatalf("failed - expected timeout not to count as a failure, got %v", document.Failures)
+	}
+
+	directive := document.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != "HastaLaVista" || len(directive.Metrics) != len(emfMetrics) {
+		t.Fatalf("failed - unexpected directive %+v", directive)
+	}
+	if len(directive.Dimensions) != 1 || len(directive.Dimensions[0]) != 2 || directive.Dimensions[0][1] != "AutoScalingGroupName" {
+		t.Fatalf("failed - unexpected dimensions %v", directive.Dimensions)
+	}
+}
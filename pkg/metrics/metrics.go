@@ -0,0 +1,50 @@
+package metrics
+
+import "time"
+
+// Drain outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+)
+
+// Load balancer types
+const (
+	LoadBalancerELBV1          = "elbv1"
+	LoadBalancerELBV2          = "elbv2"
+	LoadBalancerTargetPool     = "targetpool"
+	LoadBalancerBackendService = "backendservice"
+	LoadBalancerAzure          = "azurelb"
+	LoadBalancerOctavia        = "octavia"
+	LoadBalancerMetalLBL2      = "metallb-l2"
+	LoadBalancerMetalLBBGP     = "metallb-bgp"
+)
+
+// Recorder receives instrumentation from cloud providers while draining nodes
+type Recorder interface {
+	// DrainStarted marks a drain as in flight
+	DrainStarted()
+	// DrainFinished records the outcome of a drain started with DrainStarted
+	DrainFinished(cluster string, outcome string, duration time.Duration)
+	// LoadBalancerDrained records how long draining from a single load balancer or target group took
+	LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool)
+	// APICall records a call to the cloud provider API
+	APICall(operation string, err error)
+}
+
+// Nop is a Recorder which discards everything
+type Nop struct{}
+
+// DrainStarted does nothing
+func (Nop) DrainStarted() {}
+
+// DrainFinished does nothing
+func (Nop) DrainFinished(cluster string, outcome string, duration time.Duration) {}
+
+// LoadBalancerDrained does nothing
+func (Nop) LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool) {
+}
+
+// APICall does nothing
+func (Nop) APICall(operation string, err error) {}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hastalavista"
+
+var durationBuckets = []float64{1, 5, 10, 30, 60, 120, 300}
+
+// Prometheus is a Recorder exposing metrics for scraping
+type Prometheus struct {
+	gatherer prometheus.Gatherer
+
+	drains          *prometheus.CounterVec
+	drainDuration   *prometheus.HistogramVec
+	drainsInFlight  prometheus.Gauge
+	lbDrainDuration *prometheus.HistogramVec
+	lbTimeouts      *prometheus.CounterVec
+	apiCalls        *prometheus.CounterVec
+	apiErrors       *prometheus.CounterVec
+}
+
+// NewPrometheus creates the collectors and registers them with a new registry
+func NewPrometheus() *Prometheus {
+	registry := prometheus.NewRegistry()
+	p := &Prometheus{
+		gatherer: registry,
+		drains: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "drains_total",
+			Help:      "Drain requests by cluster and outcome.",
+		}, []string{"cluster", "outcome"}),
+		drainDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "drain_duration_seconds",
+			Help:      "Time taken to drain a node from all of its load balancers.",
+			Buckets:   durationBuckets,
+		}, []string{"cluster", "outcome"}),
+		drainsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "drains_in_flight",
+			Help:      "Drains currently in progress.",
+		}),
+		lbDrainDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "load_balancer_drain_duration_seconds",
+			Help:      "Time taken to drain a node from a single load balancer or target group.",
+			Buckets:   durationBuckets,
+		}, []string{"cluster", "type"}),
+		lbTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "load_balancer_drain_timeouts_total",
+			Help:      "Load balancers or target groups a node did not drain from before the timeout.",
+		}, []string{"cluster", "type"}),
+		apiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cloud_api_calls_total",
+			Help:      "Cloud provider API calls by operation.",
+		}, []string{"operation"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cloud_api_errors_total",
+			Help:      "Failed cloud provider API calls by operation.",
+		}, []string{"operation"}),
+	}
+
+	registry.MustRegister(
+		p.drains,
+		p.drainDuration,
+		p.drainsInFlight,
+		p.lbDrainDuration,
+		p.lbTimeouts,
+		p.apiCalls,
+		p.apiErrors,
+	)
+	return p
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{})
+}
+
+// DrainStarted increments the in flight gauge
+func (p *Prometheus) DrainStarted() {
+	p.drainsInFlight.Inc()
+}
+
+// DrainFinished counts the drain and observes its duration
+func (p *Prometheus) DrainFinished(cluster string, outcome string, duration time.Duration) {
+	p.drainsInFlight.Dec()
+	p.drains.WithLabelValues(cluster, outcome).Inc()
+	p.drainDuration.WithLabelValues(cluster, outcome).Observe(duration.Seconds())
+}
+
+// LoadBalancerDrained observes the duration and counts timeouts
+func (p *Prometheus) LoadBalancerDrained(cluster string, lbType string, duration time.Duration, timedOut bool) {
+	p.lbDrainDuration.WithLabelValues(cluster, lbType).Observe(duration.Seconds())
+	if timedOut {
+		p.lbTimeouts.WithLabelValues(cluster, lbType).Inc()
+	}
+}
+
+// APICall counts the call and whether it failed
+func (p *Prometheus) APICall(operation string, err error) {
+	p.apiCalls.WithLabelValues(operation).Inc()
+	if err != nil {
+		p.apiErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	p := NewPrometheus()
+	p.DrainStarted()
+	p.DrainStarted()
+	p.LoadBalancerDrained("prod", LoadBalancerELBV2, 3*time.Second, true)
+	p.APICall("elbv2.DescribeTargetHealth", nil)
+	p.APICall("elbv2.DescribeTargetHealth", errors.New("throttled"))
+	p.DrainFinished("prod", OutcomeTimeout, 65*time.Second)
+
+	recorder := httptest.NewRecorder()
+	p.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	expected := []string{
+		`hastalavista_drains_total{cluster="prod",outcome="timeout"} 1`,
+		`hastalavista_drains_in_flight 1`,
+		`hastalavista_load_balancer_drain_timeouts_total{cluster="prod",type="elbv2"} 1`,
+		`hastalavista_load_balancer_drain_duration_seconds_count{cluster="prod",type="elbv2"} 1`,
+		`hastalavista_cloud_api_calls_total{operation="elbv2.DescribeTargetHealth"} 2`,
+		`hastalavista_cloud_api_errors_total{operation="elbv2.DescribeTargetHealth"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Fatalf("failed - expected metrics to contain %q, got\n%s", line, body)
+		}
+	}
+}
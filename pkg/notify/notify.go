@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Notification describes a completed drain
+type Notification struct {
+	Node          string                          `json:"node"`
+	NodeID        string                          `json:"nodeId,omitempty"`
+	Cluster       string                          `json:"cluster,omitempty"`
+	LoadBalancers []deregister.LoadBalancerResult `json:"loadBalancers"`
+	Outcome       string                          `json:"outcome"`
+	DryRun        bool                            `json:"dryRun"`
+	Duration      time.Duration                   `json:"-"`
+	Error         string                          `json:"error,omitempty"`
+}
+
+// DurationSeconds is the drain duration in seconds, for payloads and templates
+func (n *Notification) DurationSeconds() float64 {
+	return n.Duration.Seconds()
+}
+
+// MarshalJSON includes the duration in seconds
+func (n *Notification) MarshalJSON() ([]byte, error) {
+	type notification Notification
+	return json.Marshal(struct {
+		*notification
+		DurationSeconds float64 `json:"durationSeconds"`
+	}{(*notification)(n), n.DurationSeconds()})
+}
+
+// Notifier is told about every completed drain
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+// Config lists the notifiers to send drain notifications to
+type Config struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+// Load reads a JSON notifier config file, validating every webhook
+func Load(path string) ([]Notifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing notifiers %s: %v", path, err)
+	}
+
+	var notifiers []Notifier
+	for i, webhook := range config.Webhooks {
+		if err := webhook.Init(); err != nil {
+			return nil, fmt.Errorf("webhook %d in %s: %v", i, path, err)
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	return notifiers, nil
+}
+
+// Provider wraps a cloud provider, sending a notification once each drain completes.
+// Notification failures are logged and never fail the drain.
+type Provider struct {
+	deregister.Forwarder
+	Notifiers []Notifier
+}
+
+// DrainNodeFromLoadBalancer drains the node with the wrapped provider and notifies on completion
+func (p *Provider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	result, err := p.Provider.DrainNodeFromLoadBalancer(ctx, nodeName)
+
+	notification := &Notification{Node: nodeName, Outcome: metrics.OutcomeSuccess}
+	if result != nil {
+		notification.NodeID = result.NodeID
+		notification.Cluster = result.ClusterName
+		notification.LoadBalancers = result.LoadBalancers
+		notification.Duration = result.Duration
+		notification.DryRun = result.DryRun
+		if result.TimedOut() {
+			notification.Outcome = metrics.OutcomeTimeout
+		}
+	}
+	if err != nil {
+		notification.Outcome = metrics.OutcomeError
+		notification.Error = err.Error()
+	}
+
+	for _, notifier := range p.Notifiers {
+		if notifyErr := notifier.Notify(ctx, notification); notifyErr != nil {
+			log.Error().Err(notifyErr).Str("nodeName", nodeName).Msg("error sending drain notification")
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+type fakeProvider struct {
+	result *deregister.DrainResult
+	err    error
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	return p.result, p.err
+}
+
+type fakeNotifier struct {
+	notifications []*Notification
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return errors.New("notifier errors are not returned")
+}
+
+func TestProviderNotifiesOutcome(t *testing.T) {
+	tests := []struct {
+		result  *deregister.DrainResult
+		err     error
+		outcome string
+	}{
+		{&deregister.DrainResult{ClusterName: "prod"}, nil, "success"},
+		{&deregister.DrainResult{LoadBalancers: []deregister.LoadBalancerResult{{Name: "elb", TimedOut: true}}}, nil, "timeout"},
+		{&deregister.DrainResult{}, errors.New("throttled"), "error"},
+	}
+
+	for _, test := range tests {
+		notifier := &fakeNotifier{}
+		provider := &Provider{
+			Forwarder: deregister.Forwarder{Provider: &fakeProvider{result: test.result, err: test.err}},
+			Notifiers: []Notifier{notifier},
+		}
+
+		_, err := provider.DrainNodeFromLoadBalancer(context.Background(), "i-0123456789")
+		if err != test.err {
+			t.Fatalf("failed - expected drain error %v, got %v", test.err, err)
+		}
+		if len(notifier.notifications) != 1 || notifier.notifications[0].Outcome != test.outcome {
+			t.Fatalf("failed - expected a single %v notification, got %v", test.outcome, notifier.notifications)
+		}
+	}
+}
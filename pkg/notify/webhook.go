@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook payload formats
+const (
+	FormatGeneric   = "generic"
+	FormatSlack     = "slack"
+	FormatPagerDuty = "pagerduty"
+)
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultAttempts    = 3
+	defaultBackoff     = time.Second
+)
+
+// Webhook posts a JSON payload describing each drain to a URL. The payload is
+// built for the format unless a Go template rendering the JSON body is given.
+type Webhook struct {
+	Name           string            `json:"name"`
+	URL            string            `json:"url"`
+	Format         string            `json:"format"`
+	Template       string            `json:"template,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	RoutingKey     string            `json:"routingKey,omitempty"`
+	Outcomes       []string          `json:"outcomes,omitempty"`
+	Attempts       int               `json:"attempts,omitempty"`
+	BackoffSeconds float64           `json:"backoffSeconds,omitempty"`
+	HTTP           *http.Client      `json:"-"`
+
+	template *template.Template
+}
+
+// Init validates the webhook, applying defaults and parsing its template
+func (w *Webhook) Init() error {
+	if w.Format == "" {
+		w.Format = FormatGeneric
+	}
+
+	switch w.Format {
+	case FormatGeneric, FormatSlack:
+	case FormatPagerDuty:
+		if w.RoutingKey == "" {
+			return errors.New("pagerduty webhooks require a routingKey")
+		}
+		if w.URL == "" {
+			w.URL = pagerDutyEventsURL
+		}
+	default:
+		return fmt.Errorf("unknown format %q", w.Format)
+	}
+
+	if w.URL == "" {
+		return errors.New("url is required")
+	}
+
+	for _, outcome := range w.Outcomes {
+		if outcome != metrics.OutcomeSuccess && outcome != metrics.OutcomeTimeout && outcome != metrics.OutcomeError {
+			return fmt.Errorf("unknown outcome %q", outcome)
+		}
+	}
+
+	if w.Template != "" {
+		t, err := template.New(w.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(w.Template)
+		if err != nil {
+			return err
+		}
+		w.template = t
+	}
+	return nil
+}
+
+// Notify posts the notification if its outcome is wanted, retrying
+// network errors, throttling and server errors with exponential backoff
+func (w *Webhook) Notify(ctx context.Context, notification *Notification) error {
+	if !w.wants(notification.Outcome) {
+		return nil
+	}
+
+	body, err := w.payload(notification)
+	if err != nil {
+		return err
+	}
+
+	attempts := w.Attempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	backoff := defaultBackoff
+	if w.BackoffSeconds > 0 {
+		backoff = time.Duration(w.BackoffSeconds * float64(time.Second))
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= attempts {
+			return err
+		}
+
+		log.Warn().Err(err).Str("webhook", w.Name).Int("attempt", attempt).Msg("error posting webhook, retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) wants(outcome string) bool {
+	if len(w.Outcomes) == 0 {
+		return true
+	}
+	for _, wanted := range w.Outcomes {
+		if wanted == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// post sends the body once, reporting whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	httpClient := w.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("webhook %s returned status %d: %s", w.Name, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (w *Webhook) payload(n *Notification) ([]byte, error) {
+	if w.template != nil {
+		var buf bytes.Buffer
+		if err := w.template.Execute(&buf, n); err != nil {
+			return nil, err
+		}
+		if !json.Valid(buf.Bytes()) {
+			return nil, fmt.Errorf("webhook %s template did not render valid JSON", w.Name)
+		}
+		return buf.Bytes(), nil
+	}
+
+	switch w.Format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": summary(n)})
+	case FormatPagerDuty:
+		return json.Marshal(pagerDutyEvent(w.RoutingKey, n))
+	}
+	return json.Marshal(n)
+}
+
+// pagerDutyEvent triggers an incident for failed drains and resolves it once the node drains
+func pagerDutyEvent(routingKey string, n *Notification) map[string]interface{} {
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"dedup_key":    "hasta-la-vista/" + n.Node,
+		"payload": map[string]interface{}{
+			"summary":        summary(n),
+			"source":         n.Node,
+			"severity":       "error",
+			"component":      "hasta-la-vista",
+			"group":          n.Cluster,
+			"class":          "drain-" + n.Outcome,
+			"custom_details": n,
+		},
+	}
+	if n.Outcome == metrics.OutcomeTimeout {
+		event["payload"].(map[string]interface{})["severity"] = "warning"
+	}
+	if n.Outcome == metrics.OutcomeSuccess {
+		event["event_action"] = "resolve"
+	}
+	return event
+}
+
+// summary is a one line human readable description of the drain
+func summary(n *Notification) string {
+	cluster := ""
+	if n.Cluster != "" {
+		cluster = " in cluster " + n.Cluster
+	}
+
+	switch n.Outcome {
+	case metrics.OutcomeTimeout:
+		var stuck []string
+		for _, lb := range n.LoadBalancers {
+			if lb.TimedOut {
+				stuck = append(stuck, lb.Name)
+			}
+		}
+		return fmt.Sprintf(":warning: Node %s%s did not drain from %s within timeout", n.Node, cluster, strings.Join(stuck, ", "))
+	case metrics.OutcomeError:
+		return fmt.Sprintf(":x: Error draining node %s%s: %s", n.Node, cluster, n.Error)
+	}
+	return fmt.Sprintf(":white_check_mark: Node %s%s drained from %d load balancers in %.1fs", n.Node, cluster, len(n.LoadBalancers), n.DurationSeconds())
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
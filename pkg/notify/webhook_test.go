@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// fakeReceiver records webhook bodies, failing the first failures requests with status
+type fakeReceiver struct {
+	mu       sync.Mutex
+	bodies   []map[string]interface{}
+	requests int
+	failures int
+	status   int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.requests <= f.failures {
+		w.WriteHeader(f.status)
+		return
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.bodies = append(f.bodies, body)
+}
+
+func newNotification(outcome string) *Notification {
+	return &Notification{
+		Node:    "i-0123456789",
+		NodeID:  "i-0123456789",
+		Cluster: "prod",
+		LoadBalancers: []deregister.LoadBalancerResult{
+			{Name: "classic-elb", Type: "elbv1"},
+			{Name: "target-group", Type: "elbv2", TimedOut: outcome == "timeout"},
+		},
+		Outcome:  outcome,
+		Duration: 90 * time.Second,
+	}
+}
+
+func newWebhook(t *testing.T, url string, webhook Webhook) *Webhook {
+	webhook.URL = url
+	webhook.BackoffSeconds = 0.001
+	if err := webhook.Init(); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	return &webhook
+}
+
+func TestWebhookGenericPayload(t *testing.T) {
+	receiver := &fakeReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{Name: "generic"})
+	if err := webhook.Notify(context.Background(), newNotification("success")); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+
+	body := receiver.bodies[0]
+	if body["node"] != "i-0123456789" || body["cluster"] != "prod" || body["outcome"] != "success" || body["durationSeconds"] != 90.0 {
+		t.Fatalf("failed - unexpected payload %v", body)
+	}
+	if lbs := body["loadBalancers"].([]interface{}); len(lbs) != 2 {
+		t.Fatalf("failed - expected both load balancers in payload, got %v", lbs)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	receiver := &fakeReceiver{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{Name: "flaky", Attempts: 3})
+	if err := webhook.Notify(context.Background(), newNotification("success")); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if receiver.requests != 3 || len(receiver.bodies) != 1 {
+		t.Fatalf("failed - expected success on the third attempt, got %v requests", receiver.requests)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	receiver := &fakeReceiver{failures: 5, status: http.StatusBadRequest}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{Name: "broken", Attempts: 3})
+	if err := webhook.Notify(context.Background(), newNotification("success")); err == nil {
+		t.Fatalf("failed - expected error for bad request")
+	}
+	if receiver.requests != 1 {
+		t.Fatalf("failed - expected a single attempt, got %v", receiver.requests)
+	}
+}
+
+func TestWebhookFiltersOutcomes(t *testing.T) {
+	receiver := &fakeReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{Name: "alerts", Format: FormatSlack, Outcomes: []string{"timeout", "error"}})
+	webhook.Notify(context.Background(), newNotification("success"))
+	webhook.Notify(context.Background(), newNotification("timeout"))
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("failed - expected only the timeout to be sent, got %v", receiver.bodies)
+	}
+	text := receiver.bodies[0]["text"].(string)
+	if !strings.Contains(text, "did not drain from target-group") {
+		t.Fatalf("failed - expected slack text to name the stuck target group, got %v", text)
+	}
+}
+
+func TestWebhookPagerDutyPayload(t *testing.T) {
+	receiver := &fakeReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{Name: "pagerduty", Format: FormatPagerDuty, RoutingKey: "routing-key"})
+	webhook.Notify(context.Background(), newNotification("error"))
+	webhook.Notify(context.Background(), newNotification("success"))
+
+	trigger, resolve := receiver.bodies[0], receiver.bodies[1]
+	if trigger["routing_key"] != "routing-key" || trigger["event_action"] != "trigger" || trigger["dedup_key"] != "hasta-la-vista/i-0123456789" {
+		t.Fatalf("failed - unexpected trigger event %v", trigger)
+	}
+	if trigger["payload"].(map[string]interface{})["severity"] != "error" {
+		t.Fatalf("failed - expected error severity, got %v", trigger["payload"])
+	}
+	if resolve["event_action"] != "resolve" || resolve["dedup_key"] != trigger["dedup_key"] {
+		t.Fatalf("failed - expected success to resolve the incident, got %v", resolve)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	receiver := &fakeReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := newWebhook(t, server.URL, Webhook{
+		Name:     "custom",
+		Template: `{"host": {{ json .Node }}, "result": {{ json .Outcome }}, "lbs": {{ len .LoadBalancers }}}`,
+	})
+	if err := webhook.Notify(context.Background(), newNotification("success")); err != nil {
+		t.Fatalf("failed - unexpected error %v", err)
+	}
+	if body := receiver.bodies[0]; body["host"] != "i-0123456789" || body["result"] != "success" || body["lbs"] != 2.0 {
+		t.Fatalf("failed - unexpected templated payload %v", body)
+	}
+
+	invalid := newWebhook(t, server.URL, Webhook{Name: "invalid", Template: `{"host": {{ .Node }}}`})
+	if err := invalid.Notify(context.Background(), newNotification("success")); err == nil {
+		t.Fatalf("failed - expected invalid JSON to be rejected")
+	}
+}
+
+func TestWebhookInitValidates(t *testing.T) {
+	invalid := []Webhook{
+		{Name: "no-url"},
+		{Name: "bad-format", URL: "http://example.com", Format: "teams"},
+		{Name: "no-routing-key", Format: FormatPagerDuty},
+		{Name: "bad-outcome", URL: "http://example.com", Outcomes: []string{"stuck"}},
+	}
+	for _, webhook := range invalid {
+		if err := webhook.Init(); err == nil {
+			t.Fatalf("failed - expected webhook %v to be rejected", webhook.Name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+// Actions which may be granted to an identity
+const (
+	ActionDrain   = "drain"
+	ActionUndrain = "undrain"
+	ActionDryRun  = "dry-run"
+)
+
+const wildcard = "*"
+
+// Policy is a set of rules granting identities actions on nodes. Requests
+// are denied unless a rule allows them.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants the listed identities the listed actions on nodes which match every
+// non-empty selector. Identities are identity names, or "group:<name>" for groups.
+type Rule struct {
+	Identities        []string          `json:"identities"`
+	Actions           []string          `json:"actions"`
+	Clusters          []string          `json:"clusters,omitempty"`
+	VPCs              []string          `json:"vpcs,omitempty"`
+	AutoscalingGroups []string          `json:"autoscalingGroups,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+}
+
+// Load reads a JSON policy file
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("error parsing policy %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// Authorize returns whether the identity may take the action on the node,
+// with a reason when it may not
+func (p *Policy) Authorize(identity *auth.Identity, action string, node *deregister.NodeInfo) (bool, string) {
+	if identity == nil {
+		return false, "request has no identity"
+	}
+
+	reason := fmt.Sprintf("no rule grants %s any actions", identity.Name)
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(identity) {
+			continue
+		}
+
+		denial := rule.deny(action, node)
+		if denial == "" {
+			return true, ""
+		}
+		reason = denial
+	}
+
+	return false, reason
+}
+
+func (r *Rule) appliesTo(identity *auth.Identity) bool {
+	for _, allowed := range r.Identities {
+		if allowed == wildcard || allowed == identity.Name {
+			return true
+		}
+		for _, group := range identity.Groups {
+			if allowed == "group:"+group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deny returns why the rule does not allow the action on the node, or empty if it does
+func (r *Rule) deny(action string, node *deregister.NodeInfo) string {
+	if !matches(r.Actions, action) {
+		return fmt.Sprintf("action %s not allowed", action)
+	}
+
+	if len(r.Clusters) > 0 && !matches(r.Clusters, node.ClusterName) {
+		return fmt.Sprintf("cluster %s not allowed", node.ClusterName)
+	}
+
+	if len(r.VPCs) > 0 && !matches(r.VPCs, node.VPCID) {
+		return fmt.Sprintf("vpc %s not allowed", node.VPCID)
+	}
+
+	if len(r.AutoscalingGroups) > 0 && !matches(r.AutoscalingGroups, node.AutoscalingGroup) {
+		return fmt.Sprintf("autoscaling group %s not allowed", node.AutoscalingGroup)
+	}
+
+	for key, value := range r.Tags {
+		actual, ok := node.Tags[key]
+		if !ok || (value != wildcard && actual != value) {
+			return fmt.Sprintf("node does not have tag %s=%s", key, value)
+		}
+	}
+
+	return ""
+}
+
+func matches(allowed []string, value string) bool {
+	for _, a := range allowed {
+		if a == wildcard || a == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+)
+
+func TestAuthorize(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{
+			Identities: []string{"ops"},
+			Actions:    []string{"*"},
+		},
+		{
+			Identities: []string{"ci", "group:payments-team"},
+			Actions:    []string{ActionDrain, ActionDryRun},
+			Clusters:   []string{"staging"},
+			Tags:       map[string]string{"team": "payments"},
+		},
+		{
+			Identities:        []string{"spot-handler"},
+			Actions:           []string{ActionDrain},
+			VPCs:              []string{"vpc-1"},
+			AutoscalingGroups: []string{"prod-spot"},
+		},
+	}}
+
+	staging := &deregister.NodeInfo{
+		ClusterName: "staging",
+		VPCID:       "vpc-1",
+		Tags:        map[string]string{"team": "payments"},
+	}
+	prodSpot := &deregister.NodeInfo{
+		ClusterName:      "prod",
+		VPCID:            "vpc-1",
+		AutoscalingGroup: "prod-spot",
+		Tags:             map[string]string{"team": "search"},
+	}
+
+	cases := []struct {
+		Identity *auth.Identity
+		Action   string
+		Node     *deregister.NodeInfo
+		Allowed  bool
+		Reason   string
+	}{
+		{Identity: &auth.Identity{Name: "ops"}, Action: ActionUndrain, Node: prodSpot, Allowed: true},
+		{Identity: &auth.Identity{Name: "ci"}, Action: ActionDrain, Node: staging, Allowed: true},
+		{Identity: &auth.Identity{Name: "ci"}, Action: ActionUndrain, Node: staging, Reason: "action undrain not allowed"},
+		{Identity: &auth.Identity{Name: "ci"}, Action: ActionDrain, Node: prodSpot, Reason: "cluster prod not allowed"},
+		{Identity: &auth.Identity{Name: "dev", Groups: []string{"payments-team"}}, Action: ActionDryRun, Node: staging, Allowed: true},
+		{Identity: &auth.Identity{Name: "spot-handler"}, Action: ActionDrain, Node: prodSpot, Allowed: true},
+		{Identity: &auth.Identity{Name: "spot-handler"}, Action: ActionDrain, Node: staging, Reason: "autoscaling group  not allowed"},
+		{Identity: &auth.Identity{Name: "stranger"}, Action: ActionDrain, Node: staging, Reason: "no rule grants stranger any actions"},
+	}
+
+	for i, c := range cases {
+		allowed, reason := p.Authorize(c.Identity, c.Action, c.Node)
+		if allowed != c.Allowed {
+			t.Fatalf("%d failed - expected allowed %v, got %v (%s)", i, c.Allowed, allowed, reason)
+		}
+		if !c.Allowed && reason != c.Reason {
+			t.Fatalf("%d failed - expected reason %q, got %q", i, c.Reason, reason)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/policy"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Server serves the drain API
+type Server struct {
+	Provider      deregister.CloudProvider
+	Authenticator auth.Authenticator
+	// Policy scopes what each identity may do. If nil, any authenticated caller may act on any node
+	Policy *policy.Policy
+	DryRun bool
+	// MaxTimeout bounds the timeout a request may ask for
+	MaxTimeout time.Duration
+	// Metrics serves /metrics if not nil
+	Metrics http.Handler
+	// Config serves the active configuration on /debug/config if not nil
+	Config http.Handler
+}
+
+// Handler returns the routes of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(response http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(response, "OK")
+	})
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics)
+	}
+	mux.HandleFunc("/drain", traced(auth.Middleware(s.Authenticator, s.handleDrain)))
+	mux.HandleFunc("/undrain", traced(auth.Middleware(s.Authenticator, s.handleUndrain)))
+	mux.HandleFunc("/nodes/", traced(auth.Middleware(s.Authenticator, s.handleNodes)))
+	mux.HandleFunc("/clusters/", traced(auth.Middleware(s.Authenticator, s.handleClusters)))
+	if s.Config != nil {
+		mux.HandleFunc("/debug/config", auth.Middleware(s.Authenticator, s.Config.ServeHTTP))
+	}
+	return mux
+}
+
+func (s *Server) handleDrain(response http.ResponseWriter, request *http.Request) {
+	if !requirePost(response, request) {
+		return
+	}
+
+	nodeName := request.URL.Query().Get("node")
+	options, err := s.drainOptions(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := policy.ActionDrain
+	if options.DryRun {
+		action = policy.ActionDryRun
+	}
+
+	if !s.authorize(response, request, action, nodeName) {
+		return
+	}
+
+	ctx, cancel := s.drainContext(request)
+	defer cancel()
+	result, err := s.Provider.DrainNodeFromLoadBalancer(deregister.WithOptions(ctx, options), nodeName)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if options.DryRun || acceptsJSON(request) {
+		writeJSON(response, result)
+		return
+	}
+
+	fmt.Fprint(response, "OK")
+}
+
+// drainGrace is how much longer than MaxTimeout a drain may run, covering the lookups
+// before polling starts and the final poll
+const drainGrace = time.Minute
+
+// detachedContext keeps the values of a request's context, such as its trace, without
+// its cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// drainContext detaches the drain from the request, so a client or proxy giving up on a
+// long drain does not leave load balancers half-drained. The drain is still bounded by MaxTimeout
+func (s *Server) drainContext(request *http.Request) (context.Context, context.CancelFunc) {
+	ctx := detachedContext{request.Context()}
+	if s.MaxTimeout > 0 {
+		return context.WithTimeout(ctx, s.MaxTimeout+drainGrace)
+	}
+	return context.WithCancel(ctx)
+}
+
+// acceptsJSON reports whether the client asked for a JSON drain result rather than "OK"
+func acceptsJSON(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), "application/json")
+}
+
+// drainOptions reads the dryRun and timeout query parameters. Timeouts may be
+// seconds or a duration such as 90s, and may not exceed MaxTimeout
+func (s *Server) drainOptions(request *http.Request) (deregister.DrainOptions, error) {
+	query := request.URL.Query()
+	options := deregister.DrainOptions{DryRun: s.DryRun}
+
+	if value := query.Get("dryRun"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid dryRun %q", value)
+		}
+		options.DryRun = options.DryRun || dryRun
+	}
+
+	if value := query.Get("timeout"); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil || timeout <= 0 {
+			return options, fmt.Errorf("invalid timeout %q, expected seconds or a duration such as 90s", value)
+		}
+		if s.MaxTimeout > 0 && timeout > s.MaxTimeout {
+			return options, fmt.Errorf("timeout %v exceeds the maximum of %v", timeout, s.MaxTimeout)
+		}
+		options.Timeout = timeout
+	}
+
+	return options, nil
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func writeJSON(response http.ResponseWriter, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(response).Encode(value); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
+
+func (s *Server) handleUndrain(response http.ResponseWriter, request *http.Request) {
+	if !requirePost(response, request) {
+		return
+	}
+
+	restorer, ok := s.Provider.(deregister.Restorer)
+	if !ok {
+		log.Warn().Msg("cloud provider does not support undrain")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	nodeName := request.URL.Query().Get("node")
+	action := policy.ActionUndrain
+	if s.DryRun {
+		action = policy.ActionDryRun
+	}
+
+	if !s.authorize(response, request, action, nodeName) {
+		return
+	}
+
+	err := restorer.RestoreNodeToLoadBalancer(request.Context(), nodeName)
+	if err == deregister.ErrNotSupported {
+		log.Warn().Msg("cloud provider does not support undrain")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("nodeName", nodeName).Msg("error restoring node to load balancers")
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(response, "OK")
+}
+
+// handleNodes serves the read-only GET /nodes/{node}/registrations, which needs the dry-run action
+func (s *Server) handleNodes(response http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, "/nodes/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "registrations" {
+		http.NotFound(response, request)
+		return
+	}
+	if !requireGet(response, request) {
+		return
+	}
+
+	nodeName := parts[0]
+	lister, ok := s.Provider.(deregister.RegistrationLister)
+	if !ok {
+		log.Warn().Msg("cloud provider does not support listing registrations")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	if !s.authorize(response, request, policy.ActionDryRun, nodeName) {
+		return
+	}
+
+	registrations, err := lister.ListRegistrations(request.Context(), nodeName)
+	if err == deregister.ErrNotSupported {
+		log.Warn().Msg("cloud provider does not support listing registrations")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("nodeName", nodeName).Msg("error listing node registrations")
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(response, registrations)
+}
+
+// handleClusters serves the read-only GET /clusters/{name}/loadbalancers, which needs the
+// dry-run action for the cluster
+func (s *Server) handleClusters(response http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, "/clusters/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "loadbalancers" {
+		http.NotFound(response, request)
+		return
+	}
+	if !requireGet(response, request) {
+		return
+	}
+
+	clusterName := parts[0]
+	lister, ok := s.Provider.(deregister.ClusterLister)
+	if !ok {
+		log.Warn().Msg("cloud provider does not support listing cluster load balancers")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	if !s.authorizeCluster(response, request, policy.ActionDryRun, clusterName) {
+		return
+	}
+
+	lbs, err := lister.ListClusterLoadBalancers(request.Context(), clusterName)
+	if err == deregister.ErrNotSupported {
+		log.Warn().Msg("cloud provider does not support listing cluster load balancers")
+		response.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if err == deregister.ErrClusterNotFound {
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("clusterName", clusterName).Msg("error listing cluster load balancers")
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(response, lbs)
+}
+
+// authorizeCluster checks the policy for a request about a whole cluster. Only rules which
+// select nodes by cluster alone can allow it
+func (s *Server) authorizeCluster(response http.ResponseWriter, request *http.Request, action string, clusterName string) bool {
+	if s.Policy == nil {
+		return true
+	}
+
+	identity := auth.IdentityFromContext(request.Context())
+	allowed, reason := s.Policy.Authorize(identity, action, &deregister.NodeInfo{ClusterName: clusterName})
+	if !allowed {
+		log.Warn().
+			Str("identity", identity.Name).
+			Str("action", action).
+			Str("clusterName", clusterName).
+			Str("reason", reason).
+			Msg("request denied by policy")
+		http.Error(response, reason, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// authorize checks the policy for the caller, writing a 403 with the reason if denied
+func (s *Server) authorize(response http.ResponseWriter, request *http.Request, action string, nodeName string) bool {
+	if s.Policy == nil {
+		return true
+	}
+
+	identity := auth.IdentityFromContext(request.Context())
+	describer, ok := s.Provider.(deregister.NodeDescriber)
+	if !ok {
+		log.Error().Msg("cloud provider cannot describe nodes, denying request")
+		http.Error(response, "cloud provider does not support authorization policies", http.StatusForbidden)
+		return false
+	}
+
+	node, err := describer.DescribeNode(request.Context(), nodeName)
+	if err == deregister.ErrNotSupported {
+		log.Error().Msg("cloud provider cannot describe nodes, denying request")
+		http.Error(response, "cloud provider does not support authorization policies", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Str("nodeName", nodeName).Msg("error describing node for authorization")
+		response.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	allowed, reason := s.Policy.Authorize(identity, action, node)
+	if !allowed {
+		log.Warn().
+			Str("identity", identity.Name).
+			Str("action", action).
+			Str("nodeName", nodeName).
+			Str("reason", reason).
+			Msg("request denied by policy")
+		http.Error(response, reason, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// traced continues any trace propagated in the request headers with a server span
+func traced(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		ctx, span := tracing.Start(tracing.ExtractHTTP(request), request.Method+" "+request.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.method", request.Method), attribute.String("http.target", request.URL.Path)))
+		defer span.End()
+		next(response, request.WithContext(ctx))
+	}
+}
+
+func requirePost(response http.ResponseWriter, request *http.Request) bool {
+	if request.Method != "POST" {
+		log.Warn().Str("Method", request.Method).Str("path", request.URL.Path).Msg("received unallowed method")
+		response.Header().Set("Allow", "POST")
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func requireGet(response http.ResponseWriter, request *http.Request) bool {
+	if request.Method != "GET" {
+		log.Warn().Str("Method", request.Method).Str("path", request.URL.Path).Msg("received unallowed method")
+		response.Header().Set("Allow", "GET")
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
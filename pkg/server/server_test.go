@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briankopp/hasta-la-vista/pkg/auth"
+	"github.com/briankopp/hasta-la-vista/pkg/deregister"
+	"github.com/briankopp/hasta-la-vista/pkg/policy"
+	"github.com/briankopp/hasta-la-vista/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeProvider struct {
+	drained  []string
+	restored []string
+	nodes    map[string]*deregister.NodeInfo
+	options  deregister.DrainOptions
+}
+
+func (p *fakeProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	p.drained = append(p.drained, nodeName)
+	p.options = deregister.OptionsFromContext(ctx)
+	return &deregister.DrainResult{NodeID: nodeName, DryRun: p.options.DryRun}, nil
+}
+
+func (p *fakeProvider) RestoreNodeToLoadBalancer(ctx context.Context, nodeName string) error {
+	p.restored = append(p.restored, nodeName)
+	return nil
+}
+
+func (p *fakeProvider) DescribeNode(ctx context.Context, nodeName string) (*deregister.NodeInfo, error) {
+	return p.nodes[nodeName], nil
+}
+
+func (p *fakeProvider) ListRegistrations(ctx context.Context, nodeName string) (*deregister.NodeRegistrations, error) {
+	return &deregister.NodeRegistrations{NodeID: nodeName, Registrations: []deregister.Registration{
+		{LoadBalancer: "alb-1", TargetGroup: "tg-1", Type: "elbv2", Port: 30080, State: "healthy"},
+	}}, nil
+}
+
+func (p *fakeProvider) ListClusterLoadBalancers(ctx context.Context, clusterName string) (*deregister.ClusterLoadBalancers, error) {
+	if clusterName != "staging" && clusterName != "prod" {
+		return nil, deregister.ErrClusterNotFound
+	}
+	return &deregister.ClusterLoadBalancers{ClusterName: clusterName, LoadBalancers: []deregister.LoadBalancerHealth{
+		{LoadBalancer: "elb-1", Type: "elbv1", Healthy: 2, StaleTargets: []string{"i-gone"}},
+	}}, nil
+}
+
+func newTestServer() (*Server, *fakeProvider) {
+	provider := &fakeProvider{nodes: map[string]*deregister.NodeInfo{
+		"i-staging": &deregister.NodeInfo{ID: "i-staging", ClusterName: "staging"},
+		"i-prod":    &deregister.NodeInfo{ID: "i-prod", ClusterName: "prod"},
+	}}
+
+	return &Server{
+		Provider:      provider,
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ci": "ci-token", "ops": "ops-token"}),
+		Policy: &policy.Policy{Rules: []policy.Rule{
+			{Identities: []string{"ops"}, Actions: []string{"*"}},
+			{Identities: []string{"ci"}, Actions: []string{policy.ActionDrain}, Clusters: []string{"staging"}},
+		}},
+	}, provider
+}
+
+func doRequest(handler http.Handler, method string, target string, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestDrainAuthorization(t *testing.T) {
+	cases := []struct {
+		Method string
+		Target string
+		Token  string
+		Code   int
+		Body   string
+	}{
+		{Method: "POST", Target: "/drain?node=i-staging", Token: "", Code: http.StatusUnauthorized},
+		{Method: "POST", Target: "/drain?node=i-staging&pw=ci-token", Token: "", Code: http.StatusUnauthorized},
+		{Method: "GET", Target: "/drain?node=i-staging", Token: "ci-token", Code: http.StatusMethodNotAllowed},
+		{Method: "POST", Target: "/drain?node=i-staging", Token: "ci-token", Code: http.StatusOK},
+		{Method: "POST", Target: "/drain?node=i-prod", Token: "ci-token", Code: http.StatusForbidden, Body: "cluster prod not allowed"},
+		{Method: "POST", Target: "/undrain?node=i-staging", Token: "ci-token", Code: http.StatusForbidden, Body: "action undrain not allowed"},
+		{Method: "POST", Target: "/undrain?node=i-prod", Token: "ops-token", Code: http.StatusOK},
+	}
+
+	for i, c := range cases {
+		s, _ := newTestServer()
+		recorder := doRequest(s.Handler(), c.Method, c.Target, c.Token)
+		if recorder.Code != c.Code {
+			t.Fatalf("%d failed - expected status %v, got %v", i, c.Code, recorder.Code)
+		}
+		if c.Body != "" && !strings.Contains(recorder.Body.String(), c.Body) {
+			t.Fatalf("%d failed - expected body to contain %q, got %q", i, c.Body, recorder.Body.String())
+		}
+	}
+}
+
+func TestDrainWithoutPolicy(t *testing.T) {
+	s, provider := newTestServer()
+	s.Policy = nil
+
+	recorder := doRequest(s.Handler(), "POST", "/drain?node=i-prod", "ci-token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("failed - expected status 200, got %v", recorder.Code)
+	}
+	if len(provider.drained) != 1 || provider.drained[0] != "i-prod" {
+		t.Fatalf("failed - expected i-prod to be drained, got %v", provider.drained)
+	}
+}
+
+func TestDebugConfigRequiresAuthentication(t *testing.T) {
+	s, _ := newTestServer()
+	s.Config = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(`{"version": 3}`))
+	})
+	handler := s.Handler()
+
+	if response := doRequest(handler, "GET", "/debug/config", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("failed - expected 401 without a token, got %v", response.Code)
+	}
+	if response := doRequest(handler, "GET", "/debug/config", "ci-token"); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "3") {
+		t.Fatalf("failed - expected the config version, got %v %v", response.Code, response.Body.String())
+	}
+}
+
+func TestDryRunRequiresDryRunAction(t *testing.T) {
+	s, provider := newTestServer()
+	s.DryRun = true
+
+	recorder := doRequest(s.Handler(), "POST", "/drain?node=i-staging", "ci-token")
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("failed - expected status 403, got %v", recorder.Code)
+	}
+	if len(provider.drained) != 0 {
+		t.Fatalf("failed - expected no drain, got %v", provider.drained)
+	}
+}
+
+func TestDrainRequestOverrides(t *testing.T) {
+	s, provider := newTestServer()
+	s.Policy = nil
+	s.MaxTimeout = 10 * time.Minute
+	handler := s.Handler()
+
+	recorder := doRequest(handler, "POST", "/drain?node=i-prod&dryRun=true&timeout=90s", "ops-token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("failed - expected status 200, got %v", recorder.Code)
+	}
+	if !provider.options.DryRun || provider.options.Timeout != 90*time.Second {
+		t.Fatalf("failed - expected dry-run with a 90s timeout, got %+v", provider.options)
+	}
+
+	var result deregister.DrainResult
+	if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil || !result.DryRun || result.NodeID != "i-prod" {
+		t.Fatalf("failed - expected the dry-run result as JSON, got %v %v", recorder.Body.String(), err)
+	}
+
+	recorder = doRequest(handler, "POST", "/drain?node=i-prod&timeout=120", "ops-token")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "OK" || provider.options.Timeout != 2*time.Minute || provider.options.DryRun {
+		t.Fatalf("failed - expected a 120s drain, got %v %+v", recorder.Code, provider.options)
+	}
+
+	for _, target := range []string{"/drain?node=i-prod&timeout=1h", "/drain?node=i-prod&timeout=abc", "/drain?node=i-prod&dryRun=maybe"} {
+		if recorder := doRequest(handler, "POST", target, "ops-token"); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("failed - expected status 400 for %v, got %v", target, recorder.Code)
+		}
+	}
+	if len(provider.drained) != 2 {
+		t.Fatalf("failed - expected rejected requests not to drain, got %v", provider.drained)
+	}
+}
+
+func TestDryRunParameterRequiresDryRunAction(t *testing.T) {
+	s, provider := newTestServer()
+
+	recorder := doRequest(s.Handler(), "POST", "/drain?node=i-staging&dryRun=true", "ci-token")
+	if recorder.Code != http.StatusForbidden || len(provider.drained) != 0 {
+		t.Fatalf("failed - expected a dry-run without the dry-run action to be denied, got %v", recorder.Code)
+	}
+}
+
+type contextCapturingProvider struct {
+	ctx context.Context
+	err error
+}
+
+func (p *contextCapturingProvider) DrainNodeFromLoadBalancer(ctx context.Context, nodeName string) (*deregister.DrainResult, error) {
+	p.ctx = ctx
+	p.err = ctx.Err()
+	return &deregister.DrainResult{NodeID: nodeName}, nil
+}
+
+func TestDrainContinuesPropagatedTrace(t *testing.T) {
+	tracing.Setup(context.Background(), "test")
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	provider := &contextCapturingProvider{}
+	s := &Server{
+		Provider:      provider,
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ops": "ops-token"}),
+	}
+
+	request := httptest.NewRequest("POST", "/drain?node=i-0123456789", nil)
+	request.Header.Set("Authorization", "Bearer ops-token")
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	response := httptest.NewRecorder()
+	s.Handler().ServeHTTP(response, request)
+
+	traceID := trace.SpanContextFromContext(provider.ctx).TraceID().String()
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("failed - expected drain to continue the propagated trace, got %v", traceID)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "POST /drain" {
+		t.Fatalf("failed - expected a single server span, got %v", spans)
+	}
+}
+
+func TestDrainOutlivesRequest(t *testing.T) {
+	provider := &contextCapturingProvider{}
+	s := &Server{
+		Provider:      provider,
+		Authenticator: auth.NewBearerAuthenticator(map[string]string{"ops": "ops-token"}),
+		MaxTimeout:    time.Minute,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest("POST", "/drain?node=i-0123456789", nil).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer ops-token")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), request)
+
+	if provider.err != nil {
+		t.Fatalf("failed - expected the drain not to be cancelled with the request, got %v", provider.err)
+	}
+	deadline, ok := provider.ctx.Deadline()
+	if !ok || time.Until(deadline) > time.Minute+drainGrace {
+		t.Fatalf("failed - expected the drain to be bounded by the max timeout, got %v %v", deadline, ok)
+	}
+}
+
+func TestDrainReturnsResultWhenJSONAccepted(t *testing.T) {
+	s, _ := newTestServer()
+	s.Policy = nil
+
+	request := httptest.NewRequest("POST", "/drain?node=i-prod", nil)
+	request.Header.Set("Authorization", "Bearer ops-token")
+	request.Header.Set("Accept", "application/json")
+	recorder := httptest.NewRecorder()
+	s.Handler().ServeHTTP(recorder, request)
+
+	var result deregister.DrainResult
+	if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil || result.NodeID != "i-prod" || result.DryRun {
+		t.Fatalf("failed - expected the drain result, got %v %+v", err, result)
+	}
+
+	if response := doRequest(s.Handler(), "POST", "/drain?node=i-prod", "ops-token"); response.Body.String() != "OK" {
+		t.Fatalf("failed - expected OK without an Accept header, got %q", response.Body.String())
+	}
+}
+
+func TestNodeRegistrations(t *testing.T) {
+	s, _ := newTestServer()
+	handler := s.Handler()
+
+	recorder := doRequest(handler, "GET", "/nodes/i-prod/registrations", "ops-token")
+	var registrations deregister.NodeRegistrations
+	if err := json.NewDecoder(recorder.Body).Decode(&registrations); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("failed - expected registrations as JSON, got %v %v", recorder.Code, err)
+	}
+	if registrations.NodeID != "i-prod" || len(registrations.Registrations) != 1 || registrations.Registrations[0].Port != 30080 {
+		t.Fatalf("failed - unexpected registrations %+v", registrations)
+	}
+
+	cases := []struct {
+		Method string
+		Target string
+		Token  string
+		Code   int
+	}{
+		{Method: "GET", Target: "/nodes/i-prod/registrations", Token: "", Code: http.StatusUnauthorized},
+		{Method: "GET", Target: "/nodes/i-staging/registrations", Token: "ci-token", Code: http.StatusForbidden},
+		{Method: "POST", Target: "/nodes/i-prod/registrations", Token: "ops-token", Code: http.StatusMethodNotAllowed},
+		{Method: "GET", Target: "/nodes/i-prod/health", Token: "ops-token", Code: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		if recorder := doRequest(handler, c.Method, c.Target, c.Token); recorder.Code != c.Code {
+			t.Fatalf("%d failed - expected status %v, got %v", i, c.Code, recorder.Code)
+		}
+	}
+
+	s.Provider = &contextCapturingProvider{}
+	if recorder := doRequest(s.Handler(), "GET", "/nodes/i-prod/registrations", "ops-token"); recorder.Code != http.StatusNotImplemented {
+		t.Fatalf("failed - expected 501 from a provider which cannot list registrations, got %v", recorder.Code)
+	}
+}
+
+func TestClusterLoadBalancers(t *testing.T) {
+	s, _ := newTestServer()
+	s.Policy.Rules = append(s.Policy.Rules, policy.Rule{Identities: []string{"ci"}, Actions: []string{policy.ActionDryRun}, Clusters: []string{"staging"}})
+	handler := s.Handler()
+
+	recorder := doRequest(handler, "GET", "/clusters/staging/loadbalancers", "ci-token")
+	var lbs deregister.ClusterLoadBalancers
+	if err := json.NewDecoder(recorder.Body).Decode(&lbs); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("failed - expected load balancers as JSON, got %v %v", recorder.Code, err)
+	}
+	if lbs.ClusterName != "staging" || len(lbs.LoadBalancers) != 1 || lbs.LoadBalancers[0].StaleTargets[0] != "i-gone" {
+		t.Fatalf("failed - unexpected load balancers %+v", lbs)
+	}
+
+	cases := []struct {
+		Method string
+		Target string
+		Token  string
+		Code   int
+	}{
+		{Method: "GET", Target: "/clusters/staging/loadbalancers", Token: "", Code: http.StatusUnauthorized},
+		{Method: "GET", Target: "/clusters/prod/loadbalancers", Token: "ci-token", Code: http.StatusForbidden},
+		{Method: "GET", Target: "/clusters/missing/loadbalancers", Token: "ops-token", Code: http.StatusNotFound},
+		{Method: "POST", Target: "/clusters/prod/loadbalancers", Token: "ops-token", Code: http.StatusMethodNotAllowed},
+		{Method: "GET", Target: "/clusters/prod/nodes", Token: "ops-token", Code: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		if recorder := doRequest(handler, c.Method, c.Target, c.Token); recorder.Code != c.Code {
+			t.Fatalf("%d failed - expected status %v, got %v", i, c.Code, recorder.Code)
+		}
+	}
+
+	s.Provider = &contextCapturingProvider{}
+	if recorder := doRequest(s.Handler(), "GET", "/clusters/prod/loadbalancers", "ops-token"); recorder.Code != http.StatusNotImplemented {
+		t.Fatalf("failed - expected 501 from a provider which cannot list load balancers, got %v", recorder.Code)
+	}
+}